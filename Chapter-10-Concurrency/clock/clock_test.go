@@ -0,0 +1,132 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"concurrency/leakcheck"
+)
+
+var epoch = time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+func TestFakeAfter(t *testing.T) {
+	f := NewFake(epoch)
+	c := f.After(time.Second)
+
+	f.Advance(999 * time.Millisecond)
+	select {
+	case <-c:
+		t.Fatal("After fired before its deadline")
+	default:
+	}
+
+	f.Advance(time.Millisecond)
+	select {
+	case now := <-c:
+		if !now.Equal(epoch.Add(time.Second)) {
+			t.Errorf("Expected: %v, got: %v", epoch.Add(time.Second), now)
+		}
+	default:
+		t.Fatal("After did not fire at its deadline")
+	}
+	if f.Waiters() != 0 {
+		t.Errorf("Expected: %d waiters, got: %d", 0, f.Waiters())
+	}
+}
+
+func TestFakeTimerStopReset(t *testing.T) {
+	f := NewFake(epoch)
+	timer := f.NewTimer(time.Second)
+
+	if !timer.Stop() {
+		t.Error("Expected Stop to report an active timer")
+	}
+	f.Advance(2 * time.Second)
+	select {
+	case <-timer.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+
+	if timer.Reset(time.Second) {
+		t.Error("Expected Reset to report an inactive timer")
+	}
+	f.Advance(time.Second)
+	select {
+	case <-timer.C():
+	default:
+		t.Fatal("reset timer did not fire")
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(epoch)
+	ticker := f.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for i := 1; i <= 3; i++ {
+		f.Advance(time.Second)
+		now := <-ticker.C()
+		if want := epoch.Add(time.Duration(i) * time.Second); !now.Equal(want) {
+			t.Errorf("Expected: %v, got: %v", want, now)
+		}
+	}
+
+	// Ticks are dropped, not queued, when the receiver falls behind.
+	f.Advance(5 * time.Second)
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Fatal("ticker queued more than one tick")
+	default:
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	defer leakcheck.Check(t)()
+	f := NewFake(epoch)
+	done := make(chan struct{})
+	go func() {
+		f.Sleep(3 * time.Second)
+		close(done)
+	}()
+
+	f.BlockUntil(1)
+	f.Advance(2 * time.Second)
+	select {
+	case <-done:
+		t.Fatal("Sleep returned early")
+	default:
+	}
+	f.Advance(time.Second)
+	<-done
+}
+
+func TestFakeNonPositive(t *testing.T) {
+	f := NewFake(epoch)
+	// Neither needs an Advance, as with the time package.
+	f.Sleep(0)
+	for _, d := range []time.Duration{0, -time.Second} {
+		select {
+		case now := <-f.After(d):
+			if !now.Equal(epoch) {
+				t.Errorf("Expected: %v, got: %v", epoch, now)
+			}
+		default:
+			t.Errorf("After(%v) did not fire at once", d)
+		}
+	}
+
+	timer := f.NewTimer(time.Second)
+	if !timer.Reset(0) {
+		t.Error("Expected Reset to report an active timer")
+	}
+	select {
+	case <-timer.C():
+	default:
+		t.Fatal("timer reset to zero did not fire")
+	}
+	if f.Waiters() != 0 {
+		t.Errorf("Expected: %d waiters, got: %d", 0, f.Waiters())
+	}
+}
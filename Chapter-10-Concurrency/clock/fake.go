@@ -0,0 +1,188 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock whose time only moves when Advance is called. Timers,
+// tickers and After channels fire in deadline order as time passes them.
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+// NewFake returns a Fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Sleep blocks until another goroutine advances the clock by at least d.
+// Like time.Sleep, it returns at once when d is zero or negative.
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+// NewTimer returns a timer that fires when the clock passes d from now, or
+// straight away when d is zero or negative, as time.NewTimer does.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{f: f, c: make(chan time.Time, 1)}
+	f.mu.Lock()
+	f.schedule(t, d)
+	f.mu.Unlock()
+	return t
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	t := &fakeTimer{f: f, c: make(chan time.Time, 1), period: d}
+	f.mu.Lock()
+	f.schedule(t, d)
+	f.mu.Unlock()
+	return fakeTicker{t}
+}
+
+// Advance moves the clock forward by d, firing every timer and ticker whose
+// deadline falls within the interval.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end := f.now.Add(d)
+	for len(f.waiters) > 0 && !f.waiters[0].when.After(end) {
+		t := f.waiters[0]
+		f.now = t.when
+		select {
+		case t.c <- f.now:
+		default:
+		}
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+			f.sortWaiters()
+		} else {
+			f.remove(t)
+		}
+	}
+	f.now = end
+}
+
+// BlockUntil waits until at least n timers, tickers or sleepers are pending.
+// Tests use it to make sure a goroutine has reached its Sleep or After call
+// before advancing the clock.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// Waiters returns the number of pending timers, tickers and sleepers.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+// schedule makes t fire d from now. A one-shot timer with nothing left to
+// wait for fires on the spot instead, so nobody has to Advance for it.
+func (f *Fake) schedule(t *fakeTimer, d time.Duration) {
+	if d <= 0 && t.period == 0 {
+		f.remove(t)
+		select {
+		case t.c <- f.now:
+		default:
+		}
+		return
+	}
+	t.when = f.now.Add(d)
+	if !f.pending(t) {
+		f.waiters = append(f.waiters, t)
+		f.cond.Broadcast()
+	}
+	f.sortWaiters()
+}
+
+func (f *Fake) remove(t *fakeTimer) bool {
+	for i, w := range f.waiters {
+		if w == t {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fake) pending(t *fakeTimer) bool {
+	for _, w := range f.waiters {
+		if w == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fake) sortWaiters() {
+	sort.SliceStable(f.waiters, func(i, j int) bool {
+		return f.waiters[i].when.Before(f.waiters[j].when)
+	})
+}
+
+type fakeTimer struct {
+	f      *Fake
+	c      chan time.Time
+	when   time.Time
+	period time.Duration
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	return t.f.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	active := t.f.pending(t)
+	t.f.schedule(t, d)
+	return active
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
+
+func (t fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("clock: non-positive interval for Ticker.Reset")
+	}
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	t.period = d
+	t.f.schedule(t.fakeTimer, d)
+}
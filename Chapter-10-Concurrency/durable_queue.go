@@ -0,0 +1,64 @@
+package main
+
+import (
+	"concurrency/clock"
+	"concurrency/examples"
+	"concurrency/jobqueue"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Enqueues the examples.Count tasks from main.go into a file-backed queue
+// and works through them. Kill the program half way and run it again: the
+// tasks that were not acknowledged are picked up where they were left.
+
+func pause() time.Duration {
+	return 100 * time.Millisecond
+}
+
+func main() {
+	dir := flag.String("dir", "jobs", "Directory holding the queue files")
+	workers := flag.Int("workers", 3, "Number of workers")
+	flag.Parse()
+
+	q, err := jobqueue.Open(*dir, jobqueue.Options{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer q.Close()
+
+	if len(q.Pending()) == 0 {
+		for i := 1; i <= 10; i++ {
+			q.Enqueue([]byte(strconv.Itoa(i)))
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, err := q.Lease()
+				if err != nil {
+					return
+				}
+				n, err := strconv.Atoi(string(job.Payload))
+				if err != nil {
+					q.Nack(job.ID, job.Lease, err)
+					continue
+				}
+				examples.Count(os.Stdout, n, clock.New(), pause)
+				q.Ack(job.ID, job.Lease)
+			}
+		}()
+	}
+	wg.Wait()
+
+	fmt.Println("dead letters:", len(q.DeadLetters()))
+}
@@ -0,0 +1,93 @@
+// Package examples holds the goroutine and channel examples run by the
+// chapter's programs, taking a clock.Clock and an io.Writer so that tests
+// can drive them with a clock.Fake and read what they print.
+package examples
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"concurrency/clock"
+)
+
+// Count prints n : 1 to n : 10, pausing for pause() between lines.
+func Count(w io.Writer, n int, clk clock.Clock, pause func() time.Duration) {
+	for i := 1; i <= 10; i++ {
+		fmt.Fprintln(w, n, ":", i)
+		clk.Sleep(pause())
+	}
+}
+
+// Channels provide a way for two goroutines to communicate with each other
+// and synchronize their execution.
+
+// Pinger sends "ping" on c forever.
+func Pinger(c chan<- string) {
+	for {
+		c <- "ping"
+	}
+}
+
+// Ponger sends "pong" on c forever.
+func Ponger(c chan<- string) {
+	for {
+		c <- "pong"
+	}
+}
+
+// Printer prints what comes on c, a second apart, until c is closed.
+func Printer(w io.Writer, c <-chan string, clk clock.Clock) {
+	for msg := range c {
+		fmt.Fprintln(w, msg)
+		clk.Sleep(1 * time.Second)
+	}
+}
+
+// Select starts two senders, one every two seconds and one every three,
+// and a receiver that prints whichever message comes first, or "timeout"
+// after a second without one. They all stop when done is closed.
+//
+// There is no default case: with one the receiver spins printing
+// "default" and never waits long enough to time out.
+func Select(w io.Writer, clk clock.Clock, done <-chan struct{}) {
+	c1 := make(chan string)
+	c2 := make(chan string)
+
+	send := func(c chan<- string, msg string, every time.Duration) {
+		for {
+			select {
+			case c <- msg:
+			case <-done:
+				return
+			}
+			select {
+			case <-clk.After(every):
+			case <-done:
+				return
+			}
+		}
+	}
+	go send(c1, "from c1", 2*time.Second)
+	go send(c2, "from c2", 3*time.Second)
+
+	go func() {
+		for {
+			// A timer, unlike After, can be stopped once a message wins,
+			// so only one is ever pending.
+			timeout := clk.NewTimer(time.Second)
+			var msg string
+			select {
+			case msg = <-c1:
+			case msg = <-c2:
+			case <-timeout.C():
+				msg = "timeout"
+			case <-done:
+				timeout.Stop()
+				return
+			}
+			timeout.Stop()
+			fmt.Fprintln(w, msg)
+		}
+	}()
+}
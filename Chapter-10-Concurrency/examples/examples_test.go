@@ -0,0 +1,89 @@
+package examples
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"concurrency/clock"
+	"concurrency/leakcheck"
+)
+
+var epoch = time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+// lines is a Writer handing each printed line to the test.
+type lines chan string
+
+func (l lines) Write(p []byte) (int, error) {
+	l <- strings.TrimSuffix(string(p), "\n")
+	return len(p), nil
+}
+
+func TestCount(t *testing.T) {
+	// A pause of zero must not wait for the clock to move.
+	var buf bytes.Buffer
+	Count(&buf, 3, clock.NewFake(epoch), func() time.Duration { return 0 })
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(got) != 10 || got[0] != "3 : 1" || got[9] != "3 : 10" {
+		t.Errorf("Expected: 3 : 1 to 3 : 10, got: %q", got)
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	defer leakcheck.Check(t)()
+	f := clock.NewFake(epoch)
+	out := make(lines, 10)
+	c := make(chan string)
+	done := make(chan struct{})
+	go func() {
+		Printer(out, c, f)
+		close(done)
+	}()
+
+	c <- "ping"
+	if v := <-out; v != "ping" {
+		t.Errorf("Expected: %s, got: %s", "ping", v)
+	}
+	f.BlockUntil(1)
+	select {
+	case c <- "pong":
+		t.Fatal("Printer took a message before its second was up")
+	default:
+	}
+	f.Advance(time.Second)
+	c <- "pong"
+	if v := <-out; v != "pong" {
+		t.Errorf("Expected: %s, got: %s", "pong", v)
+	}
+	close(c)
+	f.BlockUntil(1)
+	f.Advance(time.Second)
+	<-done
+}
+
+func TestSelect(t *testing.T) {
+	defer leakcheck.Check(t)()
+	f := clock.NewFake(epoch)
+	out := make(lines, 10)
+	done := make(chan struct{})
+	defer close(done)
+	Select(out, f, done)
+
+	// Both senders go first, in either order.
+	got := map[string]bool{<-out: true, <-out: true}
+	if !got["from c1"] || !got["from c2"] {
+		t.Errorf("Expected: from c1 and from c2, got: %v", got)
+	}
+
+	// The receiver's timeout for its next message and the two senders'
+	// pauses.
+	f.BlockUntil(3)
+	if n := f.Waiters(); n != 3 {
+		t.Fatalf("Expected: %d waiters, got: %d", 3, n)
+	}
+	f.Advance(time.Second)
+	if v := <-out; v != "timeout" {
+		t.Errorf("Expected: %s, got: %s", "timeout", v)
+	}
+}
@@ -0,0 +1,415 @@
+package jobqueue
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"concurrency/clock"
+)
+
+var (
+	// ErrEmpty is returned by Lease when no job is ready.
+	ErrEmpty = errors.New("jobqueue: no job ready")
+	// ErrNotLeased is returned when acknowledging a job that is not leased.
+	ErrNotLeased = errors.New("jobqueue: job is not leased")
+	// ErrLeaseLost is returned when acknowledging a job whose lease ran
+	// out and that has since been leased to someone else.
+	ErrLeaseLost = errors.New("jobqueue: lease lost to another worker")
+	// ErrNotFound is returned when a job ID is unknown.
+	ErrNotFound = errors.New("jobqueue: job not found")
+	// ErrClosed is returned by every operation after Close.
+	ErrClosed = errors.New("jobqueue: closed")
+)
+
+// State is where a job is in its lifecycle.
+type State string
+
+const (
+	Ready  State = "ready"
+	Leased State = "leased"
+	Dead   State = "dead"
+)
+
+// Job is a queued unit of work. Payload is opaque to the queue.
+type Job struct {
+	ID          uint64
+	Payload     []byte
+	State       State
+	Attempts    int
+	LastError   string
+	EnqueuedAt  time.Time
+	LeasedUntil time.Time
+	// Lease identifies the current lease; Ack and Nack must present it.
+	Lease uint64
+}
+
+// Options configures a Queue. Zero values pick the defaults.
+type Options struct {
+	// MaxAttempts is how many leases a job gets before it is dead-lettered.
+	MaxAttempts int
+	// LeaseTimeout is how long a worker may hold a job before it is
+	// handed to someone else.
+	LeaseTimeout time.Duration
+	// CheckpointEvery compacts the log after this many records.
+	CheckpointEvery int
+	// NoSync skips the fsync after every log write.
+	NoSync bool
+	Clock  clock.Clock
+	// ErrorLog gets checkpoint failures, which leave the log as it is
+	// and so lose nothing; the standard logger if nil.
+	ErrorLog *log.Logger
+}
+
+func (o *Options) setDefaults() {
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 3
+	}
+	if o.LeaseTimeout <= 0 {
+		o.LeaseTimeout = 30 * time.Second
+	}
+	if o.CheckpointEvery <= 0 {
+		o.CheckpointEvery = 1000
+	}
+	if o.Clock == nil {
+		o.Clock = clock.New()
+	}
+	if o.ErrorLog == nil {
+		o.ErrorLog = log.Default()
+	}
+}
+
+// Queue is a durable FIFO job queue stored in a directory. Every change is
+// appended to a log before it is applied; Checkpoint folds the log into a
+// snapshot. Jobs that were leased when the process died are ready again
+// after Open.
+type Queue struct {
+	mu     sync.Mutex
+	opts   Options
+	dir    string
+	log    *os.File
+	seq    uint64 // sequence number of the last record written
+	nextID uint64
+	jobs   map[uint64]*Job
+	ready  []uint64
+	// leases in the order they were handed out, and so of their deadlines;
+	// entries for jobs since finished or leased again are skipped.
+	leases []lease
+	// records written since the last checkpoint
+	pending int
+	closed  bool
+}
+
+type lease struct {
+	id, token uint64
+}
+
+// Open loads the queue stored in dir, creating the directory if needed.
+func Open(dir string, opts Options) (*Queue, error) {
+	opts.setDefaults()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	q := &Queue{
+		opts:   opts,
+		dir:    dir,
+		nextID: 1,
+		jobs:   make(map[uint64]*Job),
+	}
+	if err := q.loadCheckpoint(); err != nil {
+		return nil, err
+	}
+	if err := q.replay(); err != nil {
+		return nil, err
+	}
+	// Whoever held a lease before the restart is gone. The release is
+	// logged, or another crash would replay the jobs as still leased.
+	for _, id := range q.sortedIDs() {
+		if q.jobs[id].State != Leased {
+			continue
+		}
+		rec := record{Op: opExpire, ID: id}
+		if err := q.write(rec); err != nil {
+			q.Close()
+			return nil, err
+		}
+		q.apply(rec)
+	}
+	q.leases = nil
+	return q, nil
+}
+
+// Enqueue appends a job and returns its ID.
+func (q *Queue) Enqueue(payload []byte) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, ErrClosed
+	}
+	rec := record{Op: opEnqueue, ID: q.nextID, Payload: payload, Time: q.opts.Clock.Now().UnixNano()}
+	if err := q.write(rec); err != nil {
+		return 0, err
+	}
+	q.apply(rec)
+	q.maybeCheckpoint()
+	return rec.ID, nil
+}
+
+// Lease hands out the oldest ready job for LeaseTimeout. The worker must
+// Ack or Nack it before the lease runs out, or it is offered again and
+// the late Ack or Nack fails with ErrLeaseLost.
+func (q *Queue) Lease() (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return Job{}, ErrClosed
+	}
+	if err := q.reclaim(); err != nil {
+		return Job{}, err
+	}
+	if len(q.ready) == 0 {
+		return Job{}, ErrEmpty
+	}
+	until := q.opts.Clock.Now().Add(q.opts.LeaseTimeout)
+	rec := record{Op: opLease, ID: q.ready[0], Time: until.UnixNano()}
+	if err := q.write(rec); err != nil {
+		return Job{}, err
+	}
+	q.apply(rec)
+	job := *q.jobs[rec.ID]
+	q.maybeCheckpoint()
+	return job, nil
+}
+
+// Ack marks a leased job as done and removes it from the queue. lease is
+// the Job.Lease handed out by Lease.
+func (q *Queue) Ack(id, lease uint64) error {
+	return q.finish(record{Op: opAck, ID: id}, lease)
+}
+
+// Nack reports a failed attempt. The job is retried unless it has used up
+// MaxAttempts, in which case it moves to the dead-letter list.
+func (q *Queue) Nack(id, lease uint64, cause error) error {
+	rec := record{Op: opNack, ID: id}
+	if cause != nil {
+		rec.Error = cause.Error()
+	}
+	return q.finish(rec, lease)
+}
+
+func (q *Queue) finish(rec record, lease uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	job, ok := q.jobs[rec.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if job.State != Leased {
+		return ErrNotLeased
+	}
+	if job.Lease != lease {
+		return ErrLeaseLost
+	}
+	if err := q.write(rec); err != nil {
+		return err
+	}
+	q.apply(rec)
+	q.maybeCheckpoint()
+	return nil
+}
+
+// DeadLetters returns the jobs that ran out of attempts, oldest first.
+func (q *Queue) DeadLetters() []Job {
+	return q.list(Dead)
+}
+
+// Pending returns the jobs that are ready or leased, oldest first.
+func (q *Queue) Pending() []Job {
+	return append(q.list(Ready), q.list(Leased)...)
+}
+
+func (q *Queue) list(state State) []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var jobs []Job
+	for _, id := range q.sortedIDs() {
+		if job := q.jobs[id]; job.State == state {
+			jobs = append(jobs, *job)
+		}
+	}
+	return jobs
+}
+
+// Requeue moves a dead-lettered job back to the ready list with a fresh
+// set of attempts.
+func (q *Queue) Requeue(id uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	job, ok := q.jobs[id]
+	if !ok || job.State != Dead {
+		return ErrNotFound
+	}
+	rec := record{Op: opRequeue, ID: id}
+	if err := q.write(rec); err != nil {
+		return err
+	}
+	q.apply(rec)
+	q.maybeCheckpoint()
+	return nil
+}
+
+// Checkpoint writes the current state to a snapshot and truncates the log.
+func (q *Queue) Checkpoint() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	return q.checkpoint()
+}
+
+// Close flushes the log and releases the files. Leased jobs become ready
+// again the next time the queue is opened.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	if q.log == nil {
+		return nil
+	}
+	if err := q.log.Sync(); err != nil {
+		q.log.Close()
+		return err
+	}
+	return q.log.Close()
+}
+
+// reclaim gives expired leases back to the ready list, or to the dead
+// letters if they have no attempts left. Leases run out in the order they
+// were handed out, so it stops at the first one still running.
+func (q *Queue) reclaim() error {
+	now := q.opts.Clock.Now()
+	for len(q.leases) > 0 {
+		l := q.leases[0]
+		job, ok := q.jobs[l.id]
+		if ok && job.State == Leased && job.Lease == l.token {
+			if now.Before(job.LeasedUntil) {
+				return nil
+			}
+			rec := record{Op: opExpire, ID: l.id}
+			if err := q.write(rec); err != nil {
+				return err
+			}
+			q.apply(rec)
+		}
+		q.leases = q.leases[1:]
+	}
+	return nil
+}
+
+// apply changes the in-memory state for a record that is already on disk
+// (or being replayed from it).
+func (q *Queue) apply(rec record) {
+	if rec.Op == opEnqueue {
+		q.jobs[rec.ID] = &Job{
+			ID:         rec.ID,
+			Payload:    rec.Payload,
+			State:      Ready,
+			EnqueuedAt: time.Unix(0, rec.Time),
+		}
+		q.ready = append(q.ready, rec.ID)
+		if rec.ID >= q.nextID {
+			q.nextID = rec.ID + 1
+		}
+		return
+	}
+
+	job, ok := q.jobs[rec.ID]
+	if !ok {
+		return
+	}
+	switch rec.Op {
+	case opLease:
+		q.removeReady(rec.ID)
+		job.State = Leased
+		job.Attempts++
+		job.LeasedUntil = time.Unix(0, rec.Time)
+		// The record's sequence number is unique to this lease.
+		job.Lease = q.seq
+		q.leases = append(q.leases, lease{id: rec.ID, token: job.Lease})
+	case opAck:
+		delete(q.jobs, rec.ID)
+		q.removeReady(rec.ID)
+	case opNack:
+		job.LastError = rec.Error
+		q.release(job)
+	case opExpire:
+		job.LastError = "lease expired"
+		q.release(job)
+	case opRequeue:
+		job.State = Ready
+		job.Attempts = 0
+		q.ready = append(q.ready, rec.ID)
+	}
+}
+
+func (q *Queue) release(job *Job) {
+	job.LeasedUntil = time.Time{}
+	if job.Attempts >= q.opts.MaxAttempts {
+		job.State = Dead
+		return
+	}
+	job.State = Ready
+	q.ready = append(q.ready, job.ID)
+}
+
+func (q *Queue) removeReady(id uint64) {
+	for i, r := range q.ready {
+		if r == id {
+			q.ready = append(q.ready[:i], q.ready[i+1:]...)
+			return
+		}
+	}
+}
+
+func (q *Queue) sortedIDs() []uint64 {
+	ids := make([]uint64, 0, len(q.jobs))
+	for id := range q.jobs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// maybeCheckpoint compacts the log once it is long enough. The change that
+// triggered it is already safe in the log, so a failure is only logged and
+// the checkpoint tried again after the next change.
+func (q *Queue) maybeCheckpoint() {
+	if q.pending < q.opts.CheckpointEvery {
+		return
+	}
+	if err := q.checkpoint(); err != nil {
+		q.opts.ErrorLog.Printf("jobqueue: checkpoint failed: %v", err)
+	}
+}
+
+func (q *Queue) logPath() string {
+	return filepath.Join(q.dir, "queue.log")
+}
+
+func (q *Queue) checkpointPath() string {
+	return filepath.Join(q.dir, "checkpoint.json")
+}
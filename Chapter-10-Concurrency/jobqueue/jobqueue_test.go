@@ -0,0 +1,264 @@
+package jobqueue
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"concurrency/clock"
+)
+
+func open(t *testing.T, dir string, opts Options) *Queue {
+	q, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestLeaseAck(t *testing.T) {
+	q := open(t, t.TempDir(), Options{})
+	defer q.Close()
+
+	for _, p := range []string{"1", "2"} {
+		if _, err := q.Enqueue([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job, err := q.Lease()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(job.Payload) != "1" || job.Attempts != 1 {
+		t.Errorf("Expected: payload 1 on attempt 1, got: %q on attempt %d", job.Payload, job.Attempts)
+	}
+	if err := q.Ack(job.ID, job.Lease); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack(job.ID, job.Lease); err != ErrNotFound {
+		t.Errorf("Expected: %v, got: %v", ErrNotFound, err)
+	}
+
+	job, _ = q.Lease()
+	if string(job.Payload) != "2" {
+		t.Errorf("Expected: %s, got: %s", "2", job.Payload)
+	}
+	if _, err := q.Lease(); err != ErrEmpty {
+		t.Errorf("Expected: %v, got: %v", ErrEmpty, err)
+	}
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	q := open(t, t.TempDir(), Options{MaxAttempts: 2})
+	defer q.Close()
+
+	id, _ := q.Enqueue([]byte("flaky"))
+	for i := 0; i < 2; i++ {
+		job, err := q.Lease()
+		if err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+		q.Nack(job.ID, job.Lease, errors.New("boom"))
+	}
+	if _, err := q.Lease(); err != ErrEmpty {
+		t.Errorf("Expected: %v, got: %v", ErrEmpty, err)
+	}
+
+	dead := q.DeadLetters()
+	if len(dead) != 1 || dead[0].ID != id || dead[0].LastError != "boom" {
+		t.Fatalf("Expected: job %d dead with error boom, got: %+v", id, dead)
+	}
+	if err := q.Requeue(id); err != nil {
+		t.Fatal(err)
+	}
+	if job, err := q.Lease(); err != nil || job.Attempts != 1 {
+		t.Errorf("Expected: requeued job on attempt 1, got: %+v, %v", job, err)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	clk := clock.NewFake(time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC))
+	q := open(t, t.TempDir(), Options{LeaseTimeout: time.Minute, Clock: clk})
+	defer q.Close()
+
+	q.Enqueue([]byte("x"))
+	first, _ := q.Lease()
+	if _, err := q.Lease(); err != ErrEmpty {
+		t.Errorf("Expected: %v, got: %v", ErrEmpty, err)
+	}
+
+	clk.Advance(time.Minute)
+	second, err := q.Lease()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID != first.ID || second.Attempts != 2 {
+		t.Errorf("Expected: job %d on attempt 2, got: %+v", first.ID, second)
+	}
+	// The first worker's lease is gone; only the second may finish the job.
+	if err := q.Ack(first.ID, first.Lease); err != ErrLeaseLost {
+		t.Errorf("Expected: %v, got: %v", ErrLeaseLost, err)
+	}
+	if err := q.Nack(first.ID, first.Lease, errors.New("late")); err != ErrLeaseLost {
+		t.Errorf("Expected: %v, got: %v", ErrLeaseLost, err)
+	}
+	if err := q.Ack(second.ID, second.Lease); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	type testPair struct {
+		name            string
+		checkpointEvery int
+	}
+	tests := []testPair{
+		{"log only", 1000},
+		{"with checkpoints", 2},
+	}
+
+	for _, pair := range tests {
+		dir := t.TempDir()
+		q := open(t, dir, Options{CheckpointEvery: pair.checkpointEvery})
+		for _, p := range []string{"a", "b", "c", "d"} {
+			q.Enqueue([]byte(p))
+		}
+		a, _ := q.Lease()
+		q.Ack(a.ID, a.Lease)
+		b, _ := q.Lease() // still leased when the process "dies"
+		q.Close()
+
+		q = open(t, dir, Options{CheckpointEvery: pair.checkpointEvery})
+		var got []string
+		for {
+			job, err := q.Lease()
+			if err == ErrEmpty {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(job.Payload))
+			if job.ID == b.ID && job.Attempts != 2 {
+				t.Errorf("%s: Expected: attempt 2 for the interrupted job, got: %d", pair.name, job.Attempts)
+			}
+			q.Ack(job.ID, job.Lease)
+		}
+		want := "cdb"
+		if s := strings.Join(got, ""); s != want {
+			t.Errorf("%s: Expected: %s, got: %s", pair.name, want, s)
+		}
+		id, _ := q.Enqueue([]byte("e"))
+		if id != 5 {
+			t.Errorf("%s: Expected: id %d, got: %d", pair.name, 5, id)
+		}
+		q.Close()
+	}
+}
+
+func TestTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	q := open(t, dir, Options{})
+	q.Enqueue([]byte("kept"))
+	q.Close()
+
+	f, err := os.OpenFile(dir+"/queue.log", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":2,"op":"enq`)
+	f.Close()
+
+	q = open(t, dir, Options{})
+	defer q.Close()
+	if n := len(q.Pending()); n != 1 {
+		t.Errorf("Expected: %d pending, got: %d", 1, n)
+	}
+	if _, err := q.Enqueue([]byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(q.Pending()); n != 2 {
+		t.Errorf("Expected: %d pending, got: %d", 2, n)
+	}
+}
+
+func TestReclaimOrder(t *testing.T) {
+	clk := clock.NewFake(time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC))
+	q := open(t, t.TempDir(), Options{LeaseTimeout: time.Minute, Clock: clk})
+	defer q.Close()
+
+	for _, p := range []string{"a", "b", "c"} {
+		q.Enqueue([]byte(p))
+	}
+	a, _ := q.Lease()
+	clk.Advance(30 * time.Second)
+	b, _ := q.Lease()
+	q.Ack(a.ID, a.Lease)
+
+	// a is done and b has time left: c comes next.
+	clk.Advance(40 * time.Second)
+	if job, err := q.Lease(); err != nil || string(job.Payload) != "c" {
+		t.Fatalf("Expected: c, got: %q, %v", job.Payload, err)
+	}
+	clk.Advance(20 * time.Second)
+	if job, err := q.Lease(); err != nil || job.ID != b.ID || job.Attempts != 2 {
+		t.Errorf("Expected: job %d on attempt 2, got: %+v, %v", b.ID, job, err)
+	}
+}
+
+func TestReleaseLogged(t *testing.T) {
+	dir := t.TempDir()
+	q := open(t, dir, Options{})
+	q.Enqueue([]byte("x"))
+	q.Lease()
+	q.Close()
+
+	// Each restart finds the release of the one before in the log.
+	for i := 0; i < 2; i++ {
+		q = open(t, dir, Options{})
+		pending := q.Pending()
+		q.Close()
+		if len(pending) != 1 || pending[0].State != Ready || pending[0].Attempts != 1 {
+			t.Fatalf("Expected: the job ready after 1 attempt, got: %+v", pending)
+		}
+	}
+	bs, _ := os.ReadFile(dir + "/queue.log")
+	if n := strings.Count(string(bs), `"op":"expire"`); n != 1 {
+		t.Errorf("Expected: %d logged release, got: %d in %s", 1, n, bs)
+	}
+}
+
+func TestCheckpointFailure(t *testing.T) {
+	dir := t.TempDir()
+	var logs bytes.Buffer
+	q := open(t, dir, Options{CheckpointEvery: 1, ErrorLog: log.New(&logs, "", 0)})
+	defer q.Close()
+
+	// A directory in the way of the snapshot's temporary file.
+	if err := os.Mkdir(dir+"/checkpoint.json.tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	id, err := q.Enqueue([]byte("kept"))
+	if err != nil || id != 1 {
+		t.Fatalf("Expected: id 1 <nil>, got: %d %v", id, err)
+	}
+	if job, err := q.Lease(); err != nil || job.ID != id {
+		t.Fatalf("Expected: job %d <nil>, got: %+v %v", id, job, err)
+	}
+	if !strings.Contains(logs.String(), "jobqueue: checkpoint failed") {
+		t.Errorf("Expected: the failure logged, got: %q", logs.String())
+	}
+
+	os.Remove(dir + "/checkpoint.json.tmp")
+	q.Enqueue([]byte("next"))
+	q.Close()
+	q = open(t, dir, Options{})
+	defer q.Close()
+	if n := len(q.Pending()); n != 2 {
+		t.Errorf("Expected: %d pending, got: %d", 2, n)
+	}
+}
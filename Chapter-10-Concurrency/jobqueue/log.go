@@ -0,0 +1,173 @@
+package jobqueue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	opEnqueue = "enqueue"
+	opLease   = "lease"
+	opAck     = "ack"
+	opNack    = "nack"
+	opExpire  = "expire"
+	opRequeue = "requeue"
+)
+
+// record is one line of the append-only log. Seq orders records across
+// checkpoints: replay skips anything the snapshot already contains.
+type record struct {
+	Seq     uint64 `json:"seq"`
+	Op      string `json:"op"`
+	ID      uint64 `json:"id"`
+	Payload []byte `json:"payload,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Time is the enqueue time for enqueue records and the lease deadline
+	// for lease records, in Unix nanoseconds.
+	Time int64 `json:"time,omitempty"`
+}
+
+// snapshot is the checkpoint file: everything up to and including Seq.
+type snapshot struct {
+	Seq    uint64   `json:"seq"`
+	NextID uint64   `json:"next_id"`
+	Jobs   []Job    `json:"jobs"`
+	Ready  []uint64 `json:"ready"`
+}
+
+func (q *Queue) write(rec record) error {
+	if q.log == nil {
+		f, err := os.OpenFile(q.logPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		q.log = f
+	}
+	rec.Seq = q.seq + 1
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := q.log.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if !q.opts.NoSync {
+		if err := q.log.Sync(); err != nil {
+			return err
+		}
+	}
+	q.seq = rec.Seq
+	q.pending++
+	return nil
+}
+
+func (q *Queue) loadCheckpoint() error {
+	bs, err := os.ReadFile(q.checkpointPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(bs, &snap); err != nil {
+		return fmt.Errorf("jobqueue: corrupt checkpoint: %v", err)
+	}
+	q.seq = snap.Seq
+	q.nextID = snap.NextID
+	for i := range snap.Jobs {
+		job := snap.Jobs[i]
+		q.jobs[job.ID] = &job
+	}
+	q.ready = snap.Ready
+	return nil
+}
+
+// replay applies the log records written after the last checkpoint. A torn
+// final line, left by a crash in the middle of a write, is cut off.
+func (q *Queue) replay() error {
+	f, err := os.OpenFile(q.logPath(), os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				return f.Truncate(offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("jobqueue: corrupt log record at offset %d: %v", offset, err)
+		}
+		offset += int64(len(line))
+		if rec.Seq <= q.seq {
+			continue
+		}
+		q.seq = rec.Seq
+		q.pending++
+		q.apply(rec)
+	}
+}
+
+// checkpoint writes the snapshot to a temporary file, renames it into
+// place and only then truncates the log. A crash between the two steps
+// is harmless because replay skips records the snapshot already covers.
+func (q *Queue) checkpoint() error {
+	snap := snapshot{Seq: q.seq, NextID: q.nextID, Ready: q.ready}
+	for _, id := range q.sortedIDs() {
+		snap.Jobs = append(snap.Jobs, *q.jobs[id])
+	}
+	bs, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmp := q.checkpointPath() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(bs); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.checkpointPath()); err != nil {
+		return err
+	}
+
+	if q.log != nil {
+		if err := q.log.Close(); err != nil {
+			return err
+		}
+		q.log = nil
+	}
+	if err := os.Truncate(q.logPath(), 0); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	q.pending = 0
+	return nil
+}
@@ -0,0 +1,118 @@
+package leakcheck
+
+import (
+	"strings"
+	"time"
+)
+
+// TB is the part of testing.TB the checker needs.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Option changes what Check reports.
+type Option func(*config)
+
+type config struct {
+	grace   time.Duration
+	ignored []string
+}
+
+// GracePeriod sets how long Check keeps retrying before it reports the
+// goroutines that are still running. The default is one second.
+func GracePeriod(d time.Duration) Option {
+	return func(c *config) {
+		c.grace = d
+	}
+}
+
+// IgnoreFunction skips goroutines with fn anywhere on their stack, e.g.
+// "net/http.(*persistConn).readLoop".
+func IgnoreFunction(fn string) Option {
+	return func(c *config) {
+		c.ignored = append(c.ignored, fn)
+	}
+}
+
+// Goroutines the runtime and the testing package start on their own.
+var background = []string{
+	"os/signal.signal_recv",
+	"os/signal.loop",
+	"runtime.ensureSigM",
+	"testing.RunTests",
+	"testing.(*T).Run",
+	"testing.(*T).Parallel",
+	"testing.(*M).startAlarm",
+	"testing.runTests.func1",
+	"testing.tRunner.func1",
+}
+
+// Check records the goroutines running now and returns a function that
+// fails t if any goroutine started since is still running once the grace
+// period is over. Use it as the first line of a test:
+//
+//	defer leakcheck.Check(t)()
+func Check(t TB, opts ...Option) func() {
+	c := config{grace: time.Second}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	before := map[int]bool{}
+	for _, g := range dump() {
+		before[g.id] = true
+	}
+
+	return func() {
+		t.Helper()
+		deadline := time.Now().Add(c.grace)
+		wait := time.Millisecond
+		for {
+			leaked := c.leaked(before)
+			if len(leaked) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				stacks := make([]string, len(leaked))
+				for i, g := range leaked {
+					stacks[i] = g.stack
+				}
+				t.Errorf("leakcheck: %d goroutine(s) leaked:\n\n%s", len(leaked), strings.Join(stacks, "\n\n"))
+				return
+			}
+			time.Sleep(wait)
+			if wait < 100*time.Millisecond {
+				wait *= 2
+			}
+		}
+	}
+}
+
+func (c *config) leaked(before map[int]bool) []goroutine {
+	var leaked []goroutine
+	for _, g := range dump() {
+		if before[g.id] || c.ignore(g) {
+			continue
+		}
+		leaked = append(leaked, g)
+	}
+	return leaked
+}
+
+func (c *config) ignore(g goroutine) bool {
+	// Subtests and parallel tests belong to the test runner.
+	if strings.HasPrefix(g.createdBy, "testing.") {
+		return true
+	}
+	for _, fn := range background {
+		if g.has(fn) {
+			return true
+		}
+	}
+	for _, fn := range c.ignored {
+		if g.has(fn) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package leakcheck
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recorder captures the failures Check reports instead of failing the test.
+type recorder struct {
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func blockedForever(c chan struct{}) {
+	<-c
+}
+
+func TestLeakReported(t *testing.T) {
+	r := &recorder{}
+	verify := Check(r, GracePeriod(20*time.Millisecond))
+
+	c := make(chan struct{})
+	go blockedForever(c)
+	verify()
+	close(c)
+
+	if len(r.errors) != 1 {
+		t.Fatalf("Expected: %d error, got: %d", 1, len(r.errors))
+	}
+	if !strings.Contains(r.errors[0], "leakcheck.blockedForever") {
+		t.Errorf("Expected the leaked stack in the report, got: %s", r.errors[0])
+	}
+}
+
+func TestFinishesWithinGracePeriod(t *testing.T) {
+	r := &recorder{}
+	verify := Check(r)
+
+	go time.Sleep(50 * time.Millisecond)
+	verify()
+
+	if len(r.errors) != 0 {
+		t.Errorf("Expected: no errors, got: %v", r.errors)
+	}
+}
+
+func TestIgnoreFunction(t *testing.T) {
+	r := &recorder{}
+	verify := Check(r, GracePeriod(10*time.Millisecond), IgnoreFunction("concurrency/leakcheck.blockedForever"))
+
+	c := make(chan struct{})
+	go blockedForever(c)
+	verify()
+	close(c)
+
+	if len(r.errors) != 0 {
+		t.Errorf("Expected: no errors, got: %v", r.errors)
+	}
+}
+
+func TestExistingGoroutinesIgnored(t *testing.T) {
+	c := make(chan struct{})
+	defer close(c)
+	go blockedForever(c)
+
+	defer Check(t)()
+}
+
+func TestParse(t *testing.T) {
+	dump := `goroutine 1 [running]:
+main.main()
+	/tmp/s.go:3 +0xae
+
+goroutine 8 [chan receive]:
+net/http.(*persistConn).readLoop(0xc000120000)
+	/usr/local/go/src/net/http/transport.go:2200 +0x19
+created by net/http.(*Transport).dialConn in goroutine 1
+	/usr/local/go/src/net/http/transport.go:1800 +0x78`
+
+	gs := parse(dump)
+	if len(gs) != 2 {
+		t.Fatalf("Expected: %d goroutines, got: %d", 2, len(gs))
+	}
+	g := gs[1]
+	if g.id != 8 || g.state != "chan receive" {
+		t.Errorf("Expected: goroutine 8 [chan receive], got: %d [%s]", g.id, g.state)
+	}
+	if !g.has("net/http.(*persistConn).readLoop") {
+		t.Errorf("Expected readLoop frame, got: %v", g.funcs)
+	}
+	if g.createdBy != "net/http.(*Transport).dialConn" {
+		t.Errorf("Expected: %s, got: %s", "net/http.(*Transport).dialConn", g.createdBy)
+	}
+}
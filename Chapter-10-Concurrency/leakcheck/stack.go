@@ -0,0 +1,93 @@
+package leakcheck
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// goroutine is one entry of a runtime.Stack dump.
+type goroutine struct {
+	id        int
+	state     string
+	funcs     []string // innermost first
+	createdBy string
+	stack     string
+}
+
+func (g goroutine) has(fn string) bool {
+	for _, f := range g.funcs {
+		if f == fn {
+			return true
+		}
+	}
+	return false
+}
+
+// dump returns the stacks of every goroutine except the caller's.
+func dump() []goroutine {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	gs := parse(string(buf))
+	// runtime.Stack always lists the calling goroutine first.
+	if len(gs) > 0 {
+		gs = gs[1:]
+	}
+	return gs
+}
+
+// parse splits a runtime.Stack dump into goroutines. Each block looks like:
+//
+//	goroutine 8 [chan receive]:
+//	main.main.func2()
+//		/tmp/s.go:3 +0x19
+//	created by main.main in goroutine 1
+//		/tmp/s.go:3 +0x78
+func parse(s string) []goroutine {
+	var gs []goroutine
+	for _, block := range strings.Split(strings.TrimSpace(s), "\n\n") {
+		lines := strings.Split(block, "\n")
+		header := strings.TrimPrefix(lines[0], "goroutine ")
+		if header == lines[0] {
+			continue
+		}
+		g := goroutine{stack: block}
+		if i := strings.Index(header, " ["); i > 0 {
+			g.id, _ = strconv.Atoi(header[:i])
+			g.state = strings.TrimSuffix(header[i+2:], "]:")
+		}
+		for _, line := range lines[1:] {
+			if strings.HasPrefix(line, "\t") || line == "" {
+				continue
+			}
+			if strings.HasPrefix(line, "created by ") {
+				g.createdBy = funcName(strings.TrimPrefix(line, "created by "))
+				continue
+			}
+			g.funcs = append(g.funcs, funcName(line))
+		}
+		gs = append(gs, g)
+	}
+	return gs
+}
+
+// funcName strips the argument list and the "in goroutine N" suffix from a
+// stack line, leaving the qualified function name.
+func funcName(line string) string {
+	if i := strings.Index(line, " in goroutine "); i >= 0 {
+		line = line[:i]
+	}
+	if i := strings.LastIndex(line, "("); i > 0 && strings.HasSuffix(line, ")") {
+		// Method receivers like pkg.(*T).M also contain parentheses; only
+		// a trailing argument list is cut.
+		line = line[:i]
+	}
+	return line
+}
@@ -3,18 +3,32 @@ package main
 import "fmt"
 import "time"
 import "math/rand"
+import "context"
+import "strconv"
+import "concurrency/clock"
+import "concurrency/pool"
+import "concurrency/examples"
+import "os"
 
-func f(n int) {
-	for i := 1; i <= 10; i++ {
-		fmt.Println(n, ":", i)
-		amt := time.Duration(rand.Intn(250))
-		time.Sleep(time.Millisecond * amt)
-	}
+func pause() time.Duration {
+	amt := time.Duration(rand.Intn(250))
+	return time.Millisecond * amt
 }
+
 func main() {
+	ctx := context.Background()
+	p := pool.New(ctx, 3, 5)
 	for i := 1; i <= 10; i++ {
-		go f(i)
+		n := i
+		p.Submit(ctx, pool.Job{
+			ID:       strconv.Itoa(n),
+			Priority: n,
+			Run: func(ctx context.Context) (interface{}, error) {
+				examples.Count(os.Stdout, n, clock.New(), pause)
+				return nil, nil
+			},
+		})
 	}
-	var input string
-	fmt.Scanln(&input)
+	results := p.Close()
+	fmt.Println(len(results), "jobs done:", p.Metrics())
 }
@@ -0,0 +1,227 @@
+package pool
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned by TrySubmit when every queue slot is taken.
+	ErrQueueFull = errors.New("pool: job queue is full")
+	// ErrClosed is returned when submitting to a pool after Close.
+	ErrClosed = errors.New("pool: closed")
+	// ErrPanicked is wrapped in the Err of a job whose Run panicked.
+	ErrPanicked = errors.New("pool: job panicked")
+)
+
+// Job is a unit of work for the pool. Higher Priority jobs are started
+// first. If Timeout is set, the context passed to Run expires after it.
+type Job struct {
+	ID       string
+	Priority int
+	Timeout  time.Duration
+	Run      func(ctx context.Context) (interface{}, error)
+}
+
+// Result is the outcome of one Job.
+type Result struct {
+	JobID    string
+	Value    interface{}
+	Err      error
+	Duration time.Duration
+}
+
+// Metrics is a snapshot of the pool's counters.
+type Metrics struct {
+	Queued    int
+	Running   int
+	Completed int
+	Failed    int
+}
+
+// Pool runs jobs on a fixed number of workers. Jobs wait in a bounded
+// priority queue; once it is full, Submit blocks until a slot frees up.
+// Results are kept until Drain or Close hands them out, so a pool that
+// lives long should Drain now and then.
+type Pool struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	slots chan struct{} // one token per free queue slot
+	ready chan struct{} // one token per queued job
+
+	mu      sync.Mutex
+	queue   jobQueue
+	seq     uint64
+	closed  bool
+	results []Result
+
+	running   int64
+	completed int64
+	failed    int64
+
+	wg sync.WaitGroup
+}
+
+// New starts a pool with the given number of workers and queue capacity.
+// Cancelling ctx cancels the context of every running and queued job.
+func New(ctx context.Context, workers, queueSize int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Pool{
+		ctx:    ctx,
+		cancel: cancel,
+		slots:  make(chan struct{}, queueSize),
+		ready:  make(chan struct{}, queueSize),
+	}
+	for i := 0; i < queueSize; i++ {
+		p.slots <- struct{}{}
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+// Submit queues a job, waiting for a free slot if the queue is full. It
+// returns ctx.Err() if ctx is done before a slot frees up.
+func (p *Pool) Submit(ctx context.Context, job Job) error {
+	select {
+	case <-p.slots:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return p.enqueue(job)
+}
+
+// TrySubmit queues a job without waiting, returning ErrQueueFull if there
+// is no free slot.
+func (p *Pool) TrySubmit(job Job) error {
+	select {
+	case <-p.slots:
+	default:
+		return ErrQueueFull
+	}
+	return p.enqueue(job)
+}
+
+func (p *Pool) enqueue(job Job) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		p.slots <- struct{}{}
+		return ErrClosed
+	}
+	p.seq++
+	heap.Push(&p.queue, &queuedJob{job: job, seq: p.seq})
+	// Never blocks: ready has as much room as there are slots.
+	p.ready <- struct{}{}
+	p.mu.Unlock()
+	return nil
+}
+
+// Metrics returns the current queue and job counters.
+func (p *Pool) Metrics() Metrics {
+	p.mu.Lock()
+	queued := p.queue.Len()
+	p.mu.Unlock()
+	return Metrics{
+		Queued:    queued,
+		Running:   int(atomic.LoadInt64(&p.running)),
+		Completed: int(atomic.LoadInt64(&p.completed)),
+		Failed:    int(atomic.LoadInt64(&p.failed)),
+	}
+}
+
+// Drain returns the results of the jobs finished since the last Drain, in
+// completion order, and forgets them.
+func (p *Pool) Drain() []Result {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	results := p.results
+	p.results = nil
+	return results
+}
+
+// Close stops accepting jobs, waits for the queued and running ones to
+// finish and returns every result not yet drained, in completion order.
+func (p *Pool) Close() []Result {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.ready)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	p.cancel()
+
+	return p.Drain()
+}
+
+func (p *Pool) worker() {
+	defer p.wg.Done()
+	for range p.ready {
+		p.mu.Lock()
+		item := heap.Pop(&p.queue).(*queuedJob)
+		atomic.AddInt64(&p.running, 1)
+		p.mu.Unlock()
+		p.slots <- struct{}{}
+
+		res := p.run(item.job)
+
+		p.mu.Lock()
+		p.results = append(p.results, res)
+		p.mu.Unlock()
+	}
+}
+
+func (p *Pool) run(job Job) Result {
+	ctx := p.ctx
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, job.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	var value interface{}
+	err := ctx.Err()
+	if err == nil {
+		value, err = call(ctx, job)
+	}
+	if err == nil {
+		// A job that ignores its context still fails once it overruns.
+		err = ctx.Err()
+	}
+	res := Result{JobID: job.ID, Value: value, Err: err, Duration: time.Since(start)}
+	atomic.AddInt64(&p.running, -1)
+
+	if err != nil {
+		atomic.AddInt64(&p.failed, 1)
+	} else {
+		atomic.AddInt64(&p.completed, 1)
+	}
+	return res
+}
+
+// call runs the job, turning a panic into its error so one bad job does not
+// take the worker, and the process, down with it.
+func call(ctx context.Context, job Job) (value interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			value, err = nil, fmt.Errorf("%w: %v", ErrPanicked, r)
+		}
+	}()
+	return job.Run(ctx)
+}
@@ -0,0 +1,194 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"concurrency/leakcheck"
+)
+
+// blocker returns a job that runs until release is closed, and a channel
+// that is closed once the job has started.
+func blocker(release <-chan struct{}) (Job, <-chan struct{}) {
+	started := make(chan struct{})
+	return Job{ID: "blocker", Run: func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	}}, started
+}
+
+func TestPriorities(t *testing.T) {
+	defer leakcheck.Check(t)()
+	p := New(context.Background(), 1, 10)
+	release := make(chan struct{})
+	job, started := blocker(release)
+	p.TrySubmit(job)
+	<-started
+
+	for _, prio := range []int{1, 5, 3, 5, 0} {
+		id := strconv.Itoa(prio)
+		err := p.TrySubmit(Job{ID: id, Priority: prio, Run: func(ctx context.Context) (interface{}, error) {
+			return id, nil
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+
+	var got []string
+	results := p.Close()
+	if len(results) > 0 {
+		results = results[1:]
+	}
+	for _, res := range results {
+		got = append(got, res.JobID)
+	}
+	want := []string{"5", "5", "3", "1", "0"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected: %v, got: %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected: %v, got: %v", want, got)
+			break
+		}
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	defer leakcheck.Check(t)()
+	p := New(context.Background(), 1, 1)
+	release := make(chan struct{})
+	job, started := blocker(release)
+	p.TrySubmit(job)
+	<-started
+
+	noop := Job{Run: func(ctx context.Context) (interface{}, error) { return nil, nil }}
+	if err := p.TrySubmit(noop); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.TrySubmit(noop); err != ErrQueueFull {
+		t.Errorf("Expected: %v, got: %v", ErrQueueFull, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Submit(ctx, noop); err != context.DeadlineExceeded {
+		t.Errorf("Expected: %v, got: %v", context.DeadlineExceeded, err)
+	}
+
+	close(release)
+	if n := len(p.Close()); n != 2 {
+		t.Errorf("Expected: %d results, got: %d", 2, n)
+	}
+	if err := p.TrySubmit(noop); err != ErrClosed {
+		t.Errorf("Expected: %v, got: %v", ErrClosed, err)
+	}
+}
+
+func TestTimeoutAndErrors(t *testing.T) {
+	defer leakcheck.Check(t)()
+	p := New(context.Background(), 2, 4)
+	boom := errors.New("boom")
+	p.TrySubmit(Job{ID: "slow", Timeout: 10 * time.Millisecond, Run: func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}})
+	p.TrySubmit(Job{ID: "fail", Run: func(ctx context.Context) (interface{}, error) {
+		return nil, boom
+	}})
+	p.TrySubmit(Job{ID: "ok", Run: func(ctx context.Context) (interface{}, error) {
+		return 42, nil
+	}})
+
+	errs := map[string]error{}
+	for _, res := range p.Close() {
+		errs[res.JobID] = res.Err
+		if res.JobID == "ok" && res.Value != 42 {
+			t.Errorf("Expected: %v, got: %v", 42, res.Value)
+		}
+	}
+	if errs["slow"] != context.DeadlineExceeded {
+		t.Errorf("Expected: %v, got: %v", context.DeadlineExceeded, errs["slow"])
+	}
+	if errs["fail"] != boom {
+		t.Errorf("Expected: %v, got: %v", boom, errs["fail"])
+	}
+	if errs["ok"] != nil {
+		t.Errorf("Expected: %v, got: %v", nil, errs["ok"])
+	}
+
+	m := p.Metrics()
+	if m.Completed != 1 || m.Failed != 2 || m.Running != 0 || m.Queued != 0 {
+		t.Errorf("Expected: 1 completed and 2 failed, got: %+v", m)
+	}
+}
+
+func TestMetricsWhileRunning(t *testing.T) {
+	defer leakcheck.Check(t)()
+	p := New(context.Background(), 1, 2)
+	release := make(chan struct{})
+	job, started := blocker(release)
+	p.TrySubmit(job)
+	<-started
+	p.TrySubmit(Job{Run: func(ctx context.Context) (interface{}, error) { return nil, nil }})
+
+	m := p.Metrics()
+	if m.Running != 1 || m.Queued != 1 {
+		t.Errorf("Expected: 1 running and 1 queued, got: %+v", m)
+	}
+	close(release)
+	p.Close()
+}
+
+func TestPanic(t *testing.T) {
+	defer leakcheck.Check(t)()
+	p := New(context.Background(), 1, 2)
+	p.TrySubmit(Job{ID: "bad", Run: func(ctx context.Context) (interface{}, error) {
+		panic("oops")
+	}})
+	p.TrySubmit(Job{ID: "good", Run: func(ctx context.Context) (interface{}, error) {
+		return 1, nil
+	}})
+
+	results := p.Close()
+	if len(results) != 2 {
+		t.Fatalf("Expected: %d results, got: %v", 2, results)
+	}
+	if !errors.Is(results[0].Err, ErrPanicked) || results[0].Err.Error() != "pool: job panicked: oops" {
+		t.Errorf("Expected: %v: oops, got: %v", ErrPanicked, results[0].Err)
+	}
+	if results[1].JobID != "good" || results[1].Err != nil {
+		t.Errorf("Expected: the next job to run, got: %+v", results[1])
+	}
+	if m := p.Metrics(); m.Failed != 1 || m.Completed != 1 || m.Running != 0 {
+		t.Errorf("Expected: 1 failed and 1 completed, got: %+v", m)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	defer leakcheck.Check(t)()
+	p := New(context.Background(), 1, 2)
+	done := make(chan struct{})
+	p.TrySubmit(Job{ID: "first", Run: func(ctx context.Context) (interface{}, error) {
+		close(done)
+		return nil, nil
+	}})
+	<-done
+	var drained []Result
+	for len(drained) == 0 {
+		drained = p.Drain()
+	}
+	if len(drained) != 1 || drained[0].JobID != "first" {
+		t.Errorf("Expected: the first result, got: %v", drained)
+	}
+	p.TrySubmit(Job{ID: "second", Run: func(ctx context.Context) (interface{}, error) { return nil, nil }})
+	if rest := p.Close(); len(rest) != 1 || rest[0].JobID != "second" {
+		t.Errorf("Expected: only the undrained result, got: %v", rest)
+	}
+}
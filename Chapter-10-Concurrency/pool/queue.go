@@ -0,0 +1,42 @@
+package pool
+
+import "container/heap"
+
+// jobQueue is a max-heap on Priority; jobs of equal priority leave in the
+// order they were submitted.
+type jobQueue []*queuedJob
+
+type queuedJob struct {
+	job Job
+	seq uint64
+}
+
+func (q jobQueue) Len() int {
+	return len(q)
+}
+
+func (q jobQueue) Less(i, j int) bool {
+	if q[i].job.Priority != q[j].job.Priority {
+		return q[i].job.Priority > q[j].job.Priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *jobQueue) Push(x interface{}) {
+	*q = append(*q, x.(*queuedJob))
+}
+
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return item
+}
+
+var _ heap.Interface = (*jobQueue)(nil)
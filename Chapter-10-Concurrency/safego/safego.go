@@ -0,0 +1,95 @@
+package safego
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"runtime/pprof"
+	"sync"
+)
+
+// PanicError is a recovered panic from a goroutine started by Go or a Group.
+type PanicError struct {
+	Label string
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in goroutine %q: %v\n\n%s", e.Label, e.Value, e.Stack)
+}
+
+// Unwrap returns the panic value if it was an error, so errors.Is and
+// errors.As see through a panic(err).
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Go runs fn in a new goroutine labelled with label. If fn panics, the panic
+// is recovered and passed to onPanic as a *PanicError instead of crashing
+// the process. onPanic may be nil.
+func Go(label string, fn func(), onPanic func(error)) {
+	go func() {
+		if err := call(context.Background(), label, func(context.Context) error {
+			fn()
+			return nil
+		}); err != nil && onPanic != nil {
+			onPanic(err)
+		}
+	}()
+}
+
+// call runs fn with the pprof label "goroutine" set, so the label also
+// shows up in CPU and goroutine profiles, and turns a panic into an error.
+func call(ctx context.Context, label string, fn func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Label: label, Value: r, Stack: debug.Stack()}
+		}
+	}()
+	pprof.Do(ctx, pprof.Labels("goroutine", label), func(ctx context.Context) {
+		err = fn(ctx)
+	})
+	return err
+}
+
+// Group runs goroutines that share a context. The first one to return an
+// error or panic cancels the context for the rest, and its error is what
+// Wait returns. Create one with WithContext.
+type Group struct {
+	cancel context.CancelFunc
+	ctx    context.Context
+
+	wg   sync.WaitGroup
+	once sync.Once
+	err  error
+}
+
+// WithContext returns a Group and the context its goroutines receive.
+func WithContext(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Group{ctx: ctx, cancel: cancel}, ctx
+}
+
+// Go runs fn in a new goroutine labelled with label.
+func (g *Group) Go(label string, fn func(ctx context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := call(g.ctx, label, fn); err != nil {
+			g.once.Do(func() {
+				g.err = err
+				g.cancel()
+			})
+		}
+	}()
+}
+
+// Wait blocks until every goroutine has returned and reports the first
+// error.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
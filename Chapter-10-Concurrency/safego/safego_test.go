@@ -0,0 +1,97 @@
+package safego
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"concurrency/leakcheck"
+)
+
+func explode() {
+	panic("kaboom")
+}
+
+func TestGoRecovers(t *testing.T) {
+	defer leakcheck.Check(t)()
+	errs := make(chan error, 1)
+	Go("exploder", explode, func(err error) {
+		errs <- err
+	})
+
+	var pe *PanicError
+	if err := <-errs; !errors.As(err, &pe) {
+		t.Fatalf("Expected: *PanicError, got: %T", err)
+	}
+	if pe.Label != "exploder" || pe.Value != "kaboom" {
+		t.Errorf("Expected: exploder/kaboom, got: %s/%v", pe.Label, pe.Value)
+	}
+	if !strings.Contains(string(pe.Stack), "safego.explode") {
+		t.Errorf("Expected the panicking function in the stack, got:\n%s", pe.Stack)
+	}
+}
+
+func TestGroupFirstErrorCancelsSiblings(t *testing.T) {
+	defer leakcheck.Check(t)()
+	g, ctx := WithContext(context.Background())
+	sibling := make(chan error, 1)
+
+	g.Go("sibling", func(ctx context.Context) error {
+		<-ctx.Done()
+		sibling <- ctx.Err()
+		return ctx.Err()
+	})
+	g.Go("exploder", func(ctx context.Context) error {
+		explode()
+		return nil
+	})
+
+	err := g.Wait()
+	var pe *PanicError
+	if !errors.As(err, &pe) || pe.Label != "exploder" {
+		t.Fatalf("Expected: panic from exploder, got: %v", err)
+	}
+	if err := <-sibling; err != context.Canceled {
+		t.Errorf("Expected: %v, got: %v", context.Canceled, err)
+	}
+	if ctx.Err() == nil {
+		t.Error("Expected the group context to be cancelled")
+	}
+}
+
+func TestGroup(t *testing.T) {
+	type testPair struct {
+		results []error
+		want    error
+	}
+	boom := errors.New("boom")
+	tests := []testPair{
+		{[]error{nil, nil, nil}, nil},
+		{[]error{nil, boom, nil}, boom},
+	}
+
+	for _, pair := range tests {
+		g, _ := WithContext(context.Background())
+		for _, res := range pair.results {
+			res := res
+			g.Go("worker", func(ctx context.Context) error {
+				return res
+			})
+		}
+		if err := g.Wait(); err != pair.want {
+			t.Errorf("Expected: %v, got: %v", pair.want, err)
+		}
+	}
+}
+
+func TestPanicErrorUnwrap(t *testing.T) {
+	boom := errors.New("boom")
+	g, _ := WithContext(context.Background())
+	g.Go("worker", func(ctx context.Context) error {
+		panic(boom)
+	})
+	if err := g.Wait(); !errors.Is(err, boom) {
+		t.Errorf("Expected: %v, got: %v", boom, err)
+	}
+}
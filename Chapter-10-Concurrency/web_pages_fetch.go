@@ -1,6 +1,8 @@
 package main
 
 import (
+	"concurrency/safego"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -20,27 +22,40 @@ func main() {
 		"http://www.stackoverflow.com",
 	}
 
-	results := make(chan HomePageSize)
+	results := make(chan HomePageSize, len(urls))
 
+	// A failed fetch, or a panic in one, cancels the others instead of
+	// taking the whole process down.
+	g, _ := safego.WithContext(context.Background())
 	for _, url := range urls {
-		go func(url string) {
-			res, err := http.Get(url)
+		url := url
+		g.Go(url, func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 			if err != nil {
-				panic(err)
+				return err
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
 			}
 			defer res.Body.Close()
 			bs, err := ioutil.ReadAll(res.Body)
 			if err != nil {
-				panic(err)
+				return err
 			}
 			results <- HomePageSize{URL: url, Size: len(bs)}
-		}(url)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		fmt.Println(err)
+		return
 	}
+	close(results)
 
 	var biggest HomePageSize
 
-	for range urls {
-		result := <-results
+	for result := range results {
 		if result.Size > biggest.Size {
 			biggest = result
 		}
@@ -0,0 +1,173 @@
+// Package api serves the maths package and the shapes of Chapter-7 as a
+// JSON API:
+//
+//	POST /stats        [3, 1, 4]  ->  {"count": 3, "average": 2.67, "min": 1, "max": 4}
+//	POST /shapes/area  [{"type": "circle", "r": 1}, ...]  ->  per-shape and total area and perimeter
+//
+// Failures are answered with a status and a JSON body of the form
+// {"error": {"code": ..., "message": ..., "fields": [...]}}.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"packages_example/maths"
+)
+
+// MaxBodySize caps request bodies.
+const MaxBodySize = 1 << 20
+
+// Error is the body of every failed response.
+type Error struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields says what is wrong with which part of the request, for
+	// validation errors.
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is one failed check, Field being a path into the request
+// such as "[2].r".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func validation(fields []FieldError) *Error {
+	return &Error{Status: http.StatusUnprocessableEntity, Code: "validation_failed", Message: "the request is not valid", Fields: fields}
+}
+
+// Handler returns the API's routes.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("POST /stats", handle(stats))
+	mux.Handle("POST /shapes/area", handle(area))
+	for _, path := range []string{"/stats", "/shapes/area"} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, &Error{Status: http.StatusMethodNotAllowed, Code: "method_not_allowed", Message: r.Method + " is not allowed; use POST"})
+		})
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, &Error{Status: http.StatusNotFound, Code: "not_found", Message: "no such endpoint: " + r.URL.Path})
+	})
+	return mux
+}
+
+// handle adapts a function taking the decoded body and returning the
+// reply.
+func handle[Req, Resp any](f func(Req) (Resp, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := decode(w, r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		resp, err := f(req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// decode reads a single JSON value into v, strictly.
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if t, _, err := mime.ParseMediaType(ct); err != nil || t != "application/json" {
+			return &Error{Status: http.StatusUnsupportedMediaType, Code: "unsupported_media_type", Message: "send application/json"}
+		}
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(new(json.RawMessage)) != io.EOF {
+		err = errors.New("more than one JSON value")
+	}
+	if err == nil {
+		return nil
+	}
+	var tooLarge *http.MaxBytesError
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &tooLarge):
+		return &Error{Status: http.StatusRequestEntityTooLarge, Code: "too_large", Message: fmt.Sprintf("the body is over %d bytes", tooLarge.Limit)}
+	case errors.As(err, &syntax):
+		return &Error{Status: http.StatusBadRequest, Code: "malformed_json", Message: fmt.Sprintf("malformed JSON at byte %d", syntax.Offset)}
+	case errors.As(err, &typ):
+		return &Error{Status: http.StatusBadRequest, Code: "malformed_json", Message: fmt.Sprintf("%s: expected %s, got %s", fieldPath(typ.Field), typ.Type, typ.Value)}
+	case err == io.EOF:
+		return &Error{Status: http.StatusBadRequest, Code: "malformed_json", Message: "the body is empty"}
+	}
+	return &Error{Status: http.StatusBadRequest, Code: "malformed_json", Message: err.Error()}
+}
+
+// fieldPath writes the decoder's "0.r" as "[0].r", as FieldError does.
+func fieldPath(field string) string {
+	if field == "" {
+		return "body"
+	}
+	var b strings.Builder
+	for _, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			b.WriteString("[" + part + "]")
+		} else {
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(part)
+		}
+	}
+	return b.String()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Status: http.StatusInternalServerError, Code: "internal", Message: http.StatusText(http.StatusInternalServerError)}
+	}
+	writeJSON(w, e.Status, struct {
+		Error *Error `json:"error"`
+	}{e})
+}
+
+// Stats is the reply of /stats.
+type Stats struct {
+	Count   int     `json:"count"`
+	Average float64 `json:"average"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+}
+
+func stats(xs []float64) (Stats, error) {
+	if len(xs) == 0 {
+		return Stats{}, validation([]FieldError{{"body", "give at least one number"}})
+	}
+	// Finite numbers can still add up to infinity.
+	avg := maths.Average(xs)
+	if math.IsInf(avg, 0) || math.IsNaN(avg) {
+		return Stats{}, validation([]FieldError{{"body", "the numbers are too large to average"}})
+	}
+	return Stats{Count: len(xs), Average: avg, Min: maths.Min(xs), Max: maths.Max(xs)}, nil
+}
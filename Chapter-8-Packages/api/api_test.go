@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPI(t *testing.T) {
+	h := Handler()
+	type testPair struct {
+		method, path, contentType, body string
+		status                          int
+		want                            string
+	}
+	tests := []testPair{
+		{"POST", "/stats", "application/json", `[3, 1, 4, 1, 5, 9, 2, 6]`, 200,
+			`{"count":8,"average":3.875,"min":1,"max":9}`},
+		{"POST", "/stats", "", `[-2.5]`, 200,
+			`{"count":1,"average":-2.5,"min":-2.5,"max":-2.5}`},
+		{"POST", "/stats", "application/json; charset=utf-8", `[]`, 422,
+			`{"error":{"code":"validation_failed","message":"the request is not valid","fields":[{"field":"body","message":"give at least one number"}]}}`},
+		{"POST", "/stats", "application/json", `[1e308, 1e308]`, 422,
+			`{"error":{"code":"validation_failed","message":"the request is not valid","fields":[{"field":"body","message":"the numbers are too large to average"}]}}`},
+		{"POST", "/stats", "application/json", `[1, "two"]`, 400,
+			`{"error":{"code":"malformed_json","message":"[1]: expected float64, got string"}}`},
+		{"POST", "/stats", "application/json", `{"xs": [1]}`, 400,
+			`{"error":{"code":"malformed_json","message":"body: expected []float64, got object"}}`},
+		{"POST", "/stats", "application/json", `[1, 2`, 400,
+			`{"error":{"code":"malformed_json","message":"unexpected EOF"}}`},
+		{"POST", "/stats", "application/json", `[1] [2]`, 400,
+			`{"error":{"code":"malformed_json","message":"more than one JSON value"}}`},
+		{"POST", "/stats", "application/json", `[1, }`, 400,
+			`{"error":{"code":"malformed_json","message":"malformed JSON at byte 5"}}`},
+		{"POST", "/stats", "application/json", ``, 400,
+			`{"error":{"code":"malformed_json","message":"the body is empty"}}`},
+		{"POST", "/stats", "text/plain", `[1]`, 415,
+			`{"error":{"code":"unsupported_media_type","message":"send application/json"}}`},
+		{"POST", "/stats", "application/json", `[` + strings.Repeat("1,", MaxBodySize/2) + `1]`, 413,
+			`{"error":{"code":"too_large","message":"the body is over 1048576 bytes"}}`},
+		{"GET", "/stats", "", ``, 405,
+			`{"error":{"code":"method_not_allowed","message":"GET is not allowed; use POST"}}`},
+		{"POST", "/nothing", "", ``, 404,
+			`{"error":{"code":"not_found","message":"no such endpoint: /nothing"}}`},
+
+		{"POST", "/shapes/area", "application/json", `[{"type": "rectangle", "l": 3, "w": 4}, {"type": "rectangle", "l": 1, "w": 1}]`, 200,
+			`{"shapes":[{"type":"rectangle","area":12,"perimeter":14},{"type":"rectangle","area":1,"perimeter":4}],"total":{"area":13,"perimeter":18}}`},
+		{"POST", "/shapes/area", "application/json", `[{"type": "circle", "r": 0.5}]`, 200,
+			`{"shapes":[{"type":"circle","area":0.7853981633974483,"perimeter":3.141592653589793}],"total":{"area":0.7853981633974483,"perimeter":3.141592653589793}}`},
+		{"POST", "/shapes/area", "application/json", `[{"type": "multi", "shapes": [{"type": "rectangle", "l": 2, "w": 2}, {"type": "multi", "shapes": [{"type": "rectangle", "l": 1, "w": 3}]}]}]`, 200,
+			`{"shapes":[{"type":"multi","area":7,"perimeter":16}],"total":{"area":7,"perimeter":16}}`},
+		{"POST", "/shapes/area", "application/json", `[]`, 422,
+			`{"error":{"code":"validation_failed","message":"the request is not valid","fields":[{"field":"body","message":"give at least one shape"}]}}`},
+		{"POST", "/shapes/area", "application/json", `[{"type": "circle", "r": -1, "l": 2}, {"type": "rectangle", "l": 1}, {"r": 1}, {"type": "hexagon"}, {"type": "multi", "shapes": [{"type": "circle"}]}, {"type": "multi"}]`, 422,
+			`{"error":{"code":"validation_failed","message":"the request is not valid","fields":[` +
+				`{"field":"[0].l","message":"is not a field of a circle"},` +
+				`{"field":"[0].r","message":"must be a positive number"},` +
+				`{"field":"[1].w","message":"is required"},` +
+				`{"field":"[2].type","message":"is required"},` +
+				`{"field":"[3].type","message":"unknown shape \"hexagon\"; use circle, rectangle or multi"},` +
+				`{"field":"[4].shapes[0].r","message":"is required"},` +
+				`{"field":"[5].shapes","message":"give at least one shape"}]}}`},
+		{"POST", "/shapes/area", "application/json", `[{"type": "circle", "radius": 1}]`, 400,
+			`{"error":{"code":"malformed_json","message":"json: unknown field \"radius\""}}`},
+		{"POST", "/shapes/area", "application/json", `[{"type": "circle", "r": "1"}]`, 400,
+			`{"error":{"code":"malformed_json","message":"[0].r: expected float64, got string"}}`},
+		{"PUT", "/shapes/area", "application/json", `[]`, 405,
+			`{"error":{"code":"method_not_allowed","message":"PUT is not allowed; use POST"}}`},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		if test.contentType != "" {
+			r.Header.Set("Content-Type", test.contentType)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		body := strings.TrimSuffix(w.Body.String(), "\n")
+		name := test.method + " " + test.path + " " + test.body
+		if len(name) > 80 {
+			name = name[:80]
+		}
+		if w.Code != test.status || body != test.want {
+			t.Errorf("%s: Expected: %d %s, got: %d %s", name, test.status, test.want, w.Code, body)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: Expected: application/json, got: %s", name, ct)
+		}
+	}
+}
+
+func TestMaxShapes(t *testing.T) {
+	body := "[" + strings.Repeat(`{"type": "circle", "r": 1},`, MaxShapes) + `{"type": "circle", "r": 1}]`
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("POST", "/shapes/area", strings.NewReader(body)))
+	if w.Code != 422 || !strings.Contains(w.Body.String(), `"field":"[1000]","message":"more than 1000 shapes"`) {
+		t.Errorf("Expected: 422 for too many shapes, got: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"fmt"
+	"math"
+
+	"packages/shapes"
+)
+
+// MaxShapes caps the shapes in a request, counting those inside multis.
+const MaxShapes = 1000
+
+// ShapeRequest is one shape of a /shapes/area request: a circle with R,
+// a rectangle with L and W, or a multi made of Shapes.
+type ShapeRequest struct {
+	Type   string         `json:"type"`
+	R      *float64       `json:"r,omitempty"`
+	L      *float64       `json:"l,omitempty"`
+	W      *float64       `json:"w,omitempty"`
+	Shapes []ShapeRequest `json:"shapes,omitempty"`
+}
+
+// Measure is the area and perimeter of a shape, or of all of them.
+type Measure struct {
+	Area      float64 `json:"area"`
+	Perimeter float64 `json:"perimeter"`
+}
+
+// ShapeResult is one shape of the reply, in the order given.
+type ShapeResult struct {
+	Type string `json:"type"`
+	Measure
+}
+
+// Areas is the reply of /shapes/area.
+type Areas struct {
+	Shapes []ShapeResult `json:"shapes"`
+	Total  Measure       `json:"total"`
+}
+
+func area(reqs []ShapeRequest) (Areas, error) {
+	if len(reqs) == 0 {
+		return Areas{}, validation([]FieldError{{"body", "give at least one shape"}})
+	}
+	v := &validator{}
+	var all []shapes.Shape
+	for i, req := range reqs {
+		all = append(all, v.shape(fmt.Sprintf("[%d]", i), req))
+	}
+	if len(v.fields) > 0 {
+		return Areas{}, validation(v.fields)
+	}
+	reply := Areas{Shapes: make([]ShapeResult, len(all))}
+	for i, s := range all {
+		m := Measure{Area: s.Area(), Perimeter: s.Perimeter()}
+		reply.Shapes[i] = ShapeResult{Type: reqs[i].Type, Measure: m}
+		reply.Total.Area += m.Area
+		reply.Total.Perimeter += m.Perimeter
+	}
+	if math.IsInf(reply.Total.Area, 0) || math.IsInf(reply.Total.Perimeter, 0) {
+		return Areas{}, validation([]FieldError{{"body", "the shapes are too large to measure"}})
+	}
+	return reply, nil
+}
+
+// validator turns requests into shapes, collecting every problem on the
+// way.
+type validator struct {
+	fields []FieldError
+	count  int
+}
+
+func (v *validator) fail(field, format string, args ...interface{}) {
+	v.fields = append(v.fields, FieldError{field, fmt.Sprintf(format, args...)})
+}
+
+// length checks a dimension is there and positive.
+func (v *validator) length(field string, x *float64) float64 {
+	switch {
+	case x == nil:
+		v.fail(field, "is required")
+	case *x <= 0 || math.IsInf(*x, 0):
+		v.fail(field, "must be a positive number")
+	default:
+		return *x
+	}
+	return 0
+}
+
+// unused checks that the fields of other types are left out.
+func (v *validator) unused(path, typ string, set map[string]bool) {
+	for _, name := range []string{"r", "l", "w", "shapes"} {
+		if set[name] {
+			v.fail(path+"."+name, "is not a field of a %s", typ)
+		}
+	}
+}
+
+func (v *validator) shape(path string, req ShapeRequest) shapes.Shape {
+	v.count++
+	if v.count == MaxShapes+1 {
+		v.fail(path, "more than %d shapes", MaxShapes)
+	}
+	set := map[string]bool{"r": req.R != nil, "l": req.L != nil, "w": req.W != nil, "shapes": req.Shapes != nil}
+	switch req.Type {
+	case "circle":
+		set["r"] = false
+		v.unused(path, req.Type, set)
+		return &shapes.Circle{R: v.length(path+".r", req.R)}
+	case "rectangle":
+		set["l"], set["w"] = false, false
+		v.unused(path, req.Type, set)
+		return &shapes.Rectangle{L: v.length(path+".l", req.L), W: v.length(path+".w", req.W)}
+	case "multi":
+		set["shapes"] = false
+		v.unused(path, req.Type, set)
+		if len(req.Shapes) == 0 {
+			v.fail(path+".shapes", "give at least one shape")
+		}
+		m := &shapes.MultiShape{}
+		for i, inner := range req.Shapes {
+			m.Shapes = append(m.Shapes, v.shape(fmt.Sprintf("%s.shapes[%d]", path, i), inner))
+		}
+		return m
+	case "":
+		v.fail(path+".type", "is required")
+	default:
+		v.fail(path+".type", "unknown shape %q; use circle, rectangle or multi", req.Type)
+	}
+	return &shapes.MultiShape{}
+}
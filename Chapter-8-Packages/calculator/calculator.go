@@ -0,0 +1,111 @@
+// Package calculator is a net/rpc service over the maths package from
+// creating_packages, plus the four arithmetic operations.
+package calculator
+
+import (
+	"errors"
+	"math"
+
+	"packages_example/maths"
+)
+
+// Name is the service name methods are called under, as in
+// "Calculator.Average".
+const Name = "Calculator"
+
+var (
+	ErrEmpty        = errors.New("calculator: no numbers given")
+	ErrNotANumber   = errors.New("calculator: inputs must be finite numbers")
+	ErrDivideByZero = errors.New("calculator: division by zero")
+)
+
+// Numbers are the arguments of Average, Max and Min.
+type Numbers struct {
+	Xs []float64
+}
+
+// Operands are the arguments of Add, Sub, Mul and Div.
+type Operands struct {
+	A, B float64
+}
+
+// Result is the reply of every method.
+type Result struct {
+	Value float64
+}
+
+// Calculator is the RPC receiver. Its methods are not meant to be called
+// directly; use Client.
+type Calculator struct{}
+
+// Registrar is an RPC server to register with, such as *rpc.Server or
+// *rpcx.Server.
+type Registrar interface {
+	RegisterName(name string, rcvr interface{}) error
+}
+
+// Register adds the service to s under Name.
+func Register(s Registrar) error {
+	return s.RegisterName(Name, new(Calculator))
+}
+
+func checkNumbers(xs ...float64) error {
+	for _, x := range xs {
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return ErrNotANumber
+		}
+	}
+	return nil
+}
+
+// aggregate validates args and applies f.
+func aggregate(args Numbers, reply *Result, f func([]float64) float64) error {
+	if len(args.Xs) == 0 {
+		return ErrEmpty
+	}
+	if err := checkNumbers(args.Xs...); err != nil {
+		return err
+	}
+	reply.Value = f(args.Xs)
+	return nil
+}
+
+func (*Calculator) Average(args Numbers, reply *Result) error {
+	return aggregate(args, reply, maths.Average)
+}
+
+func (*Calculator) Max(args Numbers, reply *Result) error {
+	return aggregate(args, reply, maths.Max)
+}
+
+func (*Calculator) Min(args Numbers, reply *Result) error {
+	return aggregate(args, reply, maths.Min)
+}
+
+// arithmetic validates args and applies f.
+func arithmetic(args Operands, reply *Result, f func(a, b float64) float64) error {
+	if err := checkNumbers(args.A, args.B); err != nil {
+		return err
+	}
+	reply.Value = f(args.A, args.B)
+	return nil
+}
+
+func (*Calculator) Add(args Operands, reply *Result) error {
+	return arithmetic(args, reply, func(a, b float64) float64 { return a + b })
+}
+
+func (*Calculator) Sub(args Operands, reply *Result) error {
+	return arithmetic(args, reply, func(a, b float64) float64 { return a - b })
+}
+
+func (*Calculator) Mul(args Operands, reply *Result) error {
+	return arithmetic(args, reply, func(a, b float64) float64 { return a * b })
+}
+
+func (*Calculator) Div(args Operands, reply *Result) error {
+	if args.B == 0 {
+		return ErrDivideByZero
+	}
+	return arithmetic(args, reply, func(a, b float64) float64 { return a / b })
+}
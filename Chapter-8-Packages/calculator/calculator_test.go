@@ -0,0 +1,73 @@
+package calculator
+
+import (
+	"math"
+	"net"
+	"net/rpc"
+	"testing"
+
+	"concurrency/leakcheck"
+)
+
+// startServer serves the calculator until the returned listener is closed.
+// rpc.Server.Accept would log the close, so it accepts by hand.
+func startServer(t *testing.T) net.Listener {
+	s := rpc.NewServer()
+	if err := Register(s); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.ServeConn(conn)
+		}
+	}()
+	return l
+}
+
+func TestClient(t *testing.T) {
+	defer leakcheck.Check(t)()
+	l := startServer(t)
+	defer l.Close()
+	c, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	xs := []float64{1, 2, 3, 4}
+	type testPair struct {
+		name string
+		call func() (float64, error)
+		want float64
+		err  error
+	}
+	tests := []testPair{
+		{"average", func() (float64, error) { return c.Average(xs...) }, 2.5, nil},
+		{"max", func() (float64, error) { return c.Max(xs...) }, 4, nil},
+		{"min", func() (float64, error) { return c.Min(xs...) }, 1, nil},
+		{"add", func() (float64, error) { return c.Add(2, 3) }, 5, nil},
+		{"sub", func() (float64, error) { return c.Sub(2, 3) }, -1, nil},
+		{"mul", func() (float64, error) { return c.Mul(2, 3) }, 6, nil},
+		{"div", func() (float64, error) { return c.Div(3, 2) }, 1.5, nil},
+		{"average of nothing", func() (float64, error) { return c.Average() }, 0, ErrEmpty},
+		{"max of nothing", func() (float64, error) { return c.Max() }, 0, ErrEmpty},
+		{"min of nothing", func() (float64, error) { return c.Min() }, 0, ErrEmpty},
+		{"div by zero", func() (float64, error) { return c.Div(1, 0) }, 0, ErrDivideByZero},
+		{"nan", func() (float64, error) { return c.Average(1, math.NaN()) }, 0, ErrNotANumber},
+		{"inf", func() (float64, error) { return c.Add(math.Inf(1), 1) }, 0, ErrNotANumber},
+	}
+	for _, test := range tests {
+		got, err := test.call()
+		if got != test.want || err != test.err {
+			t.Errorf("%s: Expected: %v %v, got: %v %v", test.name, test.want, test.err, got, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package calculator
+
+import (
+	"net/rpc"
+)
+
+// Caller is an RPC client, such as *rpc.Client or *rpcx.Client.
+type Caller interface {
+	Call(serviceMethod string, args interface{}, reply interface{}) error
+	Close() error
+}
+
+// Client calls a Calculator service as ordinary Go functions.
+type Client struct {
+	rpc Caller
+}
+
+// Dial connects to an RPC server at addr that has the service registered.
+func Dial(addr string) (*Client, error) {
+	c, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(c), nil
+}
+
+// NewClient wraps an existing connection, which may also be used for other
+// services.
+func NewClient(c Caller) *Client {
+	return &Client{rpc: c}
+}
+
+// call turns the service's own errors, which arrive as rpc.ServerError
+// text, back into the package's error values.
+func (c *Client) call(method string, args interface{}) (float64, error) {
+	var reply Result
+	err := c.rpc.Call(Name+"."+method, args, &reply)
+	if serr, ok := err.(rpc.ServerError); ok {
+		for _, known := range []error{ErrEmpty, ErrNotANumber, ErrDivideByZero} {
+			if string(serr) == known.Error() {
+				return 0, known
+			}
+		}
+	}
+	return reply.Value, err
+}
+
+// Average returns the mean of xs, or ErrEmpty.
+func (c *Client) Average(xs ...float64) (float64, error) {
+	return c.call("Average", Numbers{Xs: xs})
+}
+
+// Max returns the largest of xs, or ErrEmpty.
+func (c *Client) Max(xs ...float64) (float64, error) {
+	return c.call("Max", Numbers{Xs: xs})
+}
+
+// Min returns the smallest of xs, or ErrEmpty.
+func (c *Client) Min(xs ...float64) (float64, error) {
+	return c.call("Min", Numbers{Xs: xs})
+}
+
+func (c *Client) Add(a, b float64) (float64, error) {
+	return c.call("Add", Operands{A: a, B: b})
+}
+
+func (c *Client) Sub(a, b float64) (float64, error) {
+	return c.call("Sub", Operands{A: a, B: b})
+}
+
+func (c *Client) Mul(a, b float64) (float64, error) {
+	return c.call("Mul", Operands{A: a, B: b})
+}
+
+// Div returns a/b, or ErrDivideByZero.
+func (c *Client) Div(a, b float64) (float64, error) {
+	return c.call("Div", Operands{A: a, B: b})
+}
+
+// Close closes the underlying connection.
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"packages/chat"
+	"packages/protocol"
+	"strings"
+)
+
+// The chat server runs on the same protocol.Server as tcp_servers.go, with
+// chat.Server as its handler. Start it with
+//
+//	go run chat.go -serve
+//
+// and connect a terminal client per user with
+//
+//	go run chat.go -nick alice
+
+func server(addr string) {
+	srv := &protocol.Server{Handler: chat.NewServer()}
+	err := srv.ListenAndServe(addr)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+const help = `commands:
+  /create <room>       create a room and join it
+  /join <room>         join a room and make it current
+  /leave <room>        leave a room
+  /rooms               list rooms
+  /msg <nick> <text>   private message
+  /quit                disconnect
+anything else is said in the current room`
+
+func client(addr, nick string) {
+	c, err := chat.Dial(addr, nick, protocol.Options{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer c.Close()
+
+	go func() {
+		for e := range c.Events {
+			printEvent(e)
+		}
+		fmt.Println("disconnected")
+		os.Exit(0)
+	}()
+
+	fmt.Println(help)
+	room := chat.Lobby
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "/") {
+			err = c.Say(room, line)
+		} else {
+			fields := strings.SplitN(line, " ", 3)
+			arg := ""
+			if len(fields) > 1 {
+				arg = fields[1]
+			}
+			switch fields[0] {
+			case "/create":
+				if err = c.Create(arg); err == nil {
+					room = arg
+				}
+			case "/join":
+				if err = c.Join(arg); err == nil {
+					room = arg
+				}
+			case "/leave":
+				if err = c.Leave(arg); err == nil && arg == room {
+					room = chat.Lobby
+				}
+			case "/rooms":
+				var rooms []string
+				if rooms, err = c.Rooms(); err == nil {
+					fmt.Println("rooms:", strings.Join(rooms, ", "))
+				}
+			case "/msg":
+				if len(fields) < 3 {
+					fmt.Println("usage: /msg <nick> <text>")
+					continue
+				}
+				err = c.Msg(arg, fields[2])
+			case "/quit":
+				return
+			default:
+				fmt.Println(help)
+			}
+		}
+		if err != nil {
+			fmt.Println("error:", err)
+		}
+	}
+}
+
+func printEvent(e chat.Event) {
+	at := e.Time.Format("15:04")
+	switch e.Kind {
+	case chat.MessageEvent:
+		fmt.Printf("%s [%s] <%s> %s\n", at, e.Room, e.From, e.Text)
+	case chat.PrivateEvent:
+		fmt.Printf("%s *%s* %s\n", at, e.From, e.Text)
+	case chat.JoinEvent:
+		fmt.Printf("%s [%s] %s joined\n", at, e.Room, e.From)
+	case chat.LeaveEvent:
+		fmt.Printf("%s [%s] %s left\n", at, e.Room, e.From)
+	}
+}
+
+func main() {
+	serve := flag.Bool("serve", false, "Run the chat server")
+	addr := flag.String("addr", ":8080", "Server address")
+	nick := flag.String("nick", "", "Nickname to log in with")
+	flag.Parse()
+
+	if *serve {
+		server(*addr)
+		return
+	}
+	if *nick == "" {
+		fmt.Println("usage: go run chat.go -serve | -nick <name>")
+		return
+	}
+	client(*addr, *nick)
+}
@@ -0,0 +1,239 @@
+package chat
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"concurrency/leakcheck"
+	"packages/protocol"
+)
+
+func startServer(t *testing.T) (*protocol.Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &protocol.Server{Handler: NewServer()}
+	go s.Serve(l)
+	return s, l.Addr().String()
+}
+
+func login(t *testing.T, addr, nick string, codec protocol.Codec) *Client {
+	c, err := Dial(addr, nick, protocol.Options{Codecs: []protocol.Codec{codec}})
+	if err != nil {
+		t.Fatalf("%s: %v", nick, err)
+	}
+	return c
+}
+
+// expect waits for the next event on c and checks it.
+func expect(t *testing.T, c *Client, kind EventKind, room, from, text string) {
+	t.Helper()
+	select {
+	case e := <-c.Events:
+		if e.Kind != kind || e.Room != room || e.From != from || e.Text != text {
+			t.Errorf("Expected: %s %s %s %q, got: %s %s %s %q", kind, room, from, text, e.Kind, e.Room, e.From, e.Text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected: %s %s %s %q, got nothing", kind, room, from, text)
+	}
+}
+
+func TestChat(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s, addr := startServer(t)
+	defer s.Close()
+
+	// Each client speaks a different codec to the same server.
+	alice := login(t, addr, "alice", protocol.Gob)
+	defer alice.Close()
+	expect(t, alice, JoinEvent, Lobby, "alice", "")
+
+	bob := login(t, addr, "bob", protocol.JSONLines)
+	defer bob.Close()
+	expect(t, bob, JoinEvent, Lobby, "bob", "")
+	expect(t, alice, JoinEvent, Lobby, "bob", "")
+
+	carol := login(t, addr, "carol", protocol.Binary)
+	expect(t, carol, JoinEvent, Lobby, "carol", "")
+	expect(t, alice, JoinEvent, Lobby, "carol", "")
+	expect(t, bob, JoinEvent, Lobby, "carol", "")
+
+	// A room only its members hear.
+	if err := alice.Create("go"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, alice, JoinEvent, "go", "alice", "")
+	if err := bob.Join("go"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, alice, JoinEvent, "go", "bob", "")
+	expect(t, bob, JoinEvent, "go", "bob", "")
+
+	if err := bob.Say("go", "goroutines!"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, alice, MessageEvent, "go", "bob", "goroutines!")
+	expect(t, bob, MessageEvent, "go", "bob", "goroutines!")
+
+	// Private message.
+	if err := carol.Msg("alice", "psst"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, alice, PrivateEvent, "", "carol", "psst")
+
+	rooms, err := carol.Rooms()
+	if err != nil || len(rooms) != 2 || rooms[0] != "go" || rooms[1] != Lobby {
+		t.Errorf("Expected: [go lobby], got: %v, %v", rooms, err)
+	}
+
+	// Disconnecting leaves every room.
+	carol.Close()
+	expect(t, alice, LeaveEvent, Lobby, "carol", "")
+	expect(t, bob, LeaveEvent, Lobby, "carol", "")
+
+	if err := bob.Leave("go"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, alice, LeaveEvent, "go", "bob", "")
+	expect(t, bob, LeaveEvent, "go", "bob", "")
+
+	select {
+	case e := <-bob.Events:
+		t.Errorf("Expected no more events for bob, got: %+v", e)
+	default:
+	}
+}
+
+func TestErrors(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s, addr := startServer(t)
+	defer s.Close()
+
+	alice := login(t, addr, "alice", protocol.Gob)
+	defer alice.Close()
+
+	if _, err := Dial(addr, "alice", protocol.Options{}); err == nil || err.Error() != ErrNickTaken.Error() {
+		t.Errorf("Expected: %v, got: %v", ErrNickTaken, err)
+	}
+	if _, err := Dial(addr, "two words", protocol.Options{}); err == nil || err.Error() != ErrBadName.Error() {
+		t.Errorf("Expected: %v, got: %v", ErrBadName, err)
+	}
+
+	type testPair struct {
+		err  error
+		want error
+	}
+	tests := []testPair{
+		{alice.Join("nowhere"), ErrNoRoom},
+		{alice.Create(Lobby), ErrRoomExists},
+		{alice.Say("nowhere", "hi"), ErrNotInRoom},
+		{alice.Leave("nowhere"), ErrNotInRoom},
+		{alice.Msg("nobody", "hi"), ErrNoUser},
+	}
+	for _, pair := range tests {
+		if pair.err == nil || pair.err.Error() != pair.want.Error() {
+			t.Errorf("Expected: %v, got: %v", pair.want, pair.err)
+		}
+	}
+}
+
+// TestNickReuse logs in with the nick of a client that has just gone,
+// while the server may still be logging the old one out, and then plays
+// the old session's late requests against the new one.
+func TestNickReuse(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s, addr := startServer(t)
+	defer s.Close()
+	srv := s.Handler.(*Server)
+
+	for i := 0; i < 20; i++ {
+		old := login(t, addr, "ann", protocol.Gob)
+		old.Create("room" + strconv.Itoa(i))
+		srv.mu.Lock()
+		stale := srv.users["ann"]
+		srv.mu.Unlock()
+		old.Close()
+
+		var c *Client
+		for {
+			var err error
+			c, err = Dial(addr, "ann", protocol.Options{})
+			if err == nil {
+				break
+			}
+			if err.Error() != ErrNickTaken.Error() {
+				t.Fatal(err)
+			}
+		}
+		if err := srv.leave(stale, Lobby); err != ErrNotInRoom {
+			t.Errorf("Expected: %v, got: %v", ErrNotInRoom, err)
+		}
+		if err := srv.join(stale, Lobby, false); err != ErrNotLoggedIn {
+			t.Errorf("Expected: %v, got: %v", ErrNotLoggedIn, err)
+		}
+		// Still in the lobby: the old session's leaving must not have
+		// taken the new one with it.
+		if err := c.Say(Lobby, "back"); err != nil {
+			t.Fatalf("Expected: still in the lobby, got: %v", err)
+		}
+		expect(t, c, JoinEvent, Lobby, "ann", "")
+		expect(t, c, MessageEvent, Lobby, "ann", "back")
+		c.Close()
+	}
+}
+
+// TestUndrainedEvents checks that calls keep working for a client that
+// never reads its events.
+func TestUndrainedEvents(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s, addr := startServer(t)
+	defer s.Close()
+
+	c := login(t, addr, "ann", protocol.Gob)
+	defer c.Close()
+	for i := 0; i < EventBuffer*2; i++ {
+		if err := c.Say(Lobby, "hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Rooms(); err != nil {
+		t.Fatal(err)
+	}
+	// The join and every echo came back; the overflow was dropped.
+	if got, want := c.Dropped(), uint64(EventBuffer*2+1-EventBuffer); got != want {
+		t.Errorf("Expected: %d dropped, got: %d", want, got)
+	}
+}
+
+// TestEventsClosed ranges over Events across a server shutdown.
+func TestEventsClosed(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s, addr := startServer(t)
+
+	c := login(t, addr, "ann", protocol.Gob)
+	defer c.Close()
+	done := make(chan []Event)
+	go func() {
+		var got []Event
+		for e := range c.Events {
+			got = append(got, e)
+		}
+		done <- got
+	}()
+	if err := c.Say(Lobby, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	select {
+	case got := <-done:
+		if len(got) != 2 || got[1].Kind != MessageEvent || got[1].Text != "bye" {
+			t.Errorf("Expected: the join and the message, got: %+v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected: Events closed with the connection, got: still open")
+	}
+}
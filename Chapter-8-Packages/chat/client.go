@@ -0,0 +1,125 @@
+package chat
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"packages/protocol"
+)
+
+// EventBuffer is how many events Client.Events holds before it starts
+// dropping them.
+const EventBuffer = 64
+
+// Client is a logged-in chat user. Events pushed by the server arrive on
+// Events, which is closed once the connection is gone, so ranging over it
+// ends with the session.
+//
+// Events and replies come in on the same connection, so a full Events
+// must not stop the client from reading: when the caller falls
+// EventBuffer events behind, new events are dropped and counted in
+// Dropped, and calls keep working.
+type Client struct {
+	conn    *protocol.Conn
+	Events  <-chan Event
+	dropped uint64
+
+	mu     sync.Mutex // guards sending on and closing events
+	closed bool
+}
+
+// Dial connects to the chat server at addr and logs in as nick.
+func Dial(addr, nick string, opts protocol.Options) (*Client, error) {
+	events := make(chan Event, EventBuffer)
+	c := &Client{Events: events}
+	handler := protocol.HandlerFunc(func(_ *protocol.Conn, m *protocol.Message) (interface{}, error) {
+		if e, ok := m.Payload.(Event); ok && m.Type == EventType {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.closed {
+				return nil, nil
+			}
+			select {
+			case events <- e:
+			default:
+				atomic.AddUint64(&c.dropped, 1)
+			}
+		}
+		return nil, nil
+	})
+	conn, err := protocol.Dial(addr, handler, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.conn = conn
+	go func() {
+		<-conn.Done()
+		c.mu.Lock()
+		c.closed = true
+		close(events)
+		c.mu.Unlock()
+	}()
+	if err := c.call(LoginType, Login{Nick: nick}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) call(typ string, payload interface{}) error {
+	_, err := c.conn.Call(context.Background(), typ, payload)
+	return err
+}
+
+// Create makes a new room and joins it.
+func (c *Client) Create(room string) error {
+	return c.call(CreateType, RoomRequest{Room: room})
+}
+
+// Join enters an existing room.
+func (c *Client) Join(room string) error {
+	return c.call(JoinType, RoomRequest{Room: room})
+}
+
+// Leave exits a room.
+func (c *Client) Leave(room string) error {
+	return c.call(LeaveType, RoomRequest{Room: room})
+}
+
+// Say sends text to everyone in room.
+func (c *Client) Say(room, text string) error {
+	return c.call(SayType, Say{Room: room, Text: text})
+}
+
+// Msg sends text privately to the user nick.
+func (c *Client) Msg(nick, text string) error {
+	return c.call(PrivType, Private{To: nick, Text: text})
+}
+
+// Rooms lists every room on the server.
+func (c *Client) Rooms() ([]string, error) {
+	v, err := c.conn.Call(context.Background(), RoomsType, nil)
+	if err != nil {
+		return nil, err
+	}
+	rooms, _ := v.([]string)
+	return rooms, nil
+}
+
+// Dropped returns how many events were thrown away because Events was
+// full.
+func (c *Client) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// Done is closed when the connection to the server is gone.
+func (c *Client) Done() <-chan struct{} {
+	return c.conn.Done()
+}
+
+// Close disconnects; the server tells the user's rooms they left.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"time"
+
+	"packages/protocol"
+)
+
+// Message types, used as protocol.Message.Type. All but EventType are
+// requests from a client; EventType is pushed by the server.
+const (
+	LoginType  = "chat.login"
+	CreateType = "chat.create"
+	JoinType   = "chat.join"
+	LeaveType  = "chat.leave"
+	RoomsType  = "chat.rooms"
+	SayType    = "chat.say"
+	PrivType   = "chat.msg"
+	EventType  = "chat.event"
+)
+
+// Lobby is the room everyone joins on login. It is never removed.
+const Lobby = "lobby"
+
+type Login struct {
+	Nick string
+}
+
+type RoomRequest struct {
+	Room string
+}
+
+type Say struct {
+	Room string
+	Text string
+}
+
+type Private struct {
+	To   string
+	Text string
+}
+
+// EventKind says what happened.
+type EventKind string
+
+const (
+	MessageEvent EventKind = "message"
+	PrivateEvent EventKind = "private"
+	JoinEvent    EventKind = "join"
+	LeaveEvent   EventKind = "leave"
+)
+
+// Event is pushed to every client that should see it: room members for
+// messages, joins and leaves, the recipient for private messages.
+type Event struct {
+	Kind EventKind
+	Room string
+	From string
+	Text string
+	Time time.Time
+}
+
+func init() {
+	protocol.Register(Login{})
+	protocol.Register(RoomRequest{})
+	protocol.Register(Say{})
+	protocol.Register(Private{})
+	protocol.Register(Event{})
+}
@@ -0,0 +1,246 @@
+package chat
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"packages/protocol"
+)
+
+var (
+	ErrNotLoggedIn = errors.New("chat: log in first")
+	ErrNickTaken   = errors.New("chat: nickname is taken")
+	ErrBadName     = errors.New("chat: names must be 1-32 characters without spaces")
+	ErrNoRoom      = errors.New("chat: no such room")
+	ErrRoomExists  = errors.New("chat: room already exists")
+	ErrNotInRoom   = errors.New("chat: not in that room")
+	ErrNoUser      = errors.New("chat: no such user")
+)
+
+type user struct {
+	nick  string
+	conn  *protocol.Conn
+	rooms map[string]bool
+}
+
+// Server is a protocol.Handler that keeps track of users and rooms.
+// Register it as the Handler of a protocol.Server.
+type Server struct {
+	mu    sync.Mutex
+	users map[string]*user
+	conns map[*protocol.Conn]*user
+	rooms map[string]map[string]bool // room name -> member nicks
+	now   func() time.Time
+}
+
+// NewServer returns a chat server with an empty lobby.
+func NewServer() *Server {
+	return &Server{
+		users: make(map[string]*user),
+		conns: make(map[*protocol.Conn]*user),
+		rooms: map[string]map[string]bool{Lobby: {}},
+		now:   time.Now,
+	}
+}
+
+func (s *Server) ServeMessage(c *protocol.Conn, m *protocol.Message) (interface{}, error) {
+	if m.Type == LoginType {
+		req, _ := m.Payload.(Login)
+		return nil, s.login(c, req.Nick)
+	}
+
+	s.mu.Lock()
+	u := s.conns[c]
+	s.mu.Unlock()
+	if u == nil {
+		return nil, ErrNotLoggedIn
+	}
+
+	switch m.Type {
+	case CreateType:
+		req, _ := m.Payload.(RoomRequest)
+		return nil, s.join(u, req.Room, true)
+	case JoinType:
+		req, _ := m.Payload.(RoomRequest)
+		return nil, s.join(u, req.Room, false)
+	case LeaveType:
+		req, _ := m.Payload.(RoomRequest)
+		return nil, s.leave(u, req.Room)
+	case RoomsType:
+		return s.roomList(), nil
+	case SayType:
+		req, _ := m.Payload.(Say)
+		return nil, s.say(u, req)
+	case PrivType:
+		req, _ := m.Payload.(Private)
+		return nil, s.private(u, req)
+	}
+	return nil, errors.New("chat: unknown message " + m.Type)
+}
+
+func validName(name string) bool {
+	return len(name) > 0 && len(name) <= 32 && !strings.ContainsAny(name, " \t\r\n")
+}
+
+func (s *Server) login(c *protocol.Conn, nick string) error {
+	if !validName(nick) {
+		return ErrBadName
+	}
+	s.mu.Lock()
+	if s.conns[c] != nil {
+		s.mu.Unlock()
+		return errors.New("chat: already logged in")
+	}
+	if s.users[nick] != nil {
+		s.mu.Unlock()
+		return ErrNickTaken
+	}
+	u := &user{nick: nick, conn: c, rooms: make(map[string]bool)}
+	s.users[nick] = u
+	s.conns[c] = u
+	s.mu.Unlock()
+
+	go func() {
+		<-c.Done()
+		s.logout(u)
+	}()
+	return s.join(u, Lobby, false)
+}
+
+// logout removes a disconnected user and tells their rooms. The user
+// leaves every room under the same lock that frees the nick, so a new
+// login with that nick cannot be caught up in it.
+func (s *Server) logout(u *user) {
+	s.mu.Lock()
+	delete(s.users, u.nick)
+	delete(s.conns, u.conn)
+	rooms := make([]string, 0, len(u.rooms))
+	for room := range u.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	tos := make([][]*protocol.Conn, len(rooms))
+	for i, room := range rooms {
+		tos[i] = s.part(u, room)
+	}
+	s.mu.Unlock()
+
+	for i, room := range rooms {
+		s.broadcast(tos[i], Event{Kind: LeaveEvent, Room: room, From: u.nick})
+	}
+}
+
+func (s *Server) join(u *user, room string, create bool) error {
+	if !validName(room) {
+		return ErrBadName
+	}
+	s.mu.Lock()
+	// The connection may have closed since the request came in.
+	if s.conns[u.conn] != u {
+		s.mu.Unlock()
+		return ErrNotLoggedIn
+	}
+	members, ok := s.rooms[room]
+	switch {
+	case create && ok:
+		s.mu.Unlock()
+		return ErrRoomExists
+	case !create && !ok:
+		s.mu.Unlock()
+		return ErrNoRoom
+	case create:
+		members = make(map[string]bool)
+		s.rooms[room] = members
+	}
+	members[u.nick] = true
+	u.rooms[room] = true
+	to := s.members(room)
+	s.mu.Unlock()
+
+	s.broadcast(to, Event{Kind: JoinEvent, Room: room, From: u.nick})
+	return nil
+}
+
+func (s *Server) leave(u *user, room string) error {
+	s.mu.Lock()
+	if !u.rooms[room] {
+		s.mu.Unlock()
+		return ErrNotInRoom
+	}
+	to := s.part(u, room)
+	s.mu.Unlock()
+
+	// The one leaving sees the notice too, as confirmation.
+	s.broadcast(append(to, u.conn), Event{Kind: LeaveEvent, Room: room, From: u.nick})
+	return nil
+}
+
+// part takes u out of room, dropping the room once it is empty, and
+// returns who is left to tell. s.mu must be held.
+func (s *Server) part(u *user, room string) []*protocol.Conn {
+	members := s.rooms[room]
+	delete(members, u.nick)
+	delete(u.rooms, room)
+	if len(members) == 0 && room != Lobby {
+		delete(s.rooms, room)
+	}
+	return s.members(room)
+}
+
+func (s *Server) say(u *user, req Say) error {
+	s.mu.Lock()
+	if !u.rooms[req.Room] {
+		s.mu.Unlock()
+		return ErrNotInRoom
+	}
+	to := s.members(req.Room)
+	s.mu.Unlock()
+
+	s.broadcast(to, Event{Kind: MessageEvent, Room: req.Room, From: u.nick, Text: req.Text})
+	return nil
+}
+
+func (s *Server) private(u *user, req Private) error {
+	s.mu.Lock()
+	to := s.users[req.To]
+	s.mu.Unlock()
+	if to == nil {
+		return ErrNoUser
+	}
+	s.broadcast([]*protocol.Conn{to.conn}, Event{Kind: PrivateEvent, From: u.nick, Text: req.Text})
+	return nil
+}
+
+func (s *Server) roomList() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rooms := make([]string, 0, len(s.rooms))
+	for room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// members returns the connections in room. s.mu must be held.
+func (s *Server) members(room string) []*protocol.Conn {
+	var conns []*protocol.Conn
+	for nick := range s.rooms[room] {
+		if u := s.users[nick]; u != nil {
+			conns = append(conns, u.conn)
+		}
+	}
+	return conns
+}
+
+// broadcast sends an event without holding the lock, so one slow client
+// does not hold up the rest of the server.
+func (s *Server) broadcast(to []*protocol.Conn, e Event) {
+	e.Time = s.now()
+	for _, c := range to {
+		c.Notify(EventType, e)
+	}
+}
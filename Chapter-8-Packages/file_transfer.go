@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"packages/protocol"
+	"packages/transfer"
+)
+
+// Sends files to a receiving server, resuming where it left off if the
+// connection drops. Start the receiver with
+//
+//	go run file_transfer.go -serve -dir received
+//
+// and send with
+//
+//	go run file_transfer.go send big.iso
+
+func server(addr, dir string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Println(err)
+		return
+	}
+	srv := &protocol.Server{Handler: transfer.NewReceiver(dir)}
+	fmt.Println("Receiving into", dir)
+	err := srv.ListenAndServe(addr)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func send(addr, path string, chunkSize int) {
+	opts := transfer.Options{
+		ChunkSize: chunkSize,
+		Progress: func(offset, size int64) {
+			percent := 100.0
+			if size > 0 {
+				percent = float64(offset) * 100 / float64(size)
+			}
+			fmt.Printf("\r%s: %d/%d bytes (%.0f%%)", path, offset, size, percent)
+		},
+	}
+	err := transfer.SendFile(context.Background(), addr, path, protocol.Options{}, opts)
+	fmt.Println()
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Println("sent and verified")
+}
+
+func main() {
+	serve := flag.Bool("serve", false, "Run the receiving server")
+	addr := flag.String("addr", "127.0.0.1:8080", "Server address")
+	dir := flag.String("dir", "received", "Directory received files are stored in")
+	chunkSize := flag.Int("chunk", 256<<10, "Bytes per chunk")
+	flag.Parse()
+
+	if *serve {
+		server(*addr, *dir)
+		return
+	}
+	if flag.Arg(0) != "send" || flag.NArg() < 2 {
+		fmt.Println("usage: go run file_transfer.go -serve | send <file>...")
+		return
+	}
+	for _, path := range flag.Args()[1:] {
+		send(*addr, path, *chunkSize)
+	}
+}
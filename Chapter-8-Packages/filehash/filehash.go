@@ -0,0 +1,27 @@
+// Package filehash hashes whole files, as getHash in hashes_crytography.go
+// first did with crc32, with any hash.Hash.
+package filehash
+
+import (
+	"hash"
+	"io"
+	"os"
+)
+
+// File feeds the contents of the named file to h and returns the sum.
+func File(name string, h hash.Hash) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Reader(f, h)
+}
+
+// Reader feeds everything in r to h and returns the sum.
+func Reader(r io.Reader, h hash.Hash) ([]byte, error) {
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
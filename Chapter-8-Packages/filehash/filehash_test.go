@@ -0,0 +1,33 @@
+package filehash
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	data := []byte("test")
+	path := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sum, err := File(path, sha256.New())
+	want := sha256.Sum256(data)
+	if err != nil || !bytes.Equal(sum, want[:]) {
+		t.Errorf("Expected: %x, got: %x %v", want, sum, err)
+	}
+
+	sum, _ = File(path, crc32.NewIEEE())
+	if crc := crc32.ChecksumIEEE(data); len(sum) != 4 || uint32(sum[0])<<24|uint32(sum[1])<<16|uint32(sum[2])<<8|uint32(sum[3]) != crc {
+		t.Errorf("Expected: %x, got: %x", crc, sum)
+	}
+
+	if _, err := File(filepath.Join(t.TempDir(), "missing"), sha256.New()); !os.IsNotExist(err) {
+		t.Errorf("Expected: not exist error, got: %v", err)
+	}
+}
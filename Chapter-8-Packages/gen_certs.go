@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"packages/tlsconfig"
+	"strings"
+)
+
+// Generates a development CA and a server and client certificate signed by
+// it, for the -tls flag of tcp_servers.go, rpc_server.go and
+// http_servers.go:
+//
+//	go run gen_certs.go -dir certs
+//	go run tcp_servers.go -tls certs -mtls
+
+func main() {
+	dir := flag.String("dir", "certs", "Directory to write the PEM files to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,::1", "Comma-separated names and IPs for the server certificate")
+	flag.Parse()
+
+	err := tlsconfig.GenerateDev(*dir, strings.Split(*hosts, ","))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Wrote ca.pem, server.pem, client.pem and their keys to", *dir)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"packages/stubgen"
+)
+
+// Generates a typed client stub for an RPC service from the Go source of
+// its receiver type, so callers write stub.Negate(99) instead of
+// client.Call("Server.Negate", int64(99), &reply):
+//
+//	go run gen_stubs.go -file rpc_server.go -type Server -package stubs -o stubs/server_stub.go
+//
+// Every method registered by net/rpc gets a stub method; the others are
+// skipped. When the stub goes in another package, types from the source
+// package are qualified with its name and -import must give its path.
+
+func main() {
+	file := flag.String("file", "rpc_server.go", "Go file declaring the service's methods")
+	typeName := flag.String("type", "Server", "Receiver type of the methods")
+	service := flag.String("service", "", "Name the service is registered under (default: -type)")
+	pkg := flag.String("package", "", "Package of the stub (default: the source's)")
+	importPath := flag.String("import", "", "Import path of the source's package, when -package differs")
+	output := flag.String("o", "", "File to write (default: standard output)")
+	flag.Parse()
+
+	if *service == "" {
+		*service = *typeName
+	}
+	src, err := stubgen.Generate(*file, *typeName, *service, *pkg, *importPath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *output == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*output, src, 0644); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Wrote the", *service, "stub to", *output)
+}
@@ -0,0 +1,13 @@
+module packages
+
+go 1.25.5
+
+require (
+	concurrency v0.0.0
+	packages_example v0.0.0
+)
+
+replace (
+	concurrency => ../Chapter-10-Concurrency
+	packages_example => ./creating_packages
+)
@@ -2,10 +2,11 @@ package main
 
 import (
 	"crypto/sha1"
+	"encoding/binary"
 	"fmt"
 	"hash/crc32"
-	"io"
 	"os"
+	"packages/filehash"
 )
 
 func main() {
@@ -39,24 +40,11 @@ func test_getHash() {
 }
 
 func getHash(fileName string) (uint32, error) {
-	f, err := os.Open(fileName)
+	sum, err := filehash.File(fileName, crc32.NewIEEE())
 	if err != nil {
 		return 0, err
 	}
-	defer func(f *os.File) {
-		err := f.Close()
-		if err != nil {
-			fmt.Println(err)
-		}
-		fmt.Println("file close ok")
-	}(f)
-
-	h := crc32.NewIEEE()
-	_, err = io.Copy(h, f)
-	if err != nil {
-		return 0, err
-	}
-	return h.Sum32(), nil
+	return binary.BigEndian.Uint32(sum), nil
 }
 
 func crc32Hash() {
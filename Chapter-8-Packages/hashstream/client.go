@@ -0,0 +1,19 @@
+package hashstream
+
+import (
+	"context"
+
+	"packages/rpcx"
+)
+
+// OpenWalk starts receiving the hashes of the files under dir on the
+// server.
+func OpenWalk(ctx context.Context, c rpcx.Caller, dir string) (*rpcx.ServerStream[FileHash], error) {
+	return rpcx.OpenServerStream[Dir, FileHash](ctx, c, WalkName, Dir{Path: dir})
+}
+
+// OpenVerify starts sending hashes to check against the files under dir on
+// the server.
+func OpenVerify(ctx context.Context, c rpcx.Caller, dir string) (*rpcx.ClientStream[FileHash, Report], error) {
+	return rpcx.OpenClientStream[Dir, FileHash, Report](ctx, c, VerifyName, Dir{Path: dir}, 0)
+}
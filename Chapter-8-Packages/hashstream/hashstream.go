@@ -0,0 +1,197 @@
+// Package hashstream serves the SHA-256 of every file under a directory as
+// a stream, sent while the directory is still being walked, and checks a
+// client's stream of hashes against the server's own files.
+package hashstream
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"packages/filehash"
+	"packages/rpcx"
+)
+
+const (
+	// WalkName is the server-streaming service sending FileHashes.
+	WalkName = "FileHashes"
+	// VerifyName is the client-streaming service checking them.
+	VerifyName = "FileVerify"
+)
+
+var ErrBadDir = errors.New("hashstream: directory must be relative and inside the served root")
+
+// Dir is the arguments of both services: a directory relative to the root
+// being served, "." for all of it.
+type Dir struct {
+	Path string
+}
+
+// FileHash is one file, its path relative to the walked directory and
+// slash-separated.
+type FileHash struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// Report is the result of a verification.
+type Report struct {
+	Matched int
+	// Differ holds the paths whose contents differ, Missing those the
+	// server does not have and Extra those only the server has.
+	Differ, Missing, Extra []string
+}
+
+// Service hashes files under root.
+type Service struct {
+	root string
+}
+
+// Register serves the files under root as WalkName and VerifyName on s.
+func Register(s rpcx.Registrar, root string, opts rpcx.StreamOptions) error {
+	svc := &Service{root: root}
+	if err := rpcx.ServeServerStream(s, WalkName, opts, svc.Walk); err != nil {
+		return err
+	}
+	return rpcx.ServeClientStream(s, VerifyName, opts, svc.Verify)
+}
+
+// open opens the directory d names for the length of one call. Everything
+// is read through an os.Root, so symlinks cannot lead outside the served
+// root.
+func (svc *Service) open(d Dir) (*os.Root, error) {
+	if !filepath.IsLocal(d.Path) && d.Path != "." {
+		return nil, ErrBadDir
+	}
+	root, err := os.OpenRoot(svc.root)
+	if err != nil || d.Path == "." {
+		return root, err
+	}
+	defer root.Close()
+	return root.OpenRoot(d.Path)
+}
+
+// Walk sends the hash of each regular file as it is reached, in lexical
+// order, stopping early if the stream is cancelled.
+func (svc *Service) Walk(ctx context.Context, d Dir, send func(FileHash) error) error {
+	dir, err := svc.open(d)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return WalkFS(dir.FS(), func(h FileHash) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return send(h)
+	})
+}
+
+// Verify compares the hashes received with the files under the directory.
+func (svc *Service) Verify(ctx context.Context, d Dir, recv func() (FileHash, error)) (Report, error) {
+	var report Report
+	dir, err := svc.open(d)
+	if err != nil {
+		return report, err
+	}
+	defer dir.Close()
+	fsys := dir.FS()
+	seen := make(map[string]bool)
+	for {
+		h, err := recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+		seen[h.Path] = true
+		mine, err := HashFS(fsys, h.Path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			report.Missing = append(report.Missing, h.Path)
+		case err != nil:
+			return report, err
+		case mine != h:
+			report.Differ = append(report.Differ, h.Path)
+		default:
+			report.Matched++
+		}
+	}
+	err = WalkFS(fsys, func(h FileHash) error {
+		if !seen[h.Path] {
+			report.Extra = append(report.Extra, h.Path)
+		}
+		return ctx.Err()
+	})
+	sort.Strings(report.Missing)
+	sort.Strings(report.Differ)
+	return report, err
+}
+
+// Walk calls fn with the hash of every regular file under dir.
+func Walk(dir string, fn func(FileHash) error) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return WalkFS(root.FS(), fn)
+}
+
+// WalkFS calls fn with the hash of every regular file in fsys. Symlinks
+// are skipped.
+func WalkFS(fsys fs.FS, fn func(FileHash) error) error {
+	return fs.WalkDir(fsys, ".", func(p string, e fs.DirEntry, err error) error {
+		if err != nil || !e.Type().IsRegular() {
+			return err
+		}
+		h, err := HashFS(fsys, p)
+		if err != nil {
+			return err
+		}
+		return fn(h)
+	})
+}
+
+// Hash hashes the file at the slash-separated rel under dir.
+func Hash(dir, rel string) (FileHash, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return FileHash{}, err
+	}
+	defer root.Close()
+	return HashFS(root.FS(), rel)
+}
+
+// HashFS hashes the file at rel in fsys. Like WalkFS, it takes only
+// regular files: a symlink is reported as not existing.
+func HashFS(fsys fs.FS, rel string) (FileHash, error) {
+	if !fs.ValidPath(rel) {
+		return FileHash{}, ErrBadDir
+	}
+	info, err := fs.Lstat(fsys, rel)
+	if err != nil {
+		return FileHash{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return FileHash{}, &fs.PathError{Op: "hash", Path: rel, Err: fs.ErrNotExist}
+	}
+	f, err := fsys.Open(rel)
+	if err != nil {
+		return FileHash{}, err
+	}
+	defer f.Close()
+	sum, err := filehash.Reader(f, sha256.New())
+	if err != nil {
+		return FileHash{}, err
+	}
+	return FileHash{Path: rel, Size: info.Size(), SHA256: hex.EncodeToString(sum)}, nil
+}
@@ -0,0 +1,168 @@
+package hashstream
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"concurrency/leakcheck"
+	"packages/rpcx"
+)
+
+func sum(data string) string {
+	s := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(s[:])
+}
+
+// tree writes files, given as path and contents, under a new directory.
+func tree(t *testing.T, files ...string) string {
+	dir := t.TempDir()
+	for i := 0; i < len(files); i += 2 {
+		name := filepath.Join(dir, filepath.FromSlash(files[i]))
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err := os.WriteFile(name, []byte(files[i+1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func serve(t *testing.T, root string) *rpc.Client {
+	s := rpcx.NewServer()
+	if err := Register(s, root, rpcx.StreamOptions{Window: 2, MaxBatch: 2}); err != nil {
+		t.Fatal(err)
+	}
+	server, client := net.Pipe()
+	go s.ServeConn(server)
+	return rpc.NewClient(client)
+}
+
+func TestWalk(t *testing.T) {
+	defer leakcheck.Check(t)()
+	root := tree(t, "a.txt", "a", "sub/b.txt", "bb", "sub/deeper/c.txt", "ccc")
+	c := serve(t, root)
+	defer c.Close()
+
+	type testPair struct {
+		dir  string
+		want string
+	}
+	tests := []testPair{
+		{".", fmt.Sprint([]FileHash{{"a.txt", 1, sum("a")}, {"sub/b.txt", 2, sum("bb")}, {"sub/deeper/c.txt", 3, sum("ccc")}})},
+		{"sub", fmt.Sprint([]FileHash{{"b.txt", 2, sum("bb")}, {"deeper/c.txt", 3, sum("ccc")}})},
+	}
+	for _, test := range tests {
+		st, err := OpenWalk(context.Background(), c, test.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []FileHash
+		for {
+			h, err := st.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, h)
+		}
+		if fmt.Sprint(got) != test.want {
+			t.Errorf("%s: Expected: %s, got: %v", test.dir, test.want, got)
+		}
+	}
+
+	for _, dir := range []string{"..", "/etc", "sub/../.."} {
+		st, _ := OpenWalk(context.Background(), c, dir)
+		if _, err := st.Recv(); err == nil || err.Error() != ErrBadDir.Error() {
+			t.Errorf("%s: Expected: %v, got: %v", dir, ErrBadDir, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	defer leakcheck.Check(t)()
+	server := tree(t, "same.txt", "x", "changed.txt", "old", "extra.txt", "e")
+	client := tree(t, "same.txt", "x", "changed.txt", "new", "gone/missing.txt", "m")
+	c := serve(t, server)
+	defer c.Close()
+
+	st, err := OpenVerify(context.Background(), c, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Walk(client, st.Send); err != nil {
+		t.Fatal(err)
+	}
+	report, err := st.CloseAndRecv()
+	want := fmt.Sprint(Report{Matched: 1, Differ: []string{"changed.txt"}, Missing: []string{"gone/missing.txt"}, Extra: []string{"extra.txt"}})
+	if got := fmt.Sprint(report); got != want || err != nil {
+		t.Errorf("Expected: %s <nil>, got: %s %v", want, got, err)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	defer leakcheck.Check(t)()
+	outside := tree(t, "secret.txt", "s")
+	root := tree(t, "a.txt", "a")
+	for _, link := range [][2]string{
+		{filepath.Join(outside, "secret.txt"), "leak.txt"},
+		{outside, "out"},
+		{"a.txt", "alias.txt"},
+	} {
+		if err := os.Symlink(link[0], filepath.Join(root, link[1])); err != nil {
+			t.Skip("no symlinks here:", err)
+		}
+	}
+	c := serve(t, root)
+	defer c.Close()
+
+	// Symlinks are skipped, even those that stay inside the root.
+	st, err := OpenWalk(context.Background(), c, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []FileHash
+	for {
+		h, err := st.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, h)
+	}
+	if want := fmt.Sprint([]FileHash{{"a.txt", 1, sum("a")}}); fmt.Sprint(got) != want {
+		t.Errorf("Expected: %s, got: %v", want, got)
+	}
+	st, _ = OpenWalk(context.Background(), c, "out")
+	if h, err := st.Recv(); err == nil || err == io.EOF {
+		t.Errorf("Expected: an error walking out of the root, got: %v", h)
+	}
+
+	// Verify does not follow them either.
+	verify, err := OpenVerify(context.Background(), c, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Send(FileHash{"leak.txt", 1, sum("s")})
+	verify.Send(FileHash{"alias.txt", 1, sum("a")})
+	report, err := verify.CloseAndRecv()
+	want := fmt.Sprint(Report{Missing: []string{"alias.txt", "leak.txt"}, Extra: []string{"a.txt"}})
+	if got := fmt.Sprint(report); got != want || err != nil {
+		t.Errorf("Expected: %s <nil>, got: %s %v", want, got, err)
+	}
+	verify, _ = OpenVerify(context.Background(), c, ".")
+	verify.Send(FileHash{"out/secret.txt", 1, sum("s")})
+	if report, err := verify.CloseAndRecv(); err == nil {
+		t.Errorf("Expected: an error reading out of the root, got: %+v", report)
+	}
+}
@@ -1,26 +1,89 @@
 package main
 
 import (
-	"io"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"packages/api"
+	"packages/middleware"
+	"packages/static"
+	"packages/tlsconfig"
+	"packages/views"
+	"packages/web"
+	"time"
 )
 
+// site renders the pages in the web package. Run with -tags dev to pick
+// up template edits without restarting.
+var site *views.Renderer
+
 func hello(res http.ResponseWriter, req *http.Request) {
-	res.Header().Set("Content-Type", "text/html")
-	io.WriteString(res, `<!DOCTYPE html>
-							<html>
-							<head>
-								<meta charset="utf-8">
-								<title>Hello World</title>
-							</head>
-								<body>
-									<h1>Hello World</h1>
-								</body>
-							</html>`)
+	name := req.URL.Query().Get("name")
+	if name == "" {
+		name = "World"
+	}
+	if err := site.Render(res, http.StatusOK, "hello", struct{ Name string }{name}); err != nil {
+		fmt.Println("hello:", err)
+	}
 }
 
 func main() {
+	certs := flag.String("tls", "", "Directory from gen_certs.go; serves HTTPS")
+	mtls := flag.Bool("mtls", false, "Require client certificates (with -tls)")
+	assets := flag.String("assets", "", "Serve /assets/ from this directory instead of the files built in")
+	listing := flag.Bool("listing", false, "List the contents of asset directories without an index.html")
+	flag.Parse()
+
+	var err error
+	site, err = views.New(views.Options{FS: web.Templates(), Reload: web.Dev})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	http.HandleFunc("/hello", hello)
-	http.Handle("/assets/", http.StripPrefix("/assets/", http.FileServer(http.Dir("assets"))))
-	http.ListenAndServe(":8080", nil)
+	if web.Dev {
+		fmt.Println("Reloading templates on every request")
+		// Shows off middleware.Recover; never part of a real server.
+		http.HandleFunc("/panic", func(res http.ResponseWriter, req *http.Request) {
+			panic("handler failed")
+		})
+	}
+	// The JSON API answers its own paths, errors included.
+	rest := api.Handler()
+	http.Handle("/stats", rest)
+	http.Handle("/shapes/", rest)
+
+	assetFS := web.Static()
+	if *assets != "" {
+		assetFS = os.DirFS(*assets)
+	}
+	http.Handle("/assets/", http.StripPrefix("/assets", static.Handler(assetFS, static.Options{Listing: *listing})))
+
+	// Every route goes through the same stack, outermost first.
+	logger := log.New(os.Stdout, "http: ", log.LstdFlags)
+	handler := middleware.Chain(http.DefaultServeMux,
+		middleware.RequestID(),
+		middleware.Logging(logger),
+		middleware.Recover(logger),
+		middleware.CORS(middleware.CORSOptions{Expose: []string{middleware.RequestIDHeader}}),
+		middleware.Timeout(10*time.Second),
+		middleware.Compress(gzip.DefaultCompression),
+	)
+
+	tlsConf, _, err := tlsconfig.LoadDev(*certs, *mtls)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	srv := &http.Server{Addr: ":8080", Handler: handler, TLSConfig: tlsConf}
+	if tlsConf != nil {
+		// The certificate is already in TLSConfig.
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	fmt.Println(err)
 }
@@ -0,0 +1,255 @@
+// Package jsonrpc2 serves the methods registered on a net/rpc Server as
+// JSON-RPC 2.0 over HTTP POST, for callers that cannot speak gob. Batches
+// are run concurrently and answered in order; notifications are run but
+// not answered.
+//
+// Methods keep their net/rpc names, such as "Calculator.Average". Params
+// may be the argument itself, an object, or a one-element array holding it
+// as net/rpc/jsonrpc clients send.
+package jsonrpc2
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/rpc"
+	"strings"
+	"sync"
+)
+
+// The error codes defined by the specification. Errors returned by the
+// methods themselves are reported as CodeServerError.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeServerError    = -32000
+)
+
+// MaxBodySize caps the size of a request body, batch included.
+const MaxBodySize = 1 << 20
+
+// MaxBatchWorkers caps how many calls from one batch run at once.
+const MaxBatchWorkers = 16
+
+// Error is the error member of a response.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc2: %s (%d)", e.Message, e.Code)
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var null = json.RawMessage("null")
+
+func errorResponse(id json.RawMessage, code int, msg string) *response {
+	if id == nil {
+		id = null
+	}
+	return &response{JSONRPC: "2.0", Error: &Error{Code: code, Message: msg}, ID: id}
+}
+
+// Server runs one request at a time from a codec, as *rpc.Server and
+// *rpcx.Server do. The codec also has RemoteAddr and TLS methods giving
+// the HTTP request's, which *rpcx.Server passes on to its interceptors.
+type Server interface {
+	ServeRequest(codec rpc.ServerCodec) error
+}
+
+// Handler returns an http.Handler calling the services registered on s.
+func Handler(s Server) http.Handler {
+	return &handler{server: s}
+}
+
+type handler struct {
+	server Server
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requests must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var reply interface{}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			reply = errorResponse(nil, CodeParseError, err.Error())
+		} else if len(batch) == 0 {
+			reply = errorResponse(nil, CodeInvalidRequest, "empty batch")
+		} else if responses := h.batch(r, batch); len(responses) > 0 {
+			reply = responses
+		}
+	} else if resp := h.call(r, body); resp != nil {
+		reply = resp
+	}
+
+	if reply == nil {
+		// Only notifications: nothing to say.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// batch runs the calls in parallel, at most MaxBatchWorkers at a time,
+// and returns the responses in request order, leaving out notifications.
+func (h *handler) batch(r *http.Request, batch []json.RawMessage) []*response {
+	results := make([]*response, len(batch))
+	sem := make(chan struct{}, MaxBatchWorkers)
+	var wg sync.WaitGroup
+	for i, raw := range batch {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, raw json.RawMessage) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = h.call(r, raw)
+		}(i, raw)
+	}
+	wg.Wait()
+
+	responses := make([]*response, 0, len(results))
+	for _, r := range results {
+		if r != nil {
+			responses = append(responses, r)
+		}
+	}
+	return responses
+}
+
+// call runs one request, returning nil for a notification.
+func (h *handler) call(r *http.Request, raw json.RawMessage) *response {
+	var req request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			return errorResponse(nil, CodeParseError, err.Error())
+		}
+		return errorResponse(nil, CodeInvalidRequest, err.Error())
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" || !validID(req.ID) {
+		return errorResponse(req.ID, CodeInvalidRequest, "not a JSON-RPC 2.0 request")
+	}
+
+	codec := &codec{req: &req, tls: r.TLS}
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		codec.remote = net.TCPAddrFromAddrPort(ap)
+	}
+	h.server.ServeRequest(codec)
+	if req.ID == nil {
+		return nil
+	}
+	return codec.resp
+}
+
+// validID accepts a string, a number, null or no id at all.
+func validID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+// codec is an rpc.ServerCodec for exactly one request, so the registered
+// services can be reused as they are.
+type codec struct {
+	req       *request
+	badParams bool
+	resp      *response
+	remote    net.Addr
+	tls       *tls.ConnectionState
+}
+
+func (c *codec) ReadRequestHeader(r *rpc.Request) error {
+	r.ServiceMethod = c.req.Method
+	r.Seq = 0
+	return nil
+}
+
+func (c *codec) ReadRequestBody(arg interface{}) error {
+	// net/rpc passes nil to discard the body of an unknown method.
+	if arg == nil || len(c.req.Params) == 0 || bytes.Equal(c.req.Params, null) {
+		return nil
+	}
+	params := c.req.Params
+	if params[0] == '[' {
+		var positional []json.RawMessage
+		if err := json.Unmarshal(params, &positional); err != nil || len(positional) != 1 {
+			c.badParams = true
+			return errors.New("params must hold exactly one argument")
+		}
+		params = positional[0]
+	}
+	if err := json.Unmarshal(params, arg); err != nil {
+		c.badParams = true
+		return err
+	}
+	return nil
+}
+
+func (c *codec) WriteResponse(r *rpc.Response, reply interface{}) error {
+	id := c.req.ID
+	switch {
+	case r.Error == "":
+		result, err := json.Marshal(reply)
+		if err != nil {
+			c.resp = errorResponse(id, CodeInternalError, err.Error())
+			return nil
+		}
+		c.resp = &response{JSONRPC: "2.0", Result: result, ID: id}
+	case c.badParams:
+		c.resp = errorResponse(id, CodeInvalidParams, r.Error)
+	case strings.HasPrefix(r.Error, "rpc: can't find") ||
+		strings.HasPrefix(r.Error, "rpc: service/method request ill-formed"):
+		c.resp = errorResponse(id, CodeMethodNotFound, r.Error)
+	default:
+		c.resp = errorResponse(id, CodeServerError, r.Error)
+	}
+	return nil
+}
+
+func (c *codec) RemoteAddr() net.Addr { return c.remote }
+
+func (c *codec) TLS() *tls.ConnectionState { return c.tls }
+
+func (c *codec) Close() error { return nil }
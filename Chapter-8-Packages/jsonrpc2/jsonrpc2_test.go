@@ -0,0 +1,176 @@
+package jsonrpc2
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"packages/calculator"
+	"packages/rpcx"
+)
+
+func post(t *testing.T, url, body string) (int, string) {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(b))
+}
+
+func TestHandler(t *testing.T) {
+	s := rpc.NewServer()
+	calculator.Register(s)
+	srv := httptest.NewServer(Handler(s))
+	defer srv.Close()
+
+	type testPair struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}
+	tests := []testPair{
+		{"object params",
+			`{"jsonrpc":"2.0","method":"Calculator.Average","params":{"Xs":[1,2,3]},"id":1}`,
+			200, `{"jsonrpc":"2.0","result":{"Value":2},"id":1}`},
+		{"array params",
+			`{"jsonrpc":"2.0","method":"Calculator.Add","params":[{"A":1,"B":2}],"id":"a"}`,
+			200, `{"jsonrpc":"2.0","result":{"Value":3},"id":"a"}`},
+		{"null id",
+			`{"jsonrpc":"2.0","method":"Calculator.Mul","params":{"A":2,"B":2},"id":null}`,
+			200, `{"jsonrpc":"2.0","result":{"Value":4},"id":null}`},
+		{"method error",
+			`{"jsonrpc":"2.0","method":"Calculator.Max","params":{"Xs":[]},"id":2}`,
+			200, `{"jsonrpc":"2.0","error":{"code":-32000,"message":"calculator: no numbers given"},"id":2}`},
+		{"unknown method",
+			`{"jsonrpc":"2.0","method":"Calculator.Pow","id":3}`,
+			200, `{"jsonrpc":"2.0","error":{"code":-32601,"message":"rpc: can't find method Calculator.Pow"},"id":3}`},
+		{"ill-formed method",
+			`{"jsonrpc":"2.0","method":"Pow","id":4}`,
+			200, `{"jsonrpc":"2.0","error":{"code":-32601,"message":"rpc: service/method request ill-formed: Pow"},"id":4}`},
+		{"bad params",
+			`{"jsonrpc":"2.0","method":"Calculator.Add","params":[1,2],"id":5}`,
+			200, `{"jsonrpc":"2.0","error":{"code":-32602,"message":"params must hold exactly one argument"},"id":5}`},
+		{"wrong version",
+			`{"jsonrpc":"1.0","method":"Calculator.Add","id":6}`,
+			200, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"not a JSON-RPC 2.0 request"},"id":6}`},
+		{"parse error",
+			`{"jsonrpc":"2.0",`,
+			200, `{"jsonrpc":"2.0","error":{"code":-32700,"message":"unexpected end of JSON input"},"id":null}`},
+		{"empty batch",
+			`[]`,
+			200, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"empty batch"},"id":null}`},
+		{"notification",
+			`{"jsonrpc":"2.0","method":"Calculator.Add","params":{"A":1,"B":2}}`,
+			204, ``},
+		{"batch",
+			`[{"jsonrpc":"2.0","method":"Calculator.Min","params":{"Xs":[3,1]},"id":1},
+			  {"jsonrpc":"2.0","method":"Calculator.Add","params":{"A":1,"B":2}},
+			  {"jsonrpc":"2.0","method":"Calculator.Div","params":{"A":1,"B":0},"id":2},
+			  5]`,
+			200, `[{"jsonrpc":"2.0","result":{"Value":1},"id":1},` +
+				`{"jsonrpc":"2.0","error":{"code":-32000,"message":"calculator: division by zero"},"id":2},` +
+				`{"jsonrpc":"2.0","error":{"code":-32600,"message":"json: cannot unmarshal number into Go value of type jsonrpc2.request"},"id":null}]`},
+		{"batch of notifications",
+			`[{"jsonrpc":"2.0","method":"Calculator.Add","params":{"A":1,"B":2}}]`,
+			204, ``},
+	}
+	for _, test := range tests {
+		status, got := post(t, srv.URL, test.body)
+		if status != test.status || got != test.want {
+			t.Errorf("%s: Expected: %d %s, got: %d %s", test.name, test.status, test.want, status, got)
+		}
+	}
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected: %d, got: %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+// gauge is a Server that answers every call with null after a short
+// wait, recording the most calls it saw running at once.
+type gauge struct {
+	mu       sync.Mutex
+	running  int
+	most     int
+	requests int
+}
+
+func (g *gauge) ServeRequest(codec rpc.ServerCodec) error {
+	var req rpc.Request
+	if err := codec.ReadRequestHeader(&req); err != nil {
+		return err
+	}
+	codec.ReadRequestBody(nil)
+	g.mu.Lock()
+	g.requests++
+	g.running++
+	if g.running > g.most {
+		g.most = g.running
+	}
+	g.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	g.mu.Lock()
+	g.running--
+	g.mu.Unlock()
+	return codec.WriteResponse(&rpc.Response{ServiceMethod: req.ServiceMethod, Seq: req.Seq}, nil)
+}
+
+func TestBatchWorkers(t *testing.T) {
+	g := &gauge{}
+	srv := httptest.NewServer(Handler(g))
+	defer srv.Close()
+
+	calls := make([]string, 10*MaxBatchWorkers)
+	for i := range calls {
+		calls[i] = `{"jsonrpc":"2.0","method":"Gauge.Wait","id":1}`
+	}
+	status, _ := post(t, srv.URL, "["+strings.Join(calls, ",")+"]")
+	if status != http.StatusOK || g.requests != len(calls) {
+		t.Errorf("Expected: %d calls answered, got: %d calls, status %d", len(calls), g.requests, status)
+	}
+	if g.most > MaxBatchWorkers {
+		t.Errorf("Expected: at most %d calls at once, got: %d", MaxBatchWorkers, g.most)
+	}
+}
+
+// TestHandlerAuth checks that rpcx interceptors see who sent the HTTP
+// request.
+func TestHandlerAuth(t *testing.T) {
+	s := rpcx.NewServer(rpcx.Auth(rpcx.LocalOrCertified))
+	calculator.Register(s)
+	h := Handler(s)
+	body := `{"jsonrpc":"2.0","method":"Calculator.Add","params":{"A":1,"B":2},"id":1}`
+
+	type testPair struct {
+		remoteAddr string
+		want       string
+	}
+	tests := []testPair{
+		{"127.0.0.1:40000", `{"jsonrpc":"2.0","result":{"Value":3},"id":1}`},
+		{"[::1]:40000", `{"jsonrpc":"2.0","result":{"Value":3},"id":1}`},
+		{"192.0.2.1:40000", `{"jsonrpc":"2.0","error":{"code":-32000,"message":"` + rpcx.ErrUnauthorized.Error() + `"},"id":1}`},
+		{"", `{"jsonrpc":"2.0","error":{"code":-32000,"message":"` + rpcx.ErrUnauthorized.Error() + `"},"id":1}`},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/rpc", strings.NewReader(body))
+		r.RemoteAddr = test.remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := strings.TrimSpace(w.Body.String()); got != test.want {
+			t.Errorf("%q: Expected: %s, got: %s", test.remoteAddr, test.want, got)
+		}
+	}
+}
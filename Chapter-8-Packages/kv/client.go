@@ -0,0 +1,75 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	"packages/protocol"
+)
+
+// Client talks to a kv server over one protocol.Conn. It is safe for
+// concurrent use; requests are pipelined on the connection.
+type Client struct {
+	conn *protocol.Conn
+}
+
+// Dial connects to the kv server at addr.
+func Dial(addr string, opts protocol.Options) (*Client, error) {
+	conn, err := protocol.Dial(addr, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+// call sends a request and turns the store's own errors, which arrive as
+// plain text, back into the package's error values.
+func (c *Client) call(ctx context.Context, typ string, payload interface{}) (interface{}, error) {
+	v, err := c.conn.Call(ctx, typ, payload)
+	if remote, ok := err.(protocol.RemoteError); ok {
+		for _, known := range []error{ErrNotFound, ErrEmptyKey, ErrClosed} {
+			if string(remote) == known.Error() {
+				return nil, known
+			}
+		}
+	}
+	return v, err
+}
+
+// Get returns the value of key, or ErrNotFound.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	v, err := c.call(ctx, GetType, GetRequest{Key: key})
+	if err != nil {
+		return nil, err
+	}
+	value, _ := v.([]byte)
+	return value, nil
+}
+
+// Set stores value under key. A positive ttl makes the key expire.
+func (c *Client) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := c.call(ctx, SetType, SetRequest{Key: key, Value: value, TTL: ttl})
+	return err
+}
+
+// Delete removes key, or returns ErrNotFound.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	_, err := c.call(ctx, DeleteType, DeleteRequest{Key: key})
+	return err
+}
+
+// Scan returns one page of keys with the given prefix after the key after.
+// The server caps limit at 1000.
+func (c *Client) Scan(ctx context.Context, prefix, after string, limit int) ([]Pair, error) {
+	v, err := c.call(ctx, ScanType, ScanRequest{Prefix: prefix, After: after, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	reply, _ := v.(ScanReply)
+	return reply.Pairs, nil
+}
+
+// Close disconnects.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
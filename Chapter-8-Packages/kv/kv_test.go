@@ -0,0 +1,271 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"concurrency/clock"
+	"concurrency/leakcheck"
+	"packages/protocol"
+)
+
+func open(t *testing.T, dir string, opts Options) *Store {
+	s, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func expectValue(t *testing.T, s *Store, key, want string) {
+	t.Helper()
+	v, err := s.Get(key)
+	if err != nil || string(v) != want {
+		t.Errorf("Expected: %s=%q, got: %q %v", key, want, v, err)
+	}
+}
+
+func expectMissing(t *testing.T, s *Store, key string) {
+	t.Helper()
+	if v, err := s.Get(key); err != ErrNotFound {
+		t.Errorf("Expected: %v, got: %q %v", ErrNotFound, v, err)
+	}
+}
+
+func TestGetSetDelete(t *testing.T) {
+	s := open(t, t.TempDir(), Options{})
+	defer s.Close()
+
+	expectMissing(t, s, "a")
+	s.Set("a", []byte("1"), 0)
+	s.Set("a", []byte("2"), 0)
+	expectValue(t, s, "a", "2")
+
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	expectMissing(t, s, "a")
+	if err := s.Delete("a"); err != ErrNotFound {
+		t.Errorf("Expected: %v, got: %v", ErrNotFound, err)
+	}
+	if err := s.Set("", nil, 0); err != ErrEmptyKey {
+		t.Errorf("Expected: %v, got: %v", ErrEmptyKey, err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0))
+	dir := t.TempDir()
+	s := open(t, dir, Options{Clock: clk, CompactInterval: -1})
+
+	s.Set("session", []byte("x"), time.Minute)
+	s.Set("forever", []byte("y"), 0)
+	clk.Advance(59 * time.Second)
+	expectValue(t, s, "session", "x")
+	clk.Advance(time.Second)
+	expectMissing(t, s, "session")
+	if s.Len() != 1 {
+		t.Errorf("Expected: %d, got: %d", 1, s.Len())
+	}
+	s.Close()
+
+	// The expiry time is logged, so the key stays expired after a restart.
+	s = open(t, dir, Options{Clock: clk, CompactInterval: -1})
+	defer s.Close()
+	expectMissing(t, s, "session")
+	expectValue(t, s, "forever", "y")
+}
+
+func TestScan(t *testing.T) {
+	s := open(t, t.TempDir(), Options{Sync: SyncNever})
+	defer s.Close()
+	for i := 0; i < 5; i++ {
+		s.Set(fmt.Sprintf("user:%d", i), []byte{byte(i)}, 0)
+	}
+	s.Set("other", nil, 0)
+
+	var keys []string
+	after := ""
+	for {
+		page, err := s.Scan("user:", after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, p := range page {
+			keys = append(keys, p.Key)
+		}
+		after = page[len(page)-1].Key
+	}
+	want := "[user:0 user:1 user:2 user:3 user:4]"
+	if fmt.Sprint(keys) != want {
+		t.Errorf("Expected: %s, got: %v", want, keys)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, Options{CompactInterval: -1})
+	s.Set("a", []byte("1"), 0)
+	s.Set("b", []byte("2"), 0)
+	s.Delete("a")
+
+	// Open a second store on the same files without closing the first, as
+	// after a crash. Everything acknowledged must be there.
+	crashed := open(t, dir, Options{CompactInterval: -1})
+	expectMissing(t, crashed, "a")
+	expectValue(t, crashed, "b", "2")
+	crashed.Close()
+	s.Close()
+}
+
+// TestLargestRecord writes a key and value of the largest accepted size
+// and checks that it, and the write after it, survive a reopen.
+func TestLargestRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, Options{CompactInterval: -1})
+	value := make([]byte, maxRecord-len("big"))
+	if err := s.Set("big", value, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("big", append(value, 'x'), 0); err == nil {
+		t.Error("Expected: an error for a record over the limit, got: <nil>")
+	}
+	s.Set("after", []byte("1"), 0)
+
+	crashed := open(t, dir, Options{CompactInterval: -1})
+	if v, err := crashed.Get("big"); err != nil || len(v) != len(value) {
+		t.Errorf("Expected: %d bytes, got: %d %v", len(value), len(v), err)
+	}
+	expectValue(t, crashed, "after", "1")
+	crashed.Close()
+	s.Close()
+}
+
+func TestTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, Options{CompactInterval: -1})
+	s.Set("a", []byte("1"), 0)
+	s.Set("b", []byte("2"), 0)
+	s.Close()
+
+	// Cut the last record in half, as a crash in the middle of a write would.
+	path := filepath.Join(dir, walFile)
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	s = open(t, dir, Options{CompactInterval: -1})
+	expectValue(t, s, "a", "1")
+	expectMissing(t, s, "b")
+	// Writes after the cut must survive the next restart.
+	s.Set("c", []byte("3"), 0)
+	s.Close()
+
+	s = open(t, dir, Options{CompactInterval: -1})
+	defer s.Close()
+	expectValue(t, s, "a", "1")
+	expectValue(t, s, "c", "3")
+}
+
+func TestCompact(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0))
+	dir := t.TempDir()
+	s := open(t, dir, Options{Clock: clk, CompactInterval: time.Minute})
+	for i := 0; i < 100; i++ {
+		s.Set("counter", []byte(fmt.Sprint(i)), 0)
+	}
+	s.Set("temp", []byte("t"), time.Second)
+	clk.Advance(time.Second)
+
+	// The background goroutine compacts on its ticker.
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := os.Stat(filepath.Join(dir, walFile))
+		if err == nil && info.Size() == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected: log truncated by compaction, got: timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Set("after", []byte("a"), 0)
+	s.Close()
+
+	s = open(t, dir, Options{Clock: clk, CompactInterval: -1})
+	defer s.Close()
+	expectValue(t, s, "counter", "99")
+	expectValue(t, s, "after", "a")
+	expectMissing(t, s, "temp")
+	if s.Len() != 2 {
+		t.Errorf("Expected: %d, got: %d", 2, s.Len())
+	}
+}
+
+func TestCorruptSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, Options{})
+	s.Set("a", []byte("1"), 0)
+	s.Compact()
+	s.Close()
+
+	path := filepath.Join(dir, snapshotFile)
+	bs, _ := os.ReadFile(path)
+	bs[len(bs)-1] ^= 0xff
+	os.WriteFile(path, bs, 0644)
+	if _, err := Open(dir, Options{}); err == nil {
+		t.Error("Expected: error for corrupt snapshot, got: nil")
+	}
+}
+
+func TestClient(t *testing.T) {
+	defer leakcheck.Check(t)()
+	store := open(t, t.TempDir(), Options{})
+	defer store.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &protocol.Server{Handler: Handler(store)}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	for _, codec := range []protocol.Codec{protocol.Gob, protocol.Binary, protocol.JSON} {
+		c, err := Dial(l.Addr().String(), protocol.Options{Codecs: []protocol.Codec{codec}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		key := "greeting:" + codec.Name()
+		if err := c.Set(ctx, key, []byte("hello"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		v, err := c.Get(ctx, key)
+		if err != nil || string(v) != "hello" {
+			t.Errorf("%s: Expected: hello, got: %q %v", codec.Name(), v, err)
+		}
+		pairs, err := c.Scan(ctx, key, "", 0)
+		if err != nil || len(pairs) != 1 || pairs[0].Key != key {
+			t.Errorf("%s: Expected: [%s], got: %v %v", codec.Name(), key, pairs, err)
+		}
+		if err := c.Delete(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Get(ctx, key); err != ErrNotFound {
+			t.Errorf("%s: Expected: %v, got: %v", codec.Name(), ErrNotFound, err)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,119 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+const (
+	opSet    byte = 1
+	opDelete byte = 2
+)
+
+// maxPayload bounds a record's payload: a key and value of up to maxRecord
+// bytes plus the op byte, their two length prefixes and the expiry.
+const maxPayload = maxRecord + 1 + 3*binary.MaxVarintLen64
+
+// errCorrupt marks a record that failed its checksum or was cut short.
+var errCorrupt = errors.New("kv: corrupt record")
+
+// record is one change in the write-ahead log, or one key in a snapshot.
+// On disk it is framed as
+//
+//	length uint32 | crc32 uint32 | op byte | key | value | expires varint
+//
+// where key and value carry a uvarint length prefix and expires is in Unix
+// nanoseconds, 0 for keys without a TTL.
+type record struct {
+	op      byte
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func (r record) encode() []byte {
+	payload := []byte{r.op}
+	payload = binary.AppendUvarint(payload, uint64(len(r.key)))
+	payload = append(payload, r.key...)
+	payload = binary.AppendUvarint(payload, uint64(len(r.value)))
+	payload = append(payload, r.value...)
+	var expires int64
+	if !r.expires.IsZero() {
+		expires = r.expires.UnixNano()
+	}
+	payload = binary.AppendVarint(payload, expires)
+
+	frame := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	return append(frame, payload...)
+}
+
+// readRecord reads the next record. It returns io.EOF at a clean end of
+// file and errCorrupt for a torn or damaged record.
+func readRecord(r *bufio.Reader) (record, int, error) {
+	var header [8]byte
+	n, err := io.ReadFull(r, header[:])
+	if err == io.EOF {
+		return record{}, 0, io.EOF
+	}
+	if err != nil {
+		return record{}, n, errCorrupt
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > maxPayload {
+		return record{}, n, errCorrupt
+	}
+	payload := make([]byte, size)
+	m, err := io.ReadFull(r, payload)
+	n += m
+	if err != nil || crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return record{}, n, errCorrupt
+	}
+
+	rec, ok := decodePayload(payload)
+	if !ok {
+		return record{}, n, errCorrupt
+	}
+	return rec, n, nil
+}
+
+func decodePayload(p []byte) (record, bool) {
+	if len(p) < 1 {
+		return record{}, false
+	}
+	rec := record{op: p[0]}
+	p = p[1:]
+
+	field := func() ([]byte, bool) {
+		l, n := binary.Uvarint(p)
+		if n <= 0 || l > uint64(len(p)-n) {
+			return nil, false
+		}
+		f := p[n : n+int(l)]
+		p = p[n+int(l):]
+		return f, true
+	}
+	key, ok := field()
+	if !ok {
+		return record{}, false
+	}
+	value, ok := field()
+	if !ok {
+		return record{}, false
+	}
+	expires, n := binary.Varint(p)
+	if n <= 0 {
+		return record{}, false
+	}
+	rec.key = string(key)
+	rec.value = append([]byte(nil), value...)
+	if expires != 0 {
+		rec.expires = time.Unix(0, expires)
+	}
+	return rec, true
+}
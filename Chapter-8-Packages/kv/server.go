@@ -0,0 +1,78 @@
+package kv
+
+import (
+	"errors"
+	"time"
+
+	"packages/protocol"
+)
+
+// Message types, used as protocol.Message.Type.
+const (
+	GetType    = "kv.get"
+	SetType    = "kv.set"
+	DeleteType = "kv.delete"
+	ScanType   = "kv.scan"
+)
+
+type GetRequest struct {
+	Key string
+}
+
+type SetRequest struct {
+	Key   string
+	Value []byte
+	TTL   time.Duration
+}
+
+type DeleteRequest struct {
+	Key string
+}
+
+type ScanRequest struct {
+	Prefix string
+	After  string
+	Limit  int
+}
+
+type ScanReply struct {
+	Pairs []Pair
+}
+
+func init() {
+	protocol.Register(GetRequest{})
+	protocol.Register(SetRequest{})
+	protocol.Register(DeleteRequest{})
+	protocol.Register(ScanRequest{})
+	protocol.Register(ScanReply{})
+	protocol.Register(Pair{})
+}
+
+// maxScan caps the page a client can ask for in one request.
+const maxScan = 1000
+
+// Handler returns a protocol.Handler serving s. Register it as the Handler
+// of a protocol.Server.
+func Handler(s *Store) protocol.Handler {
+	return protocol.HandlerFunc(func(c *protocol.Conn, m *protocol.Message) (interface{}, error) {
+		switch m.Type {
+		case GetType:
+			req, _ := m.Payload.(GetRequest)
+			return s.Get(req.Key)
+		case SetType:
+			req, _ := m.Payload.(SetRequest)
+			return nil, s.Set(req.Key, req.Value, req.TTL)
+		case DeleteType:
+			req, _ := m.Payload.(DeleteRequest)
+			return nil, s.Delete(req.Key)
+		case ScanType:
+			req, _ := m.Payload.(ScanRequest)
+			if req.Limit <= 0 || req.Limit > maxScan {
+				req.Limit = maxScan
+			}
+			pairs, err := s.Scan(req.Prefix, req.After, req.Limit)
+			return ScanReply{Pairs: pairs}, err
+		}
+		return nil, errors.New("kv: unknown message " + m.Type)
+	})
+}
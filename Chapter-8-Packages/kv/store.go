@@ -0,0 +1,285 @@
+package kv
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"concurrency/clock"
+)
+
+var (
+	ErrNotFound = errors.New("kv: key not found")
+	ErrClosed   = errors.New("kv: store closed")
+	ErrEmptyKey = errors.New("kv: empty key")
+)
+
+// maxRecord bounds a single key plus value.
+const maxRecord = 32 << 20
+
+// SyncPolicy says when the write-ahead log is flushed to disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every write. Nothing acknowledged is lost.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs every Options.SyncInterval. A crash can lose the
+	// writes of the last interval.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+// Options configures a Store. Zero values pick the defaults.
+type Options struct {
+	Sync SyncPolicy
+	// SyncInterval is used with SyncInterval. Defaults to one second.
+	SyncInterval time.Duration
+	// CompactInterval is how often the log is folded into a snapshot.
+	// Negative disables background compaction. Defaults to five minutes.
+	CompactInterval time.Duration
+	Clock           clock.Clock
+}
+
+func (o *Options) setDefaults() {
+	if o.SyncInterval <= 0 {
+		o.SyncInterval = time.Second
+	}
+	if o.CompactInterval == 0 {
+		o.CompactInterval = 5 * time.Minute
+	}
+	if o.Clock == nil {
+		o.Clock = clock.New()
+	}
+}
+
+// Pair is a key and its value, as returned by Scan.
+type Pair struct {
+	Key   string
+	Value []byte
+}
+
+type entry struct {
+	value   []byte
+	expires time.Time
+}
+
+// Store is a key-value map kept in memory and made durable by a
+// write-ahead log in dir. Every change is appended to the log before it is
+// applied; Compact rewrites the live keys into a snapshot and empties the
+// log. Open replays both after a crash.
+type Store struct {
+	mu     sync.RWMutex
+	opts   Options
+	data   map[string]entry
+	wal    *wal
+	closed bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open loads the store in dir, creating it if needed.
+func Open(dir string, opts Options) (*Store, error) {
+	opts.setDefaults()
+	s := &Store{
+		opts: opts,
+		data: make(map[string]entry),
+		stop: make(chan struct{}),
+	}
+	w, err := openWAL(dir, s.apply)
+	if err != nil {
+		return nil, err
+	}
+	s.wal = w
+	s.removeExpired()
+
+	s.wg.Add(1)
+	go s.background()
+	return s, nil
+}
+
+// Get returns the value of key, or ErrNotFound if it is missing or expired.
+func (s *Store) Get(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	e, ok := s.data[key]
+	if !ok || s.expired(e) {
+		return nil, ErrNotFound
+	}
+	return e.value, nil
+}
+
+// Set stores value under key. A positive ttl makes the key expire.
+func (s *Store) Set(key string, value []byte, ttl time.Duration) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	if len(key)+len(value) > maxRecord {
+		return errors.New("kv: key and value too large")
+	}
+	rec := record{op: opSet, key: key, value: value}
+	if ttl > 0 {
+		rec.expires = s.opts.Clock.Now().Add(ttl)
+	}
+	return s.set(rec)
+}
+
+// Delete removes key, returning ErrNotFound if it was not there.
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	e, ok := s.data[key]
+	if !ok || s.expired(e) {
+		return ErrNotFound
+	}
+	return s.write(record{op: opDelete, key: key})
+}
+
+func (s *Store) set(rec record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	return s.write(rec)
+}
+
+// write logs rec and applies it. s.mu must be held.
+func (s *Store) write(rec record) error {
+	if err := s.wal.append(rec, s.opts.Sync == SyncAlways); err != nil {
+		return err
+	}
+	s.apply(rec)
+	return nil
+}
+
+// Scan returns up to limit live keys with the given prefix, in key order,
+// starting after the key after. Pass the last key of one page as after to
+// get the next. A limit of 0 or less means no limit.
+func (s *Store) Scan(prefix, after string, limit int) ([]Pair, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	var keys []string
+	for k, e := range s.data {
+		if strings.HasPrefix(k, prefix) && k > after && !s.expired(e) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	pairs := make([]Pair, len(keys))
+	for i, k := range keys {
+		pairs[i] = Pair{Key: k, Value: s.data[k].value}
+	}
+	return pairs, nil
+}
+
+// Len returns the number of live keys.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, e := range s.data {
+		if !s.expired(e) {
+			n++
+		}
+	}
+	return n
+}
+
+// Compact drops expired keys, writes the rest to a new snapshot and
+// truncates the log.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.removeExpired()
+	return s.wal.compact(s.data)
+}
+
+// Sync flushes the log to disk.
+func (s *Store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	return s.wal.sync()
+}
+
+// Close stops background work and flushes and closes the log.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	err := s.wal.close()
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Store) apply(rec record) {
+	switch rec.op {
+	case opSet:
+		s.data[rec.key] = entry{value: rec.value, expires: rec.expires}
+	case opDelete:
+		delete(s.data, rec.key)
+	}
+}
+
+func (s *Store) expired(e entry) bool {
+	return !e.expires.IsZero() && !s.opts.Clock.Now().Before(e.expires)
+}
+
+func (s *Store) removeExpired() {
+	for k, e := range s.data {
+		if s.expired(e) {
+			delete(s.data, k)
+		}
+	}
+}
+
+func (s *Store) background() {
+	defer s.wg.Done()
+	var syncC, compactC <-chan time.Time
+	if s.opts.Sync == SyncInterval {
+		t := s.opts.Clock.NewTicker(s.opts.SyncInterval)
+		defer t.Stop()
+		syncC = t.C()
+	}
+	if s.opts.CompactInterval > 0 {
+		t := s.opts.Clock.NewTicker(s.opts.CompactInterval)
+		defer t.Stop()
+		compactC = t.C()
+	}
+	for {
+		select {
+		case <-syncC:
+			s.Sync()
+		case <-compactC:
+			s.Compact()
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package kv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	walFile      = "wal.log"
+	snapshotFile = "snapshot.db"
+)
+
+// wal owns the files in the store directory: the append-only log of
+// changes since the last compaction and the snapshot it compacted into.
+type wal struct {
+	dir string
+	log *os.File
+}
+
+// openWAL loads the snapshot and replays the log through apply. A torn or
+// damaged record at the end of the log, left by a crash in the middle of a
+// write, is cut off together with everything after it. A damaged snapshot
+// is an error, since it was only ever renamed into place complete.
+func openWAL(dir string, apply func(record)) (*wal, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	w := &wal{dir: dir}
+	if err := w.loadSnapshot(apply); err != nil {
+		return nil, err
+	}
+	offset, err := w.replay(apply)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(w.path(walFile), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.log = f
+	return w, nil
+}
+
+func (w *wal) path(name string) string {
+	return filepath.Join(w.dir, name)
+}
+
+func (w *wal) loadSnapshot(apply func(record)) error {
+	f, err := os.Open(w.path(snapshotFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("kv: corrupt snapshot at offset %d", offset)
+		}
+		offset += int64(n)
+		apply(rec)
+	}
+}
+
+// replay applies the log and returns the offset of the end of the last
+// good record.
+func (w *wal) replay(apply func(record)) (int64, error) {
+	f, err := os.Open(w.path(walFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		rec, n, err := readRecord(r)
+		if err != nil {
+			// io.EOF or errCorrupt: either way the good part ends here.
+			return offset, nil
+		}
+		offset += int64(n)
+		apply(rec)
+	}
+}
+
+func (w *wal) append(rec record, sync bool) error {
+	if _, err := w.log.Write(rec.encode()); err != nil {
+		return err
+	}
+	if sync {
+		return w.log.Sync()
+	}
+	return nil
+}
+
+func (w *wal) sync() error {
+	return w.log.Sync()
+}
+
+// compact writes data to a temporary snapshot, renames it into place and
+// only then truncates the log. A crash between the two steps is harmless:
+// replaying the old log over the new snapshot sets the same keys again.
+func (w *wal) compact(data map[string]entry) error {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tmp := w.path(snapshotFile + ".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	for _, k := range keys {
+		e := data[k]
+		bw.Write(record{op: opSet, key: k, value: e.value, expires: e.expires}.encode())
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, w.path(snapshotFile)); err != nil {
+		return err
+	}
+
+	if err := w.log.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.log.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return w.log.Sync()
+}
+
+func (w *wal) close() error {
+	if err := w.log.Sync(); err != nil {
+		w.log.Close()
+		return err
+	}
+	return w.log.Close()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"packages/kv"
+	"packages/protocol"
+	"time"
+)
+
+// A key-value store served over the same protocol.Server as tcp_servers.go.
+// Start it with
+//
+//	go run kv_store.go -serve -dir kvdata
+//
+// and talk to it with
+//
+//	go run kv_store.go set greeting hello
+//	go run kv_store.go -ttl 1m set session abc
+//	go run kv_store.go get greeting
+//	go run kv_store.go scan gr
+//	go run kv_store.go delete greeting
+
+func server(addr, dir string, sync kv.SyncPolicy) {
+	store, err := kv.Open(dir, kv.Options{Sync: sync})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer store.Close()
+
+	// Room for the largest value the store takes.
+	srv := &protocol.Server{Handler: kv.Handler(store), Options: protocol.Options{MaxMessageSize: 33 << 20}}
+	fmt.Println("Serving", store.Len(), "keys from", dir)
+	err = srv.ListenAndServe(addr)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func client(addr string, ttl time.Duration, args []string) {
+	c, err := kv.Dial(addr, protocol.Options{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	arg := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
+	}
+	switch arg(0) {
+	case "get":
+		var v []byte
+		if v, err = c.Get(ctx, arg(1)); err == nil {
+			fmt.Println(string(v))
+		}
+	case "set":
+		if err = c.Set(ctx, arg(1), []byte(arg(2)), ttl); err == nil {
+			fmt.Println("OK")
+		}
+	case "delete":
+		if err = c.Delete(ctx, arg(1)); err == nil {
+			fmt.Println("OK")
+		}
+	case "scan":
+		var pairs []kv.Pair
+		after := ""
+		for {
+			pairs, err = c.Scan(ctx, arg(1), after, 100)
+			if err != nil || len(pairs) == 0 {
+				break
+			}
+			for _, p := range pairs {
+				fmt.Printf("%s = %s\n", p.Key, p.Value)
+			}
+			after = pairs[len(pairs)-1].Key
+		}
+	default:
+		fmt.Println("usage: go run kv_store.go [-ttl d] get <key> | set <key> <value> | delete <key> | scan <prefix>")
+	}
+	if err != nil {
+		fmt.Println("error:", err)
+	}
+}
+
+func main() {
+	serve := flag.Bool("serve", false, "Run the kv server")
+	addr := flag.String("addr", ":8080", "Server address")
+	dir := flag.String("dir", "kvdata", "Directory for the log and snapshot")
+	syncPolicy := flag.String("sync", "always", "When to fsync the log: always, interval or never")
+	ttl := flag.Duration("ttl", 0, "Expire keys written by set after this long")
+	flag.Parse()
+
+	if *serve {
+		policies := map[string]kv.SyncPolicy{
+			"always":   kv.SyncAlways,
+			"interval": kv.SyncInterval,
+			"never":    kv.SyncNever,
+		}
+		policy, ok := policies[*syncPolicy]
+		if !ok {
+			fmt.Println("unknown sync policy:", *syncPolicy)
+			return
+		}
+		server(*addr, *dir, policy)
+		return
+	}
+	client(*addr, *ttl, flag.Args())
+}
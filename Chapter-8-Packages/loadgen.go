@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"packages/loadgen"
+	"packages/protocol"
+	"time"
+)
+
+// Load-tests the server of tcp_servers.go or rpc_server.go. Start one of
+// them, then for example
+//
+//	go run loadgen.go -target tcp -conns 50 -duration 30s
+//	go run loadgen.go -target rpc -conns 10 -rate 5000 -json
+//
+// tcp_servers.go prints every message it receives, which is most of what
+// it spends its time on.
+
+func main() {
+	target := flag.String("target", "tcp", "Server to load: tcp (protocol.Server echo) or rpc (Server.Negate)")
+	addr := flag.String("addr", "127.0.0.1:8080", "Server address")
+	codec := flag.String("codec", "gob", "Codec for -target tcp: gob, binary, json or jsonl")
+	conns := flag.Int("conns", 10, "Number of connections")
+	rate := flag.Float64("rate", 0, "Requests per second across all connections; 0 is as fast as possible")
+	duration := flag.Duration("duration", 10*time.Second, "How long to send for")
+	asJSON := flag.Bool("json", false, "Print the report as JSON")
+	flag.Parse()
+
+	var dial loadgen.Dialer
+	switch *target {
+	case "tcp":
+		c := protocol.CodecByName(*codec)
+		if c == nil {
+			fmt.Println("unknown codec:", *codec)
+			return
+		}
+		dial = loadgen.Protocol(*addr, protocol.Options{Codecs: []protocol.Codec{c}}, "hello world")
+	case "rpc":
+		dial = loadgen.RPC(*addr, "Server.Negate", int64(99), func() interface{} { return new(int64) })
+	default:
+		fmt.Println("unknown target:", *target)
+		return
+	}
+
+	report, err := loadgen.Run(context.Background(), dial, loadgen.Options{
+		Conns:    *conns,
+		Rate:     *rate,
+		Duration: *duration,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+	report.WriteTable(os.Stdout)
+}
@@ -0,0 +1,228 @@
+// Package loadgen drives a server with many concurrent connections and
+// measures how fast it answers.
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Caller makes one request over its own connection. Run calls it from a
+// single goroutine.
+type Caller interface {
+	Call(ctx context.Context) error
+	Close() error
+}
+
+// Dialer opens a new connection to the server under test.
+type Dialer func() (Caller, error)
+
+// Options configures a run. Zero values pick the defaults.
+type Options struct {
+	// Conns is how many connections send at once. Defaults to 1.
+	Conns int
+	// Rate is the target number of requests per second across all
+	// connections. Zero sends as fast as the server answers. A connection
+	// that falls behind sends its overdue requests back to back, and
+	// their latency counts from when they were due.
+	Rate float64
+	// Duration is how long to send for. Defaults to ten seconds.
+	Duration time.Duration
+}
+
+// Latency summarises how long requests took to be answered.
+type Latency struct {
+	Mean time.Duration `json:"mean_ns"`
+	P50  time.Duration `json:"p50_ns"`
+	P90  time.Duration `json:"p90_ns"`
+	P99  time.Duration `json:"p99_ns"`
+	Max  time.Duration `json:"max_ns"`
+}
+
+// Report is the outcome of a run. Only successful requests count towards
+// Throughput and Latency.
+type Report struct {
+	Conns      int           `json:"conns"`
+	Rate       float64       `json:"target_rate,omitempty"`
+	Duration   time.Duration `json:"duration_ns"`
+	Requests   int           `json:"requests"`
+	Errors     int           `json:"errors"`
+	FirstError string        `json:"first_error,omitempty"`
+	Throughput float64       `json:"throughput"`
+	Latency    Latency       `json:"latency"`
+}
+
+// Run opens opts.Conns connections with dial and sends requests on all of
+// them until opts.Duration is up or ctx is done.
+func Run(ctx context.Context, dial Dialer, opts Options) (*Report, error) {
+	if opts.Conns <= 0 {
+		opts.Conns = 1
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = 10 * time.Second
+	}
+
+	callers := make([]Caller, 0, opts.Conns)
+	defer func() {
+		for _, c := range callers {
+			c.Close()
+		}
+	}()
+	for i := 0; i < opts.Conns; i++ {
+		c, err := dial()
+		if err != nil {
+			return nil, fmt.Errorf("loadgen: connection %d: %v", i+1, err)
+		}
+		callers = append(callers, c)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	// Each connection paces itself at its share of the rate.
+	var interval time.Duration
+	if opts.Rate > 0 {
+		interval = time.Duration(float64(time.Second) * float64(opts.Conns) / opts.Rate)
+	}
+
+	results := make([]workerResult, len(callers))
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, c := range callers {
+		wg.Add(1)
+		go func(i int, c Caller) {
+			defer wg.Done()
+			results[i] = work(ctx, c, interval)
+		}(i, c)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := &Report{Conns: opts.Conns, Rate: opts.Rate, Duration: elapsed}
+	var latencies []time.Duration
+	for _, r := range results {
+		latencies = append(latencies, r.latencies...)
+		report.Errors += r.errors
+		if report.FirstError == "" && r.firstErr != nil {
+			report.FirstError = r.firstErr.Error()
+		}
+	}
+	report.Requests = len(latencies) + report.Errors
+	report.Throughput = float64(len(latencies)) / elapsed.Seconds()
+	report.Latency = summarise(latencies)
+	return report, nil
+}
+
+type workerResult struct {
+	latencies []time.Duration
+	errors    int
+	firstErr  error
+}
+
+func work(ctx context.Context, c Caller, interval time.Duration) workerResult {
+	var r workerResult
+	next := time.Now()
+	for {
+		// In rate mode a request is due at next whether or not the
+		// previous one has come back. Timing from the due time rather
+		// than the send keeps a stalled server from hiding the requests
+		// that queued up behind it.
+		var due time.Time
+		if interval > 0 {
+			due = next
+			if wait := time.Until(next); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return r
+				}
+			}
+			next = next.Add(interval)
+		}
+		if ctx.Err() != nil {
+			return r
+		}
+
+		if due.IsZero() {
+			due = time.Now()
+		}
+		err := c.Call(ctx)
+		took := time.Since(due)
+		switch {
+		case err == nil:
+			r.latencies = append(r.latencies, took)
+		case ctx.Err() != nil:
+			// Cut off by the end of the run, not the server's fault.
+			return r
+		default:
+			r.errors++
+			if r.firstErr == nil {
+				r.firstErr = err
+			}
+		}
+	}
+}
+
+func summarise(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, d := range latencies {
+		total += d
+	}
+	return Latency{
+		Mean: total / time.Duration(len(latencies)),
+		P50:  percentile(latencies, 50),
+		P90:  percentile(latencies, 90),
+		P99:  percentile(latencies, 99),
+		Max:  latencies[len(latencies)-1],
+	}
+}
+
+// percentile returns the nearest-rank percentile p of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// WriteTable prints the report for people.
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	rate := "max"
+	if r.Rate > 0 {
+		rate = fmt.Sprintf("%.0f/s", r.Rate)
+	}
+	fmt.Fprintf(tw, "connections\t%d\n", r.Conns)
+	fmt.Fprintf(tw, "target rate\t%s\n", rate)
+	fmt.Fprintf(tw, "duration\t%v\n", r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(tw, "requests\t%d\n", r.Requests)
+	fmt.Fprintf(tw, "errors\t%d\n", r.Errors)
+	if r.FirstError != "" {
+		fmt.Fprintf(tw, "first error\t%s\n", r.FirstError)
+	}
+	fmt.Fprintf(tw, "throughput\t%.1f req/s\n", r.Throughput)
+	fmt.Fprintf(tw, "latency\tmean\tp50\tp90\tp99\tmax\n")
+	l := r.Latency
+	fmt.Fprintf(tw, "\t%v\t%v\t%v\t%v\t%v\n", round(l.Mean), round(l.P50), round(l.P90), round(l.P99), round(l.Max))
+	return tw.Flush()
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
@@ -0,0 +1,179 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"concurrency/leakcheck"
+	"packages/protocol"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i + 1)
+	}
+	type testPair struct {
+		values []time.Duration
+		p      float64
+		want   time.Duration
+	}
+	tests := []testPair{
+		{sorted, 50, 50},
+		{sorted, 90, 90},
+		{sorted, 99, 99},
+		{sorted, 100, 100},
+		{sorted[:1], 99, 1},
+		{sorted[:3], 50, 2},
+		{nil, 50, 0},
+	}
+	for _, test := range tests {
+		if got := percentile(test.values, test.p); got != test.want {
+			t.Errorf("p%v of %d values: Expected: %v, got: %v", test.p, len(test.values), test.want, got)
+		}
+	}
+}
+
+type fakeCaller struct {
+	calls *int64
+	fail  bool
+}
+
+func (c fakeCaller) Call(ctx context.Context) error {
+	n := atomic.AddInt64(c.calls, 1)
+	if c.fail && n%2 == 0 {
+		return errors.New("boom")
+	}
+	return nil
+}
+
+func (c fakeCaller) Close() error {
+	return nil
+}
+
+func TestRate(t *testing.T) {
+	var calls int64
+	dial := func() (Caller, error) { return fakeCaller{calls: &calls}, nil }
+	report, err := Run(context.Background(), dial, Options{Conns: 2, Rate: 200, Duration: 500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 200/s for half a second, give or take scheduling.
+	if report.Requests < 70 || report.Requests > 130 {
+		t.Errorf("Expected: about 100 requests, got: %d", report.Requests)
+	}
+}
+
+// stallCaller answers its first call after stall and the rest at once.
+type stallCaller struct {
+	calls *int64
+	stall time.Duration
+}
+
+func (c stallCaller) Call(ctx context.Context) error {
+	if atomic.AddInt64(c.calls, 1) == 1 {
+		time.Sleep(c.stall)
+	}
+	return nil
+}
+
+func (c stallCaller) Close() error {
+	return nil
+}
+
+// TestStallLatency checks that requests due while the server stalled are
+// timed from when they were due, not from when they were finally sent.
+func TestStallLatency(t *testing.T) {
+	var calls int64
+	dial := func() (Caller, error) { return stallCaller{calls: &calls, stall: 300 * time.Millisecond}, nil }
+	report, err := Run(context.Background(), dial, Options{Rate: 100, Duration: 600 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// About 30 of the 60 requests were due during the stall, so at least
+	// the slowest tenth waited 100ms or more.
+	if report.Latency.P90 < 100*time.Millisecond {
+		t.Errorf("Expected: p90 of 100ms or more, got: %v", report.Latency.P90)
+	}
+	if report.Requests < 40 {
+		t.Errorf("Expected: about 60 requests, got: %d", report.Requests)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var calls int64
+	dial := func() (Caller, error) { return fakeCaller{calls: &calls, fail: true}, nil }
+	report, err := Run(context.Background(), dial, Options{Rate: 100, Duration: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Errors == 0 || report.FirstError != "boom" {
+		t.Errorf("Expected: errors with boom, got: %d %q", report.Errors, report.FirstError)
+	}
+}
+
+func TestDialError(t *testing.T) {
+	dial := func() (Caller, error) { return nil, errors.New("refused") }
+	if _, err := Run(context.Background(), dial, Options{}); err == nil {
+		t.Error("Expected: error, got: nil")
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	defer leakcheck.Check(t)()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := protocol.HandlerFunc(func(c *protocol.Conn, m *protocol.Message) (interface{}, error) {
+		return m.Payload, nil
+	})
+	srv := &protocol.Server{Handler: echo}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	dial := Protocol(l.Addr().String(), protocol.Options{}, "hello")
+	report, err := Run(context.Background(), dial, Options{Conns: 4, Duration: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests == 0 || report.Errors != 0 {
+		t.Errorf("Expected: requests without errors, got: %d requests, %d errors (%s)", report.Requests, report.Errors, report.FirstError)
+	}
+	if report.Latency.Max < report.Latency.P50 {
+		t.Errorf("Expected: max >= p50, got: %v < %v", report.Latency.Max, report.Latency.P50)
+	}
+}
+
+type Negator struct{}
+
+func (Negator) Negate(i int64, reply *int64) error {
+	*reply = -i
+	return nil
+}
+
+func TestRPC(t *testing.T) {
+	defer leakcheck.Check(t)()
+	server := rpc.NewServer()
+	server.RegisterName("Server", Negator{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go server.Accept(l)
+
+	dial := RPC(l.Addr().String(), "Server.Negate", int64(99), func() interface{} { return new(int64) })
+	report, err := Run(context.Background(), dial, Options{Conns: 2, Duration: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests == 0 || report.Errors != 0 {
+		t.Errorf("Expected: requests without errors, got: %d requests, %d errors (%s)", report.Requests, report.Errors, report.FirstError)
+	}
+}
@@ -0,0 +1,81 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"reflect"
+
+	"packages/protocol"
+)
+
+// ErrBadReply is returned when the server answers with something other
+// than what was expected.
+var ErrBadReply = errors.New("loadgen: unexpected reply")
+
+// Protocol dials a protocol.Server, such as the one in tcp_servers.go, and
+// sends payload as an "echo" Request, expecting it back.
+func Protocol(addr string, opts protocol.Options, payload interface{}) Dialer {
+	return func() (Caller, error) {
+		conn, err := protocol.Dial(addr, nil, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &protocolCaller{conn: conn, payload: payload}, nil
+	}
+}
+
+type protocolCaller struct {
+	conn    *protocol.Conn
+	payload interface{}
+}
+
+func (c *protocolCaller) Call(ctx context.Context) error {
+	reply, err := c.conn.Call(ctx, "echo", c.payload)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(reply, c.payload) {
+		return ErrBadReply
+	}
+	return nil
+}
+
+func (c *protocolCaller) Close() error {
+	return c.conn.Close()
+}
+
+// RPC dials a net/rpc server, such as the one in rpc_server.go, and calls
+// method with args. newReply returns a fresh pointer for each reply.
+func RPC(addr, method string, args interface{}, newReply func() interface{}) Dialer {
+	return func() (Caller, error) {
+		client, err := rpc.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return &rpcCaller{client: client, method: method, args: args, newReply: newReply}, nil
+	}
+}
+
+type rpcCaller struct {
+	client   *rpc.Client
+	method   string
+	args     interface{}
+	newReply func() interface{}
+}
+
+// Call uses Go rather than Call so the run can end while a request is
+// still waiting for its reply.
+func (c *rpcCaller) Call(ctx context.Context) error {
+	call := c.client.Go(c.method, c.args, c.newReply(), make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *rpcCaller) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Compress compresses responses with gzip or deflate, whichever the
+// client's Accept-Encoding prefers, at the given flate level. Responses
+// that are already encoded, partial or of a type that does not shrink
+// (images, archives, media) go out as they are.
+func Compress(level int) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiate(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, level: level}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiate picks gzip or deflate by quality, gzip on a tie, or "" for
+// neither.
+func negotiate(accept string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[name] = weight
+	}
+	best, bestQ := "", 0.0
+	for _, enc := range []string{"gzip", "deflate"} {
+		weight, ok := q[enc]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ = enc, weight
+		}
+	}
+	return best
+}
+
+// compressible reports whether a content type is worth compressing.
+func compressible(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(t, "text/"), t == "image/svg+xml":
+		return true
+	case strings.HasPrefix(t, "image/"), strings.HasPrefix(t, "video/"), strings.HasPrefix(t, "audio/"):
+		return false
+	}
+	switch t {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/pdf", "application/octet-stream", "application/wasm", "font/woff", "font/woff2":
+		return false
+	}
+	return true
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	level    int
+	decided  bool
+	w        io.WriteCloser // nil when not compressing
+}
+
+// decide settles whether to compress, just before the header goes out.
+func (cw *compressWriter) decide(status int) {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+	h := cw.Header()
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified || status == http.StatusPartialContent ||
+		h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" || !compressible(h.Get("Content-Type")) {
+		return
+	}
+	var err error
+	switch cw.encoding {
+	case "gzip":
+		cw.w, err = gzip.NewWriterLevel(cw.ResponseWriter, cw.level)
+	case "deflate":
+		cw.w, err = flate.NewWriter(cw.ResponseWriter, cw.level)
+	}
+	if err != nil {
+		cw.w = nil
+		return
+	}
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length")
+	// A strong validator would now be wrong about the bytes sent.
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	cw.decide(code)
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		// Sniff now, as net/http would, or it would sniff compressed bytes.
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.w == nil {
+		return cw.ResponseWriter.Write(p)
+	}
+	return cw.w.Write(p)
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := cw.w.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close finishes the compressed stream.
+func (cw *compressWriter) Close() error {
+	if cw.w == nil {
+		return nil
+	}
+	return cw.w.Close()
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures CORS. Zero values pick the defaults.
+type CORSOptions struct {
+	// Origins may call from a browser, as "https://example.com", or "*"
+	// for any. Defaults to any.
+	Origins []string
+	// Methods are allowed in preflights. Defaults to GET, HEAD and POST.
+	Methods []string
+	// Headers are the request headers allowed in preflights. Defaults to
+	// Content-Type.
+	Headers []string
+	// Expose lists the response headers scripts may read.
+	Expose []string
+	// Credentials lets cookies and auth headers through; the origin is
+	// then always named rather than "*".
+	Credentials bool
+	// MaxAge is how long browsers may cache a preflight.
+	MaxAge time.Duration
+}
+
+func (o *CORSOptions) setDefaults() {
+	if len(o.Origins) == 0 {
+		o.Origins = []string{"*"}
+	}
+	if len(o.Methods) == 0 {
+		o.Methods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	if len(o.Headers) == 0 {
+		o.Headers = []string{"Content-Type"}
+	}
+}
+
+func (o *CORSOptions) allowed(origin string) bool {
+	for _, o := range o.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORS lets browsers call from the allowed origins, answering preflight
+// requests itself. Requests from other origins are served without CORS
+// headers, so browsers keep their responses from scripts.
+func CORS(opts CORSOptions) Middleware {
+	opts.setDefaults()
+	methods := strings.Join(opts.Methods, ", ")
+	headers := strings.Join(opts.Headers, ", ")
+	expose := strings.Join(opts.Expose, ", ")
+	anyOrigin := !opts.Credentials && len(opts.Origins) == 1 && opts.Origins[0] == "*"
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			h := w.Header()
+			if !anyOrigin {
+				h.Add("Vary", "Origin")
+			}
+			if origin == "" || !opts.allowed(origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if anyOrigin {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if opts.Credentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", methods)
+				h.Set("Access-Control-Allow-Headers", headers)
+				if opts.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge.Seconds())))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if expose != "" {
+				h.Set("Access-Control-Expose-Headers", expose)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"concurrency/leakcheck"
+)
+
+func text(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	})
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	serve(Chain(text(""), mark("a"), mark("b"), mark("c")), httptest.NewRequest("GET", "/", nil))
+	if got := strings.Join(order, ""); got != "abc" {
+		t.Errorf("Expected: abc, got: %s", got)
+	}
+}
+
+func TestRequestIDAndLogging(t *testing.T) {
+	var logs bytes.Buffer
+	var seen string
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFrom(r.Context())
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, "tea")
+	}), RequestID(), Logging(log.New(&logs, "", 0)))
+
+	type testPair struct {
+		sent string
+		kept bool
+	}
+	tests := []testPair{
+		{"", false},
+		{"abc-123.x_y", true},
+		{"bad id\n", false},
+		{strings.Repeat("a", 65), false},
+	}
+	for _, test := range tests {
+		logs.Reset()
+		r := httptest.NewRequest("GET", "/pot?x=1", nil)
+		if test.sent != "" {
+			r.Header.Set(RequestIDHeader, test.sent)
+		}
+		w := serve(h, r)
+		id := w.Header().Get(RequestIDHeader)
+		if id != seen || id == "" || (id == test.sent) != test.kept {
+			t.Errorf("%q: Expected: kept %v, got: header %q, context %q", test.sent, test.kept, id, seen)
+		}
+		want := regexp.MustCompile(`^192\.0\.2\.1:1234 ` + regexp.QuoteMeta(id) + ` GET /pot\?x=1 418 3B \S+\n$`)
+		if !want.MatchString(logs.String()) {
+			t.Errorf("Expected: log matching %s, got: %q", want, logs.String())
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var logs bytes.Buffer
+	l := log.New(&logs, "", 0)
+	boom := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}), Recover(l))
+	w := serve(boom, httptest.NewRequest("GET", "/x", nil))
+	if w.Code != 500 || !strings.Contains(logs.String(), "panic serving GET /x: boom") || !strings.Contains(logs.String(), "goroutine") {
+		t.Errorf("Expected: 500 and a logged stack, got: %d %q", w.Code, logs.String())
+	}
+
+	// Too late for a 500: the connection is cut.
+	late := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "half")
+		panic("boom")
+	}), Recover(l))
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("Expected: %v, got: %v", http.ErrAbortHandler, p)
+		}
+	}()
+	serve(late, httptest.NewRequest("GET", "/x", nil))
+}
+
+func TestNegotiate(t *testing.T) {
+	type testPair struct {
+		accept string
+		want   string
+	}
+	tests := []testPair{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"*", "gzip"},
+		{"br, *;q=0.1, gzip;q=0", "deflate"},
+		{"identity", ""},
+	}
+	for _, test := range tests {
+		if got := negotiate(test.accept); got != test.want {
+			t.Errorf("%q: Expected: %q, got: %q", test.accept, test.want, got)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat("compress me ", 100)
+	h := Compress(gzip.DefaultCompression)
+
+	type testPair struct {
+		name     string
+		accept   string
+		handler  http.Handler
+		encoding string
+	}
+	typed := func(contentType string, status int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Length", "1200")
+			w.Header().Set("ETag", `"abc"`)
+			w.WriteHeader(status)
+			io.WriteString(w, body)
+		})
+	}
+	tests := []testPair{
+		{"sniffed", "gzip", text(body), "gzip"},
+		{"deflate", "deflate", text(body), "deflate"},
+		{"not accepted", "", text(body), ""},
+		{"json", "gzip", typed("application/json", 200), "gzip"},
+		{"image", "gzip", typed("image/png", 200), ""},
+		{"partial", "gzip", typed("text/plain", http.StatusPartialContent), ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", test.accept)
+		w := serve(h(test.handler), r)
+		if got := w.Header().Get("Content-Encoding"); got != test.encoding {
+			t.Errorf("%s: Expected: %q, got: %q", test.name, test.encoding, got)
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s: Expected: Vary: Accept-Encoding, got: %q", test.name, w.Header().Get("Vary"))
+		}
+		var rd io.Reader = w.Body
+		switch test.encoding {
+		case "gzip":
+			rd, _ = gzip.NewReader(w.Body)
+		case "deflate":
+			rd = flate.NewReader(w.Body)
+		}
+		if got, _ := io.ReadAll(rd); string(got) != body {
+			t.Errorf("%s: Expected: the body back, got: %d bytes", test.name, len(got))
+		}
+		if test.encoding != "" {
+			if w.Header().Get("Content-Length") != "" || strings.HasPrefix(w.Header().Get("Content-Type"), "application/x-gzip") {
+				t.Errorf("%s: Expected: no length, the original type, got: %v", test.name, w.Header())
+			}
+			if etag := w.Header().Get("ETag"); etag != "" && etag != `W/"abc"` {
+				t.Errorf("%s: Expected: a weak ETag, got: %s", test.name, etag)
+			}
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	h := CORS(CORSOptions{Origins: []string{"https://ok.example"}, Expose: []string{RequestIDHeader}, Credentials: true, MaxAge: time.Hour})(text("data"))
+	anyOrigin := CORS(CORSOptions{})(text("data"))
+
+	type testPair struct {
+		name      string
+		h         http.Handler
+		method    string
+		origin    string
+		preflight bool
+		code      int
+		want      map[string]string
+	}
+	tests := []testPair{
+		{"same origin", h, "GET", "", false, 200, map[string]string{"Access-Control-Allow-Origin": ""}},
+		{"allowed", h, "GET", "https://ok.example", false, 200, map[string]string{
+			"Access-Control-Allow-Origin":      "https://ok.example",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Expose-Headers":    RequestIDHeader,
+			"Vary":                             "Origin",
+		}},
+		{"other origin", h, "GET", "https://evil.example", false, 200, map[string]string{"Access-Control-Allow-Origin": ""}},
+		{"preflight", h, "OPTIONS", "https://ok.example", true, 204, map[string]string{
+			"Access-Control-Allow-Methods": "GET, HEAD, POST",
+			"Access-Control-Allow-Headers": "Content-Type",
+			"Access-Control-Max-Age":       "3600",
+		}},
+		{"plain options", h, "OPTIONS", "https://ok.example", false, 200, map[string]string{"Access-Control-Allow-Methods": ""}},
+		{"any origin", anyOrigin, "GET", "https://evil.example", false, 200, map[string]string{"Access-Control-Allow-Origin": "*", "Vary": ""}},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.preflight {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w := serve(test.h, r)
+		if w.Code != test.code {
+			t.Errorf("%s: Expected: %d, got: %d", test.name, test.code, w.Code)
+		}
+		for k, v := range test.want {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf("%s: %s: Expected: %q, got: %q", test.name, k, v, got)
+			}
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	defer leakcheck.Check(t)()
+	stopped := make(chan error, 1)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		stopped <- r.Context().Err()
+	})
+	w := serve(Timeout(10*time.Millisecond)(slow), httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "request timed out\n" {
+		t.Errorf("Expected: 503 request timed out, got: %d %q", w.Code, w.Body)
+	}
+	if err := <-stopped; err == nil {
+		t.Errorf("Expected: the handler's context done, got: %v", err)
+	}
+	w = serve(Timeout(time.Second)(text("quick")), httptest.NewRequest("GET", "/", nil))
+	if w.Code != 200 || w.Body.String() != "quick" {
+		t.Errorf("Expected: 200 quick, got: %d %q", w.Code, w.Body)
+	}
+}
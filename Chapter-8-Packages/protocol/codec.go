@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Codec turns Messages into bytes on a stream and back. The same handlers
+// work with any codec; which one a connection uses is agreed on by the
+// handshake when it opens.
+type Codec interface {
+	Name() string
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+// Encoder writes one Message at a time.
+type Encoder interface {
+	Encode(m *Message) error
+}
+
+// Decoder reads one Message at a time.
+type Decoder interface {
+	Decode(m *Message) error
+}
+
+// Codecs lists the built-in codecs, in the order a client offers them by
+// default.
+var Codecs = []Codec{Gob, Binary, JSON, JSONLines}
+
+// CodecByName returns the built-in codec called name, or nil.
+func CodecByName(name string) Codec {
+	for _, c := range Codecs {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// ErrNoCommonCodec is returned by the handshake when the two ends have no
+// codec in common.
+var ErrNoCommonCodec = errors.New("protocol: no common codec")
+
+const (
+	handshakeTimeout = 10 * time.Second
+	maxHandshakeLine = 256
+)
+
+// The handshake is two text lines, so it can be typed into telnet:
+//
+//	client: HELLO gob binary json jsonl
+//	server: USE json
+//
+// The server picks the first codec in the client's list that it supports,
+// or answers "ERR <reason>" and hangs up.
+
+// clientHandshake offers codecs in order of preference and returns the one
+// the server picked.
+func clientHandshake(nc net.Conn, offer []Codec) (Codec, error) {
+	nc.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer nc.SetDeadline(time.Time{})
+
+	names := make([]string, len(offer))
+	for i, c := range offer {
+		names[i] = c.Name()
+	}
+	if _, err := io.WriteString(nc, "HELLO "+strings.Join(names, " ")+"\n"); err != nil {
+		return nil, err
+	}
+	line, err := readLine(nc)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(line, "ERR ") {
+		reason := strings.TrimPrefix(line, "ERR ")
+		for _, known := range []error{ErrNoCommonCodec, ErrServerBusy} {
+			if reason == known.Error() {
+				return nil, known
+			}
+		}
+		return nil, RemoteError(reason)
+	}
+	if strings.HasPrefix(line, "USE ") {
+		for _, c := range offer {
+			if c.Name() == strings.TrimPrefix(line, "USE ") {
+				return c, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("protocol: bad handshake reply %q", line)
+}
+
+// serverHandshake reads the client's offer and picks the first codec that
+// is also in supported.
+func serverHandshake(nc net.Conn, supported []Codec) (Codec, error) {
+	nc.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer nc.SetDeadline(time.Time{})
+
+	line, err := readLine(nc)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "HELLO ") {
+		io.WriteString(nc, "ERR expected HELLO\n")
+		return nil, fmt.Errorf("protocol: bad handshake %q", line)
+	}
+	for _, name := range strings.Fields(strings.TrimPrefix(line, "HELLO ")) {
+		for _, c := range supported {
+			if c.Name() == name {
+				_, err := io.WriteString(nc, "USE "+name+"\n")
+				return c, err
+			}
+		}
+	}
+	io.WriteString(nc, "ERR "+ErrNoCommonCodec.Error()+"\n")
+	return nil, ErrNoCommonCodec
+}
+
+// readLine reads up to a newline one byte at a time, so nothing after the
+// handshake is consumed before the codec takes over the stream.
+func readLine(r io.Reader) (string, error) {
+	var buf bytes.Buffer
+	b := make([]byte, 1)
+	for buf.Len() < maxHandshakeLine {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSuffix(buf.String(), "\r"), nil
+		}
+		buf.WriteByte(b[0])
+	}
+	return "", errors.New("protocol: handshake line too long")
+}
@@ -0,0 +1,377 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+// Binary is a compact codec. Each message is a uvarint length followed by
+//
+//	kind byte | id uvarint | type string | error string | payload
+//
+// Strings are a uvarint length and the bytes. The payload is the registered
+// type name followed by the value: integers as varints, floats as 8 bytes,
+// slices and maps with a length prefix, structs field by field. Types whose
+// pointer implements both encoding.BinaryMarshaler and BinaryUnmarshaler,
+// like time.Time and url.URL, encode themselves.
+var Binary Codec = binaryCodec{}
+
+var errBinaryShort = errors.New("protocol: binary frame too short")
+
+// maxDepth bounds how deeply values may nest in a frame. Decoding recurses
+// once per level, and a frame of nothing but nesting would otherwise run
+// the goroutine out of stack, which cannot be recovered from.
+const maxDepth = 100
+
+var (
+	binaryMarshaler   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshaler = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// selfCoding reports whether values of t encode themselves. The pointer's
+// method set is checked on both ends, so a pointer-receiver MarshalBinary
+// is found whether or not the value being encoded is addressable.
+func selfCoding(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return false
+	}
+	pt := reflect.PointerTo(t)
+	return pt.Implements(binaryMarshaler) && pt.Implements(binaryUnmarshaler)
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string {
+	return "binary"
+}
+
+func (binaryCodec) NewEncoder(w io.Writer) Encoder {
+	return &binaryEncoder{w: w}
+}
+
+func (binaryCodec) NewDecoder(r io.Reader) Decoder {
+	return &binaryDecoder{r: bufio.NewReader(r)}
+}
+
+type binaryEncoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (e *binaryEncoder) Encode(m *Message) error {
+	body := []byte{byte(m.Kind)}
+	body = binary.AppendUvarint(body, m.ID)
+	body = appendString(body, m.Type)
+	body = appendString(body, m.Error)
+	body, err := appendInterface(body, reflect.ValueOf(&m.Payload).Elem())
+	if err != nil {
+		return err
+	}
+	e.buf = binary.AppendUvarint(e.buf[:0], uint64(len(body)))
+	e.buf = append(e.buf, body...)
+	_, err = e.w.Write(e.buf)
+	return err
+}
+
+type binaryDecoder struct {
+	r *bufio.Reader
+}
+
+func (d *binaryDecoder) Decode(m *Message) error {
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return err
+	}
+	if n > maxFrame {
+		return fmt.Errorf("protocol: frame of %d bytes is too large", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(d.r, body); err != nil {
+		return err
+	}
+
+	b := &binaryReader{buf: body}
+	kind := Kind(b.byte())
+	*m = Message{Kind: kind, ID: b.uvarint(), Type: b.string(), Error: b.string()}
+	if b.err != nil {
+		return b.err
+	}
+	if kind < Request || kind > Pong {
+		return fmt.Errorf("protocol: unknown message kind %d", kind)
+	}
+	payload := reflect.ValueOf(&m.Payload).Elem()
+	b.readInterface(payload)
+	return b.err
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// appendInterface writes the dynamic type's registered name, then the value.
+// A nil interface is an empty name.
+func appendInterface(buf []byte, v reflect.Value) ([]byte, error) {
+	if v.IsNil() {
+		return appendString(buf, ""), nil
+	}
+	elem := v.Elem()
+	name, ok := payloadName(elem.Interface())
+	if !ok {
+		return nil, fmt.Errorf("protocol: payload type %s is not registered", elem.Type())
+	}
+	return appendValue(appendString(buf, name), elem)
+}
+
+func appendValue(buf []byte, v reflect.Value) ([]byte, error) {
+	if selfCoding(v.Type()) {
+		if !v.CanAddr() {
+			c := reflect.New(v.Type()).Elem()
+			c.Set(v)
+			v = c
+		}
+		bs, err := v.Addr().Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return appendString(buf, string(bs)), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return binary.AppendUvarint(buf, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return appendString(buf, v.String()), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendString(buf, string(v.Bytes())), nil
+		}
+		fallthrough
+	case reflect.Array:
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			var err error
+			if buf, err = appendValue(buf, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Map:
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		iter := v.MapRange()
+		for iter.Next() {
+			var err error
+			if buf, err = appendValue(buf, iter.Key()); err != nil {
+				return nil, err
+			}
+			if buf, err = appendValue(buf, iter.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			var err error
+			if buf, err = appendValue(buf, v.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		return appendValue(append(buf, 1), v.Elem())
+	case reflect.Interface:
+		return appendInterface(buf, v)
+	}
+	return nil, fmt.Errorf("protocol: binary codec cannot encode %s", v.Type())
+}
+
+// binaryReader decodes from a frame body, remembering the first error so
+// callers can check once at the end.
+type binaryReader struct {
+	buf   []byte
+	err   error
+	depth int
+}
+
+func (b *binaryReader) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+	b.buf = nil
+}
+
+func (b *binaryReader) byte() byte {
+	if len(b.buf) < 1 {
+		b.fail(errBinaryShort)
+		return 0
+	}
+	c := b.buf[0]
+	b.buf = b.buf[1:]
+	return c
+}
+
+func (b *binaryReader) uvarint() uint64 {
+	x, n := binary.Uvarint(b.buf)
+	if n <= 0 {
+		b.fail(errBinaryShort)
+		return 0
+	}
+	b.buf = b.buf[n:]
+	return x
+}
+
+func (b *binaryReader) varint() int64 {
+	x, n := binary.Varint(b.buf)
+	if n <= 0 {
+		b.fail(errBinaryShort)
+		return 0
+	}
+	b.buf = b.buf[n:]
+	return x
+}
+
+func (b *binaryReader) bytes() []byte {
+	n := b.uvarint()
+	if n > uint64(len(b.buf)) {
+		b.fail(errBinaryShort)
+		return nil
+	}
+	bs := b.buf[:n]
+	b.buf = b.buf[n:]
+	return bs
+}
+
+func (b *binaryReader) string() string {
+	return string(b.bytes())
+}
+
+// length reads a collection length, rejecting lengths that could not fit
+// in what is left of the frame.
+func (b *binaryReader) length() int {
+	n := b.uvarint()
+	if n > uint64(len(b.buf)) {
+		b.fail(errBinaryShort)
+		return 0
+	}
+	return int(n)
+}
+
+func (b *binaryReader) readInterface(v reflect.Value) {
+	name := b.string()
+	if b.err != nil || name == "" {
+		return
+	}
+	t, ok := payloadType(name)
+	if !ok {
+		b.fail(fmt.Errorf("protocol: unknown payload type %q", name))
+		return
+	}
+	elem := reflect.New(t).Elem()
+	b.readValue(elem)
+	if b.err == nil {
+		v.Set(elem)
+	}
+}
+
+func (b *binaryReader) readValue(v reflect.Value) {
+	if b.err != nil {
+		return
+	}
+	b.depth++
+	defer func() { b.depth-- }()
+	if b.depth > maxDepth {
+		b.fail(fmt.Errorf("protocol: values nested more than %d deep", maxDepth))
+		return
+	}
+	if selfCoding(v.Type()) {
+		m := v.Addr().Interface().(encoding.BinaryUnmarshaler)
+		if err := m.UnmarshalBinary(b.bytes()); err != nil {
+			b.fail(err)
+		}
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(b.byte() != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(b.varint())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v.SetUint(b.uvarint())
+	case reflect.Float32, reflect.Float64:
+		if len(b.buf) < 8 {
+			b.fail(errBinaryShort)
+			return
+		}
+		v.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(b.buf)))
+		b.buf = b.buf[8:]
+	case reflect.String:
+		v.SetString(b.string())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte(nil), b.bytes()...))
+			return
+		}
+		n := b.length()
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		for i := 0; i < n; i++ {
+			b.readValue(v.Index(i))
+		}
+	case reflect.Array:
+		n := b.length()
+		if n != v.Len() {
+			b.fail(fmt.Errorf("protocol: array length %d, want %d", n, v.Len()))
+			return
+		}
+		for i := 0; i < n; i++ {
+			b.readValue(v.Index(i))
+		}
+	case reflect.Map:
+		n := b.length()
+		v.Set(reflect.MakeMapWithSize(v.Type(), n))
+		for i := 0; i < n && b.err == nil; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			val := reflect.New(v.Type().Elem()).Elem()
+			b.readValue(key)
+			b.readValue(val)
+			v.SetMapIndex(key, val)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				b.readValue(v.Field(i))
+			}
+		}
+	case reflect.Ptr:
+		if b.byte() == 0 {
+			return
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+		b.readValue(v.Elem())
+	case reflect.Interface:
+		b.readInterface(v)
+	default:
+		b.fail(fmt.Errorf("protocol: binary codec cannot decode %s", v.Type()))
+	}
+}
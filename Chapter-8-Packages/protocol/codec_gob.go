@@ -0,0 +1,40 @@
+package protocol
+
+import (
+	"encoding/gob"
+	"io"
+)
+
+// Gob is the encoding/gob codec. Payload types must be registered with
+// Register so gob can send them inside the interface field.
+var Gob Codec = gobCodec{}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) NewEncoder(w io.Writer) Encoder {
+	return gobEncoder{gob.NewEncoder(w)}
+}
+
+func (gobCodec) NewDecoder(r io.Reader) Decoder {
+	return gobDecoder{gob.NewDecoder(r)}
+}
+
+type gobEncoder struct {
+	enc *gob.Encoder
+}
+
+func (e gobEncoder) Encode(m *Message) error {
+	return e.enc.Encode(m)
+}
+
+type gobDecoder struct {
+	dec *gob.Decoder
+}
+
+func (d gobDecoder) Decode(m *Message) error {
+	return d.dec.Decode(m)
+}
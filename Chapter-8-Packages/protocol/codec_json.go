@@ -0,0 +1,185 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+var (
+	// JSON frames every message as a 4-byte big-endian length followed by
+	// a JSON object.
+	JSON Codec = jsonCodec{}
+	// JSONLines writes one JSON object per line.
+	JSONLines Codec = jsonLinesCodec{}
+)
+
+// maxFrame guards against a corrupt or hostile length prefix.
+const maxFrame = 64 << 20
+
+// jsonMessage is how a Message looks in JSON:
+//
+//	{"kind":"request","type":"echo","id":1,"payload_type":"string","payload":"hi"}
+//
+// payload_type names a type passed to Register. Without it the payload is
+// decoded as plain JSON (map[string]interface{}, float64, ...), and so are
+// interface fields nested inside a payload.
+type jsonMessage struct {
+	Kind        string          `json:"kind"`
+	Type        string          `json:"type,omitempty"`
+	ID          uint64          `json:"id,omitempty"`
+	PayloadType string          `json:"payload_type,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+func marshalJSON(m *Message) ([]byte, error) {
+	jm := jsonMessage{Kind: m.Kind.String(), Type: m.Type, ID: m.ID, Error: m.Error}
+	if m.Payload != nil {
+		jm.PayloadType, _ = payloadName(m.Payload)
+		bs, err := json.Marshal(m.Payload)
+		if err != nil {
+			return nil, err
+		}
+		jm.Payload = bs
+	}
+	return json.Marshal(jm)
+}
+
+func unmarshalJSON(bs []byte, m *Message) error {
+	var jm jsonMessage
+	if err := json.Unmarshal(bs, &jm); err != nil {
+		return err
+	}
+	kind, err := parseKind(jm.Kind)
+	if err != nil {
+		return err
+	}
+	*m = Message{Kind: kind, Type: jm.Type, ID: jm.ID, Error: jm.Error}
+	if len(jm.Payload) == 0 || string(jm.Payload) == "null" {
+		return nil
+	}
+	if jm.PayloadType == "" {
+		return json.Unmarshal(jm.Payload, &m.Payload)
+	}
+	t, ok := payloadType(jm.PayloadType)
+	if !ok {
+		return fmt.Errorf("protocol: unknown payload type %q", jm.PayloadType)
+	}
+	v := reflect.New(t)
+	if err := json.Unmarshal(jm.Payload, v.Interface()); err != nil {
+		return err
+	}
+	m.Payload = v.Elem().Interface()
+	return nil
+}
+
+func parseKind(s string) (Kind, error) {
+	for k := Request; k <= Pong; k++ {
+		if k.String() == s {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("protocol: unknown message kind %q", s)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) NewEncoder(w io.Writer) Encoder {
+	return &jsonEncoder{w: w}
+}
+
+func (jsonCodec) NewDecoder(r io.Reader) Decoder {
+	return &jsonDecoder{r: r}
+}
+
+type jsonEncoder struct {
+	w io.Writer
+}
+
+func (e *jsonEncoder) Encode(m *Message) error {
+	bs, err := marshalJSON(m)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 4, 4+len(bs))
+	binary.BigEndian.PutUint32(frame, uint32(len(bs)))
+	_, err = e.w.Write(append(frame, bs...))
+	return err
+}
+
+type jsonDecoder struct {
+	r io.Reader
+}
+
+func (d *jsonDecoder) Decode(m *Message) error {
+	var size [4]byte
+	if _, err := io.ReadFull(d.r, size[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxFrame {
+		return fmt.Errorf("protocol: frame of %d bytes is too large", n)
+	}
+	bs := make([]byte, n)
+	if _, err := io.ReadFull(d.r, bs); err != nil {
+		return err
+	}
+	return unmarshalJSON(bs, m)
+}
+
+type jsonLinesCodec struct{}
+
+func (jsonLinesCodec) Name() string {
+	return "jsonl"
+}
+
+func (jsonLinesCodec) NewEncoder(w io.Writer) Encoder {
+	return &jsonLinesEncoder{w: w}
+}
+
+func (jsonLinesCodec) NewDecoder(r io.Reader) Decoder {
+	return &jsonLinesDecoder{r: bufio.NewReader(r)}
+}
+
+type jsonLinesEncoder struct {
+	w io.Writer
+}
+
+func (e *jsonLinesEncoder) Encode(m *Message) error {
+	bs, err := marshalJSON(m)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(bs, '\n'))
+	return err
+}
+
+type jsonLinesDecoder struct {
+	r *bufio.Reader
+}
+
+func (d *jsonLinesDecoder) Decode(m *Message) error {
+	for {
+		line, err := d.r.ReadBytes('\n')
+		// A half-read line is the read error's fault, not bad JSON; only
+		// a last line without a newline at EOF is decoded as is.
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			return unmarshalJSON(line, m)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
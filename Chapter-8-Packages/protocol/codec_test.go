@@ -0,0 +1,231 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"concurrency/leakcheck"
+)
+
+type order struct {
+	ID     uint64
+	Items  []string
+	Counts map[string]int
+	Note   *string
+	Paid   bool
+	Total  float64
+	Extra  interface{}
+	secret int
+}
+
+// link has url.URL, whose MarshalBinary has a pointer receiver, inside
+// values that are not addressable when encoded.
+type link struct {
+	Home    url.URL
+	Mirrors map[string]url.URL
+}
+
+func init() {
+	Register(order{})
+	Register(url.URL{})
+	Register(link{})
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	note := "leave at the door"
+	payloads := []interface{}{
+		nil,
+		"hello world",
+		42,
+		[]string{"a", "b"},
+		pair{-3, 7},
+		order{
+			ID:     9,
+			Items:  []string{"tea", "milk"},
+			Counts: map[string]int{"tea": 2},
+			Note:   &note,
+			Paid:   true,
+			Total:  4.5,
+			Extra:  pair{1, 2},
+		},
+	}
+
+	for _, codec := range Codecs {
+		var buf bytes.Buffer
+		enc := codec.NewEncoder(&buf)
+		for i, p := range payloads {
+			m := Message{Kind: Request, Type: "test", ID: uint64(i + 1), Payload: p, Error: "oops"}
+			if err := enc.Encode(&m); err != nil {
+				t.Fatalf("%s: encode %T: %v", codec.Name(), p, err)
+			}
+		}
+
+		dec := codec.NewDecoder(&buf)
+		for i, p := range payloads {
+			var m Message
+			if err := dec.Decode(&m); err != nil {
+				t.Fatalf("%s: decode %T: %v", codec.Name(), p, err)
+			}
+			want := Message{Kind: Request, Type: "test", ID: uint64(i + 1), Payload: p, Error: "oops"}
+			if o, ok := p.(order); ok && (codec == JSON || codec == JSONLines) {
+				// JSON has no type names below the top level.
+				o.Extra = map[string]interface{}{"X": 1.0, "Y": 2.0}
+				want.Payload = o
+			}
+			if !reflect.DeepEqual(m, want) {
+				t.Errorf("%s: Expected: %+v, got: %+v", codec.Name(), want, m)
+			}
+		}
+	}
+}
+
+func TestBinarySelfCoding(t *testing.T) {
+	home, _ := url.Parse("https://user@example.com/a?b=c#d")
+	mirror, _ := url.Parse("http://mirror.example.org/")
+	payloads := []interface{}{
+		*home,
+		link{Home: *home, Mirrors: map[string]url.URL{"eu": *mirror}},
+	}
+	for _, p := range payloads {
+		var buf bytes.Buffer
+		if err := Binary.NewEncoder(&buf).Encode(&Message{Kind: Request, Payload: p}); err != nil {
+			t.Fatalf("encode %T: %v", p, err)
+		}
+		var m Message
+		if err := Binary.NewDecoder(&buf).Decode(&m); err != nil {
+			t.Fatalf("decode %T: %v", p, err)
+		}
+		if !reflect.DeepEqual(m.Payload, p) {
+			t.Errorf("Expected: %+v, got: %+v", p, m.Payload)
+		}
+	}
+}
+
+func TestBinaryUnknownKind(t *testing.T) {
+	var buf bytes.Buffer
+	enc := Binary.NewEncoder(&buf)
+	enc.Encode(&Message{Kind: 99, ID: 1})
+	enc.Encode(&Message{Kind: Ping, ID: 2})
+
+	dec := Binary.NewDecoder(&buf)
+	var m Message
+	if err := dec.Decode(&m); err == nil || !strings.Contains(err.Error(), "unknown message kind 99") {
+		t.Errorf("Expected: unknown message kind 99, got: %v", err)
+	}
+	// The bad frame is skipped whole.
+	if err := dec.Decode(&m); err != nil || m.Kind != Ping || m.ID != 2 {
+		t.Errorf("Expected: ping 2, got: %+v, %v", m, err)
+	}
+}
+
+func TestBinaryDepth(t *testing.T) {
+	nest := func(depth int) interface{} {
+		var v interface{}
+		for i := 0; i < depth; i++ {
+			v = []interface{}{v}
+		}
+		return v
+	}
+	type testPair struct {
+		depth int
+		ok    bool
+	}
+	tests := []testPair{{40, true}, {100000, false}}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := Binary.NewEncoder(&buf).Encode(&Message{Kind: Request, Payload: nest(test.depth)}); err != nil {
+			t.Fatal(err)
+		}
+		var m Message
+		err := Binary.NewDecoder(&buf).Decode(&m)
+		if test.ok && err != nil || !test.ok && (err == nil || !strings.Contains(err.Error(), "nested more than")) {
+			t.Errorf("%d deep: Expected: ok %v, got: %v", test.depth, test.ok, err)
+		}
+	}
+}
+
+func TestCallWithEveryCodec(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s, addr := startServer(t, Options{})
+	defer s.Close()
+
+	for _, codec := range Codecs {
+		c, err := Dial(addr, nil, Options{Codecs: []Codec{codec}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Codec() != codec {
+			t.Errorf("Expected: %s, got: %s", codec.Name(), c.Codec().Name())
+		}
+		v, err := c.Call(context.Background(), "add", pair{20, 22})
+		if err != nil || v != 42 {
+			t.Errorf("%s: Expected: %d, got: %v, %v", codec.Name(), 42, v, err)
+		}
+		c.Close()
+	}
+}
+
+func TestNegotiation(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s, addr := startServer(t, Options{Codecs: []Codec{JSONLines, JSON}})
+	defer s.Close()
+
+	c, err := Dial(addr, nil, Options{Codecs: []Codec{Gob, JSON, JSONLines}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Codec() != JSON {
+		t.Errorf("Expected: %s, got: %s", JSON.Name(), c.Codec().Name())
+	}
+
+	if _, err := Dial(addr, nil, Options{Codecs: []Codec{Gob, Binary}}); err != ErrNoCommonCodec {
+		t.Errorf("Expected: %v, got: %v", ErrNoCommonCodec, err)
+	}
+}
+
+// TestForeignClient talks JSON Lines by hand, the way a client written in
+// another language would.
+func TestForeignClient(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s, addr := startServer(t, Options{HeartbeatInterval: -1})
+	defer s.Close()
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+
+	nc.Write([]byte("HELLO msgpack jsonl\n"))
+	if line, _ := r.ReadString('\n'); line != "USE jsonl\n" {
+		t.Fatalf("Expected: %q, got: %q", "USE jsonl\n", line)
+	}
+
+	nc.Write([]byte(`{"kind":"request","type":"echo","id":7,"payload":{"a":[1,2]}}` + "\n"))
+	nc.Write([]byte(`{"kind":"request","type":"add","id":8,"payload_type":"packages/protocol.pair","payload":{"X":1,"Y":2}}` + "\n"))
+
+	for _, want := range []string{
+		`{"kind":"response","type":"echo","id":7,"payload_type":"map[string]interface {}","payload":{"a":[1,2]}}`,
+		`{"kind":"response","type":"add","id":8,"payload_type":"int","payload":3}`,
+	} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, expected interface{}
+		json.Unmarshal([]byte(line), &got)
+		json.Unmarshal([]byte(want), &expected)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected: %s, got: %s", want, strings.TrimSpace(line))
+		}
+	}
+}
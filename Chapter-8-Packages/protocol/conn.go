@@ -0,0 +1,469 @@
+package protocol
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"concurrency/clock"
+)
+
+var (
+	// ErrClosed is returned for calls on a connection that has been closed.
+	ErrClosed = errors.New("protocol: connection closed")
+	// ErrPeerDead is the error a connection fails with when the other end
+	// stops answering heartbeats.
+	ErrPeerDead = errors.New("protocol: peer missed heartbeats")
+	// ErrIdle is the error a connection fails with after IdleTimeout.
+	ErrIdle = errors.New("protocol: connection idle")
+	// ErrMessageTooLarge is the error a connection fails with when the
+	// peer sends a message over MaxMessageSize.
+	ErrMessageTooLarge = errors.New("protocol: message too large")
+)
+
+// Handler serves the Requests and Notifies that arrive on a Conn. For a
+// Request, the returned payload or error is sent back as the Response; for
+// a Notify they are dropped. Messages from one connection are handled one
+// at a time, in the order they arrived.
+type Handler interface {
+	ServeMessage(c *Conn, m *Message) (interface{}, error)
+}
+
+// HandlerFunc lets an ordinary function be used as a Handler.
+type HandlerFunc func(c *Conn, m *Message) (interface{}, error)
+
+func (f HandlerFunc) ServeMessage(c *Conn, m *Message) (interface{}, error) {
+	return f(c, m)
+}
+
+// Options tunes a Conn. Zero values pick the defaults.
+type Options struct {
+	// HeartbeatInterval is how often a Ping is sent. Negative disables
+	// heartbeats. Defaults to 15 seconds.
+	HeartbeatInterval time.Duration
+	// HeartbeatTimeout is how long the peer may stay silent before the
+	// connection is closed with ErrPeerDead. Defaults to three intervals.
+	HeartbeatTimeout time.Duration
+	// QueueSize is how many received messages may wait for the handler
+	// before the connection stops reading, Responses and heartbeats
+	// included. Defaults to 64.
+	QueueSize int
+	// Codecs are the codecs offered by Dial, most preferred first, or
+	// accepted by a Server. Defaults to all of the built-in Codecs.
+	Codecs []Codec
+	// IdleTimeout closes the connection with ErrIdle when no Request,
+	// Notify or Response has gone either way for this long. Heartbeats do
+	// not count. Zero means no limit.
+	IdleTimeout time.Duration
+	// ReadTimeout bounds how long one message may take to arrive once its
+	// first bytes have, and WriteTimeout how long sending one may take, so
+	// a slow peer cannot hold a connection forever. Zero means no limit.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// MaxMessageSize closes the connection with ErrMessageTooLarge when a
+	// message needs more than this many bytes read from the network. Reads
+	// are buffered, so a few kilobytes of the following message may count
+	// too. Zero means DefaultMaxMessageSize on a Server's connections and
+	// no limit on Dial's; negative means no limit.
+	MaxMessageSize int64
+	// TLS, when set, secures the connection: Dial uses it as the client
+	// configuration and a Server as the listener's.
+	TLS   *tls.Config
+	Clock clock.Clock
+}
+
+// DefaultMaxMessageSize is the MaxMessageSize of a Server's connections
+// unless Options says otherwise, so that no client can make the server
+// buffer the largest frame a codec allows.
+const DefaultMaxMessageSize = 4 << 20
+
+func (o Options) withDefaults() Options {
+	if o.HeartbeatInterval == 0 {
+		o.HeartbeatInterval = 15 * time.Second
+	}
+	if o.HeartbeatTimeout <= 0 {
+		o.HeartbeatTimeout = 3 * o.HeartbeatInterval
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 64
+	}
+	if len(o.Codecs) == 0 {
+		o.Codecs = Codecs
+	}
+	if o.Clock == nil {
+		o.Clock = clock.New()
+	}
+	return o
+}
+
+// Conn is a long-lived, message-oriented connection. Either end may send
+// Requests and Notifies at any time; many Requests can be in flight at once
+// and their Responses are matched up by ID.
+type Conn struct {
+	nc      net.Conn
+	codec   Codec
+	handler Handler
+	opts    Options
+
+	wmu sync.Mutex
+	enc Encoder
+	out *countingWriter
+	dec Decoder
+
+	mu         sync.Mutex
+	nextID     uint64
+	pending    map[uint64]chan *Message
+	err        error
+	lastSeen   time.Time
+	lastActive time.Time
+
+	in *meteredReader
+
+	inbox chan *Message
+	done  chan struct{}
+}
+
+// NewConn starts serving nc with codec, skipping the handshake; both ends
+// must already agree on the codec. h may be nil for a connection that only
+// makes calls; Requests that arrive on it are answered with an error.
+func NewConn(nc net.Conn, codec Codec, h Handler, opts Options) *Conn {
+	opts = opts.withDefaults()
+	now := opts.Clock.Now()
+	c := &Conn{
+		nc:         nc,
+		codec:      codec,
+		handler:    h,
+		opts:       opts,
+		pending:    make(map[uint64]chan *Message),
+		lastSeen:   now,
+		lastActive: now,
+		inbox:      make(chan *Message, opts.QueueSize),
+		done:       make(chan struct{}),
+	}
+	c.out = &countingWriter{w: nc}
+	c.enc = codec.NewEncoder(c.out)
+	c.in = &meteredReader{c: c}
+	c.dec = codec.NewDecoder(c.in)
+	go c.readLoop()
+	go c.dispatch()
+	if opts.HeartbeatInterval > 0 {
+		go c.heartbeat()
+	}
+	if opts.IdleTimeout > 0 {
+		go c.idle()
+	}
+	return c
+}
+
+// Dial connects to a protocol server at addr and negotiates a codec from
+// opts.Codecs.
+func Dial(addr string, h Handler, opts Options) (*Conn, error) {
+	var nc net.Conn
+	var err error
+	if opts.TLS != nil {
+		nc, err = tls.Dial("tcp", addr, opts.TLS)
+	} else {
+		nc, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Client(nc, h, opts)
+}
+
+// Client runs the client side of the handshake on an open connection and
+// starts serving it.
+func Client(nc net.Conn, h Handler, opts Options) (*Conn, error) {
+	opts = opts.withDefaults()
+	codec, err := clientHandshake(nc, opts.Codecs)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return NewConn(nc, codec, h, opts), nil
+}
+
+// Call sends a Request and waits for its Response. A handler error on the
+// other end comes back as a RemoteError.
+func (c *Conn) Call(ctx context.Context, typ string, payload interface{}) (interface{}, error) {
+	ch := make(chan *Message, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	if err := c.send(&Message{Kind: Request, Type: typ, ID: id, Payload: payload}); err != nil {
+		c.forget(id)
+		return nil, err
+	}
+	select {
+	case m := <-ch:
+		if m.Error != "" {
+			return m.Payload, RemoteError(m.Error)
+		}
+		return m.Payload, nil
+	case <-ctx.Done():
+		c.forget(id)
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, c.Err()
+	}
+}
+
+// Notify sends a one-way message.
+func (c *Conn) Notify(typ string, payload interface{}) error {
+	return c.send(&Message{Kind: Notify, Type: typ, Payload: payload})
+}
+
+// Codec returns the codec the connection is using.
+func (c *Conn) Codec() Codec {
+	return c.codec
+}
+
+// RemoteAddr returns the address of the other end.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.nc.RemoteAddr()
+}
+
+// Done is closed once the connection has failed or been closed.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection ended, or nil while it is open.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close shuts the connection down. Calls still waiting fail with ErrClosed.
+func (c *Conn) Close() error {
+	c.fail(ErrClosed)
+	return nil
+}
+
+func (c *Conn) send(m *Message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.Err(); err != nil {
+		return err
+	}
+	if c.opts.WriteTimeout > 0 {
+		c.nc.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout))
+	}
+	// A payload that cannot be encoded is the caller's to deal with; only
+	// once bytes have gone out is the stream broken.
+	c.out.n = 0
+	if err := c.enc.Encode(m); err != nil {
+		if c.out.n > 0 {
+			c.fail(err)
+		}
+		return err
+	}
+	if m.Kind != Ping && m.Kind != Pong {
+		c.active()
+	}
+	return nil
+}
+
+func (c *Conn) active() {
+	c.mu.Lock()
+	c.lastActive = c.opts.Clock.Now()
+	c.mu.Unlock()
+}
+
+func (c *Conn) forget(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// fail records the first error, closes the socket and wakes everything
+// waiting on the connection.
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.pending = nil
+	close(c.done)
+	c.nc.Close()
+}
+
+func (c *Conn) readLoop() {
+	for {
+		var m Message
+		c.in.n = 0
+		err := c.dec.Decode(&m)
+		if c.in.tooLarge {
+			err = ErrMessageTooLarge
+		}
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		if c.opts.ReadTimeout > 0 {
+			c.nc.SetReadDeadline(time.Time{})
+		}
+		c.mu.Lock()
+		c.lastSeen = c.opts.Clock.Now()
+		if m.Kind != Ping && m.Kind != Pong {
+			c.lastActive = c.lastSeen
+		}
+		c.mu.Unlock()
+
+		switch m.Kind {
+		case Response:
+			c.mu.Lock()
+			ch := c.pending[m.ID]
+			delete(c.pending, m.ID)
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- &m
+			}
+		case Ping:
+			c.send(&Message{Kind: Pong, ID: m.ID})
+		case Pong:
+		default:
+			select {
+			case c.inbox <- &m:
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+// dispatch feeds received messages to the handler in order. While the
+// handler keeps up, Responses and heartbeats are read as they arrive; once
+// QueueSize messages are waiting, readLoop stops reading altogether, so a
+// handler that stalls that long also holds up replies and pongs and may
+// see the connection closed with ErrPeerDead.
+func (c *Conn) dispatch() {
+	for {
+		select {
+		case m := <-c.inbox:
+			c.serve(m)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Conn) serve(m *Message) {
+	var payload interface{}
+	var err error
+	if c.handler == nil {
+		err = errors.New("protocol: no handler for " + m.Type)
+	} else {
+		payload, err = c.handler.ServeMessage(c, m)
+	}
+	if m.Kind != Request {
+		return
+	}
+	resp := &Message{Kind: Response, Type: m.Type, ID: m.ID, Payload: payload}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	if err := c.send(resp); err != nil && c.Err() == nil {
+		// Still tell the caller, who would otherwise wait for ever.
+		resp.Payload = nil
+		resp.Error = "protocol: cannot send the reply: " + err.Error()
+		c.send(resp)
+	}
+}
+
+func (c *Conn) heartbeat() {
+	ticker := c.opts.Clock.NewTicker(c.opts.HeartbeatInterval)
+	defer ticker.Stop()
+	var seq uint64
+	for {
+		select {
+		case now := <-ticker.C():
+			c.mu.Lock()
+			silent := now.Sub(c.lastSeen)
+			c.mu.Unlock()
+			if silent >= c.opts.HeartbeatTimeout {
+				c.fail(ErrPeerDead)
+				return
+			}
+			seq++
+			c.send(&Message{Kind: Ping, ID: seq})
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Conn) idle() {
+	timer := c.opts.Clock.NewTimer(c.opts.IdleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case now := <-timer.C():
+			c.mu.Lock()
+			quiet := now.Sub(c.lastActive)
+			c.mu.Unlock()
+			if quiet >= c.opts.IdleTimeout {
+				c.fail(ErrIdle)
+				return
+			}
+			timer.Reset(c.opts.IdleTimeout - quiet)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// countingWriter counts the bytes of the message being encoded, so send
+// can tell a payload the codec refused from a stream broken half way.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// meteredReader sits between the socket and the decoder. It counts the
+// bytes read for the message being decoded, to enforce MaxMessageSize, and
+// starts the ReadTimeout clock when the first of them arrive. Only
+// readLoop uses it.
+type meteredReader struct {
+	c        *Conn
+	n        int64
+	tooLarge bool
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	if limit := r.c.opts.MaxMessageSize; limit > 0 {
+		if r.n >= limit {
+			r.tooLarge = true
+			return 0, ErrMessageTooLarge
+		}
+		if int64(len(p)) > limit-r.n {
+			p = p[:limit-r.n]
+		}
+	}
+	n, err := r.c.nc.Read(p)
+	if n > 0 && r.n == 0 && r.c.opts.ReadTimeout > 0 {
+		r.c.nc.SetReadDeadline(time.Now().Add(r.c.opts.ReadTimeout))
+	}
+	r.n += int64(n)
+	return n, err
+}
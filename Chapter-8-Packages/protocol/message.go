@@ -0,0 +1,123 @@
+package protocol
+
+import (
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Kind says what a Message is for.
+type Kind uint8
+
+const (
+	// Request expects a Response with the same ID.
+	Request Kind = iota + 1
+	// Response answers the Request with the same ID.
+	Response
+	// Notify is a one-way message; nothing is sent back.
+	Notify
+	// Ping and Pong are heartbeats, handled by Conn itself.
+	Ping
+	Pong
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Request:
+		return "request"
+	case Response:
+		return "response"
+	case Notify:
+		return "notify"
+	case Ping:
+		return "ping"
+	case Pong:
+		return "pong"
+	}
+	return fmt.Sprintf("kind(%d)", uint8(k))
+}
+
+// Message is the envelope for everything sent over a Conn. Type names the
+// operation ("echo", "chat.say", ...) and ID ties a Response to its Request.
+// Payload can be any type passed to Register, or a basic type.
+type Message struct {
+	Kind    Kind
+	Type    string
+	ID      uint64
+	Payload interface{}
+	// Error is set on a Response when the handler failed.
+	Error string
+}
+
+var (
+	registryMu  sync.RWMutex
+	typesByName = map[string]reflect.Type{}
+	namesByType = map[reflect.Type]string{}
+)
+
+func init() {
+	for _, v := range []interface{}{
+		false, "", []byte(nil),
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0),
+		[]string(nil), []int(nil), []float64(nil), []interface{}(nil),
+		map[string]string(nil), map[string]interface{}(nil),
+	} {
+		t := reflect.TypeOf(v)
+		typesByName[t.String()] = t
+		namesByType[t] = t.String()
+	}
+}
+
+// Register makes a payload type known to every codec, under the same name
+// gob.Register would use. Call it from an init function for every struct
+// sent as a Payload, on both ends.
+func Register(payload interface{}) {
+	t := reflect.TypeOf(payload)
+	star := ""
+	if t.Name() == "" && t.Kind() == reflect.Ptr {
+		star = "*"
+		t = t.Elem()
+	}
+	name := star + t.String()
+	if t.Name() != "" && t.PkgPath() != "" {
+		name = star + t.PkgPath() + "." + t.Name()
+	}
+	RegisterName(name, payload)
+}
+
+// RegisterName is like Register but picks the name sent on the wire, which
+// is what a client in another language puts in "payload_type".
+func RegisterName(name string, payload interface{}) {
+	gob.RegisterName(name, payload)
+	t := reflect.TypeOf(payload)
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	typesByName[name] = t
+	namesByType[t] = name
+}
+
+// payloadName returns the registered name of v's type.
+func payloadName(v interface{}) (string, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	name, ok := namesByType[reflect.TypeOf(v)]
+	return name, ok
+}
+
+// payloadType returns the type registered under name.
+func payloadType(name string) (reflect.Type, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	t, ok := typesByName[name]
+	return t, ok
+}
+
+// RemoteError is a handler error sent back in a Response.
+type RemoteError string
+
+func (e RemoteError) Error() string {
+	return string(e)
+}
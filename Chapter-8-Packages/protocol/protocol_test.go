@@ -0,0 +1,277 @@
+package protocol
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"concurrency/clock"
+	"concurrency/leakcheck"
+	"packages/tlsconfig"
+)
+
+type pair struct {
+	X, Y int
+}
+
+func init() {
+	Register(pair{})
+}
+
+func handler(c *Conn, m *Message) (interface{}, error) {
+	switch m.Type {
+	case "echo":
+		return m.Payload, nil
+	case "add":
+		p := m.Payload.(pair)
+		return p.X + p.Y, nil
+	case "push":
+		return nil, c.Notify("pushed", m.Payload)
+	case "unsendable":
+		return make(chan int), nil
+	}
+	return nil, errors.New("unknown type " + m.Type)
+}
+
+func startServer(t *testing.T, opts Options) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Handler: HandlerFunc(handler), Options: opts}
+	go s.Serve(l)
+	return s, l.Addr().String()
+}
+
+func TestCall(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s, addr := startServer(t, Options{})
+	defer s.Close()
+	c, err := Dial(addr, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	v, err := c.Call(ctx, "echo", "hello world")
+	if err != nil || v != "hello world" {
+		t.Errorf("Expected: %q, got: %v, %v", "hello world", v, err)
+	}
+	v, err = c.Call(ctx, "add", pair{2, 3})
+	if err != nil || v != 5 {
+		t.Errorf("Expected: %d, got: %v, %v", 5, v, err)
+	}
+	_, err = c.Call(ctx, "nope", nil)
+	if _, ok := err.(RemoteError); !ok {
+		t.Errorf("Expected: RemoteError, got: %T %v", err, err)
+	}
+}
+
+// TestUnencodable sends and answers with payloads no codec can encode:
+// the call fails and the connection carries on.
+func TestUnencodable(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s, addr := startServer(t, Options{})
+	defer s.Close()
+
+	ctx := context.Background()
+	for _, codec := range Codecs {
+		c, err := Dial(addr, nil, Options{Codecs: []Codec{codec}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Call(ctx, "echo", "warm up"); err != nil {
+			t.Fatalf("%s: %v", codec.Name(), err)
+		}
+		if _, err := c.Call(ctx, "echo", make(chan int)); err == nil {
+			t.Errorf("%s: Expected: an encoding error, got: <nil>", codec.Name())
+		}
+		_, err = c.Call(ctx, "unsendable", nil)
+		if err == nil || !strings.HasPrefix(err.Error(), "protocol: cannot send the reply: ") {
+			t.Errorf("%s: Expected: cannot send the reply, got: %v", codec.Name(), err)
+		}
+		if v, err := c.Call(ctx, "echo", "still here"); err != nil || v != "still here" {
+			t.Errorf("%s: Expected: %q, got: %v, %v", codec.Name(), "still here", v, err)
+		}
+		c.Close()
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s, addr := startServer(t, Options{})
+	defer s.Close()
+	c, err := Dial(addr, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := c.Call(context.Background(), "add", pair{i, i})
+			if err != nil || v != 2*i {
+				t.Errorf("Expected: %d, got: %v, %v", 2*i, v, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestServerPush(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s, addr := startServer(t, Options{})
+	defer s.Close()
+
+	pushed := make(chan interface{}, 1)
+	c, err := Dial(addr, HandlerFunc(func(c *Conn, m *Message) (interface{}, error) {
+		if m.Kind == Notify && m.Type == "pushed" {
+			pushed <- m.Payload
+		}
+		return nil, nil
+	}), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Call(context.Background(), "push", "news"); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-pushed; v != "news" {
+		t.Errorf("Expected: %q, got: %v", "news", v)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	defer leakcheck.Check(t)()
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server) // never answers
+	c := NewConn(client, Gob, nil, Options{HeartbeatInterval: -1})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call(ctx, "echo", "x"); err != context.DeadlineExceeded {
+		t.Errorf("Expected: %v, got: %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestHeartbeatDetectsDeadPeer(t *testing.T) {
+	defer leakcheck.Check(t)()
+	clk := clock.NewFake(time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC))
+	client, server := net.Pipe()
+	defer server.Close()
+
+	// The peer reads the pings but never sends a pong.
+	pings := make(chan uint64)
+	go func() {
+		dec := gob.NewDecoder(server)
+		for {
+			var m Message
+			if err := dec.Decode(&m); err != nil {
+				close(pings)
+				return
+			}
+			pings <- m.ID
+		}
+	}()
+
+	c := NewConn(client, Gob, nil, Options{HeartbeatInterval: time.Second, Clock: clk})
+	clk.BlockUntil(1)
+	for i := uint64(1); i <= 2; i++ {
+		clk.Advance(time.Second)
+		if id := <-pings; id != i {
+			t.Errorf("Expected: ping %d, got: %d", i, id)
+		}
+	}
+	clk.Advance(time.Second)
+
+	<-c.Done()
+	if c.Err() != ErrPeerDead {
+		t.Errorf("Expected: %v, got: %v", ErrPeerDead, c.Err())
+	}
+}
+
+func TestHeartbeatKeepsIdleConnection(t *testing.T) {
+	defer leakcheck.Check(t)()
+	opts := Options{HeartbeatInterval: 5 * time.Millisecond, HeartbeatTimeout: 20 * time.Millisecond}
+	s, addr := startServer(t, opts)
+	defer s.Close()
+	c, err := Dial(addr, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := c.Call(context.Background(), "echo", "still here"); err != nil {
+		t.Errorf("Expected: no error, got: %v", err)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s, addr := startServer(t, Options{})
+	c, err := Dial(addr, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Call(context.Background(), "echo", "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+	<-c.Done()
+	if _, err := c.Call(context.Background(), "echo", "x"); err == nil {
+		t.Error("Expected an error after the server closed")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	defer leakcheck.Check(t)()
+	dir := t.TempDir()
+	if err := tlsconfig.GenerateDev(dir, []string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	serverFiles, clientFiles := tlsconfig.Dev(dir, true)
+	serverConf, err := serverFiles.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConf, err := clientFiles.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, addr := startServer(t, Options{TLS: serverConf})
+	defer s.Close()
+
+	c, err := Dial(addr, nil, Options{TLS: clientConf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	v, err := c.Call(context.Background(), "echo", "secret")
+	if err != nil || v != "secret" {
+		t.Errorf("Expected: secret, got: %v %v", v, err)
+	}
+
+	// Without a client certificate the server refuses the handshake.
+	_, plain := tlsconfig.Dev(dir, false)
+	noCert, _ := plain.Client()
+	if c, err := Dial(addr, nil, Options{TLS: noCert}); err == nil {
+		c.Close()
+		t.Error("Expected: handshake error without a client certificate, got: nil")
+	}
+}
@@ -0,0 +1,243 @@
+package protocol
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrServerClosed is returned by Serve after Close.
+	ErrServerClosed = errors.New("protocol: server closed")
+	// ErrServerBusy is returned by Dial when the server is at MaxConns and
+	// turns new connections away.
+	ErrServerBusy = errors.New("protocol: server busy")
+)
+
+// OverflowPolicy says what a Server does with a new connection when it
+// already has MaxConns open.
+type OverflowPolicy int
+
+const (
+	// Reject answers the handshake with ErrServerBusy and hangs up.
+	Reject OverflowPolicy = iota
+	// Wait stops accepting until a connection closes. Clients queue up in
+	// the kernel's listen backlog meanwhile.
+	Wait
+)
+
+// Stats are a Server's counters, for monitoring.
+type Stats struct {
+	Accepted uint64 // connections accepted, including rejected ones
+	Rejected uint64 // turned away because of MaxConns
+	Active   int64  // open right now
+	Messages uint64 // Requests and Notifies handled
+	Idle     uint64 // closed by Options.IdleTimeout
+	Timeouts uint64 // closed by Options.ReadTimeout or WriteTimeout
+	TooLarge uint64 // closed by Options.MaxMessageSize
+}
+
+// Server accepts connections and serves each one with Handler, using
+// whichever of Options.Codecs the client asks for first. The timeouts and
+// size limit in Options apply to every connection.
+type Server struct {
+	Handler Handler
+	Options Options
+	// MaxConns caps the number of open connections; zero means no cap.
+	// Overflow says what happens to connections beyond it.
+	MaxConns int
+	Overflow OverflowPolicy
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*Conn]struct{}
+	closed   bool
+	quit     chan struct{}
+	slots    chan struct{}
+
+	stats Stats
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called. With
+// Options.TLS set, every connection is wrapped in TLS first.
+func (s *Server) Serve(l net.Listener) error {
+	if s.Options.TLS != nil {
+		l = tls.NewListener(l, s.Options.TLS)
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	if s.conns == nil {
+		s.conns = make(map[*Conn]struct{})
+		s.quit = make(chan struct{})
+		if s.MaxConns > 0 {
+			s.slots = make(chan struct{}, s.MaxConns)
+		}
+	}
+	s.mu.Unlock()
+
+	for {
+		if s.slots != nil && s.Overflow == Wait {
+			select {
+			case s.slots <- struct{}{}:
+			case <-s.quit:
+				return ErrServerClosed
+			}
+		}
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		atomic.AddUint64(&s.stats.Accepted, 1)
+		if s.slots != nil && s.Overflow == Reject {
+			select {
+			case s.slots <- struct{}{}:
+			default:
+				atomic.AddUint64(&s.stats.Rejected, 1)
+				go reject(nc)
+				continue
+			}
+		}
+		atomic.AddInt64(&s.stats.Active, 1)
+		go s.handshake(nc)
+	}
+}
+
+// reject reads the client's HELLO before answering, so the client is not
+// reset with its offer still unread and gets to see the reason.
+func reject(nc net.Conn) {
+	defer nc.Close()
+	nc.SetDeadline(time.Now().Add(time.Second))
+	if _, err := readLine(nc); err == nil {
+		io.WriteString(nc, "ERR "+ErrServerBusy.Error()+"\n")
+	}
+}
+
+func (s *Server) handshake(nc net.Conn) {
+	opts := s.Options.withDefaults()
+	if opts.MaxMessageSize == 0 {
+		opts.MaxMessageSize = DefaultMaxMessageSize
+	}
+	codec, err := serverHandshake(nc, opts.Codecs)
+	if err != nil {
+		nc.Close()
+		s.release()
+		return
+	}
+	s.track(NewConn(nc, codec, HandlerFunc(s.serveMessage), opts))
+}
+
+func (s *Server) serveMessage(c *Conn, m *Message) (interface{}, error) {
+	atomic.AddUint64(&s.stats.Messages, 1)
+	if s.Handler == nil {
+		return nil, errors.New("protocol: no handler for " + m.Type)
+	}
+	return s.Handler.ServeMessage(c, m)
+}
+
+func (s *Server) track(c *Conn) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		c.Close()
+		s.release()
+		return
+	}
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		<-c.Done()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		s.count(c.Err())
+		s.release()
+	}()
+}
+
+func (s *Server) count(err error) {
+	switch {
+	case err == ErrIdle:
+		atomic.AddUint64(&s.stats.Idle, 1)
+	case err == ErrMessageTooLarge:
+		atomic.AddUint64(&s.stats.TooLarge, 1)
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		atomic.AddUint64(&s.stats.Timeouts, 1)
+	}
+}
+
+func (s *Server) release() {
+	atomic.AddInt64(&s.stats.Active, -1)
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// Stats returns a snapshot of the server's counters.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Accepted: atomic.LoadUint64(&s.stats.Accepted),
+		Rejected: atomic.LoadUint64(&s.stats.Rejected),
+		Active:   atomic.LoadInt64(&s.stats.Active),
+		Messages: atomic.LoadUint64(&s.stats.Messages),
+		Idle:     atomic.LoadUint64(&s.stats.Idle),
+		Timeouts: atomic.LoadUint64(&s.stats.Timeouts),
+		TooLarge: atomic.LoadUint64(&s.stats.TooLarge),
+	}
+}
+
+// Addr returns the listener's address, or nil before Serve is called.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops the listener and closes every open connection.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.quit != nil {
+		close(s.quit)
+	}
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return err
+}
@@ -0,0 +1,199 @@
+package protocol
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"concurrency/clock"
+	"concurrency/leakcheck"
+)
+
+func startLimitedServer(t *testing.T, s *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Handler = HandlerFunc(handler)
+	go s.Serve(l)
+	return l.Addr().String()
+}
+
+// waitStats polls until ok accepts the server's counters.
+func waitStats(t *testing.T, s *Server, ok func(Stats) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok(s.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected: stats to change, got: %+v", s.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMaxConnsReject(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s := &Server{MaxConns: 1}
+	addr := startLimitedServer(t, s)
+	defer s.Close()
+
+	first, err := Dial(addr, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Dial(addr, nil, Options{}); err != ErrServerBusy {
+		t.Errorf("Expected: %v, got: %v", ErrServerBusy, err)
+	}
+	first.Close()
+	waitStats(t, s, func(st Stats) bool { return st.Active == 0 })
+
+	second, err := Dial(addr, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Close()
+	if st := s.Stats(); st.Accepted != 3 || st.Rejected != 1 {
+		t.Errorf("Expected: 3 accepted, 1 rejected, got: %+v", st)
+	}
+}
+
+func TestMaxConnsWait(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s := &Server{MaxConns: 1, Overflow: Wait}
+	addr := startLimitedServer(t, s)
+	defer s.Close()
+
+	first, err := Dial(addr, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialed := make(chan *Conn)
+	go func() {
+		c, err := Dial(addr, nil, Options{})
+		if err != nil {
+			t.Error(err)
+		}
+		dialed <- c
+	}()
+	select {
+	case <-dialed:
+		t.Fatal("Expected: second client to wait, got: connected")
+	case <-time.After(100 * time.Millisecond):
+	}
+	first.Close()
+	select {
+	case c := <-dialed:
+		if c != nil {
+			c.Close()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected: second client to connect, got: timeout")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	defer leakcheck.Check(t)()
+	clk := clock.NewFake(time.Unix(0, 0))
+	s := &Server{Options: Options{HeartbeatInterval: -1, IdleTimeout: time.Minute, Clock: clk}}
+	addr := startLimitedServer(t, s)
+	defer s.Close()
+	c, err := Dial(addr, nil, Options{HeartbeatInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	clk.BlockUntil(1)
+	clk.Advance(30 * time.Second)
+	if _, err := c.Call(context.Background(), "echo", "still here"); err != nil {
+		t.Fatal(err)
+	}
+	// The timer fires at one minute, finds 30s of quiet and waits out the
+	// rest.
+	clk.Advance(30 * time.Second)
+	clk.BlockUntil(1)
+	select {
+	case <-c.Done():
+		t.Fatal("Expected: connection open, got: closed")
+	default:
+	}
+	clk.Advance(30 * time.Second)
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected: idle connection closed, got: open")
+	}
+	waitStats(t, s, func(st Stats) bool { return st.Idle == 1 })
+}
+
+func TestReadTimeout(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s := &Server{Options: Options{ReadTimeout: 100 * time.Millisecond}}
+	addr := startLimitedServer(t, s)
+	defer s.Close()
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	io.WriteString(nc, "HELLO jsonl\n")
+	r := bufio.NewReader(nc)
+	if line, _ := r.ReadString('\n'); line != "USE jsonl\n" {
+		t.Fatalf("Expected: USE jsonl, got: %q", line)
+	}
+	// Start a message and never finish it.
+	io.WriteString(nc, `{"kind":1,"type":"ec`)
+	nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Errorf("Expected: %v, got: %v", io.EOF, err)
+	}
+	waitStats(t, s, func(st Stats) bool { return st.Timeouts == 1 })
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s := &Server{Options: Options{MaxMessageSize: 16 << 10}}
+	addr := startLimitedServer(t, s)
+	defer s.Close()
+
+	for _, codec := range Codecs {
+		c, err := Dial(addr, nil, Options{Codecs: []Codec{codec}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		if _, err := c.Call(ctx, "echo", "small"); err != nil {
+			t.Errorf("%s: %v", codec.Name(), err)
+		}
+		if _, err := c.Call(ctx, "echo", strings.Repeat("x", 64<<10)); err == nil {
+			t.Errorf("%s: Expected: error for a large message, got: nil", codec.Name())
+		}
+		c.Close()
+	}
+	waitStats(t, s, func(st Stats) bool { return st.TooLarge == uint64(len(Codecs)) })
+	if st := s.Stats(); st.Messages != uint64(len(Codecs)) {
+		t.Errorf("Expected: %d messages, got: %d", len(Codecs), st.Messages)
+	}
+}
+
+func TestDefaultMaxMessageSize(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s := &Server{}
+	addr := startLimitedServer(t, s)
+	defer s.Close()
+
+	c, err := Dial(addr, nil, Options{Codecs: []Codec{Binary}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Call(context.Background(), "echo", strings.Repeat("x", DefaultMaxMessageSize)); err == nil {
+		t.Errorf("Expected: error for a message over %d bytes, got: nil", DefaultMaxMessageSize)
+	}
+	waitStats(t, s, func(st Stats) bool { return st.TooLarge == 1 })
+}
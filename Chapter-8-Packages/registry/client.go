@@ -0,0 +1,206 @@
+package registry
+
+import (
+	"context"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"concurrency/clock"
+	"packages/rpcx"
+)
+
+// Policy chooses the instance for each call.
+type Policy int
+
+const (
+	// RoundRobin takes the instances in turn.
+	RoundRobin Policy = iota
+	// LeastOutstanding takes the instance with the fewest calls in
+	// flight, so slow instances are given less.
+	LeastOutstanding
+)
+
+// ClientOptions configures a Client. Zero values pick the defaults.
+type ClientOptions struct {
+	Policy Policy
+	// Refresh is how often the instances are looked up again. Defaults to
+	// 5s.
+	Refresh time.Duration
+	// Dial returns the dialer for an instance. Defaults to gob over TCP.
+	Dial func(addr string) rpcx.Dialer
+	// Pool configures the connections to each instance.
+	Pool  rpcx.PoolOptions
+	Clock clock.Clock
+}
+
+func (o *ClientOptions) setDefaults() {
+	if o.Refresh <= 0 {
+		o.Refresh = 5 * time.Second
+	}
+	if o.Dial == nil {
+		o.Dial = func(addr string) rpcx.Dialer {
+			return rpcx.TCPDialer(addr, nil, rpc.NewClient)
+		}
+	}
+	if o.Clock == nil {
+		o.Clock = clock.New()
+	}
+}
+
+type instance struct {
+	addr        string
+	pool        *rpcx.Pool
+	outstanding int
+}
+
+// Client calls a service on whichever of its registered instances the
+// policy picks. An instance that fails a call other than with its own
+// error is left out until the next refresh.
+type Client struct {
+	registry Caller
+	service  string
+	opts     ClientOptions
+
+	mu        sync.Mutex
+	instances []*instance
+	refreshed time.Time
+	next      int
+	closed    bool
+}
+
+// NewClient returns a client for service, found through registry.
+func NewClient(registry Caller, service string, opts ClientOptions) *Client {
+	opts.setDefaults()
+	return &Client{registry: registry, service: service, opts: opts}
+}
+
+// refresh looks the instances up again if they are due, keeping the
+// connections to those still there.
+func (c *Client) refresh() error {
+	c.mu.Lock()
+	closed := c.closed
+	due := c.instances == nil || c.opts.Clock.Now().Sub(c.refreshed) >= c.opts.Refresh
+	c.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if !due {
+		return nil
+	}
+	var addrs []string
+	if err := c.registry.Call(Name+".Lookup", c.service, &addrs); err != nil {
+		return callError(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	old := make(map[string]*instance)
+	for _, inst := range c.instances {
+		old[inst.addr] = inst
+	}
+	c.instances = c.instances[:0]
+	for _, addr := range addrs {
+		inst := old[addr]
+		if inst == nil {
+			inst = &instance{addr: addr, pool: rpcx.NewPool(c.opts.Dial(addr), c.opts.Pool)}
+		}
+		delete(old, addr)
+		c.instances = append(c.instances, inst)
+	}
+	for _, gone := range old {
+		// Calls in flight on it fail; the instance is gone anyway.
+		gone.pool.Close()
+	}
+	c.refreshed = c.opts.Clock.Now()
+	return nil
+}
+
+// pick chooses an instance and counts the call against it.
+func (c *Client) pick() (*instance, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.instances) == 0 {
+		return nil, ErrNoInstances
+	}
+	n := len(c.instances)
+	start := c.next % n
+	c.next++
+	best := c.instances[start]
+	if c.opts.Policy == LeastOutstanding {
+		// Ties go round-robin, by starting the search at the next in turn.
+		for i := 1; i < n; i++ {
+			if inst := c.instances[(start+i)%n]; inst.outstanding < best.outstanding {
+				best = inst
+			}
+		}
+	}
+	best.outstanding++
+	return best, nil
+}
+
+func (c *Client) done(inst *instance, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	inst.outstanding--
+	if _, ok := err.(rpc.ServerError); ok || err == nil || err == context.Canceled || err == context.DeadlineExceeded {
+		return
+	}
+	for i, other := range c.instances {
+		if other == inst {
+			c.instances = append(c.instances[:i:i], c.instances[i+1:]...)
+			inst.pool.Close()
+			break
+		}
+	}
+	if len(c.instances) == 0 {
+		// Nothing left: look up again on the next call.
+		c.instances = nil
+	}
+}
+
+// Call calls serviceMethod on one instance, within the pool's timeout.
+func (c *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return c.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+// CallContext calls serviceMethod on one instance, giving up when ctx is
+// done.
+func (c *Client) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	if err := c.refresh(); err != nil {
+		return err
+	}
+	inst, err := c.pick()
+	if err != nil {
+		return err
+	}
+	err = inst.pool.CallContext(ctx, serviceMethod, args, reply)
+	c.done(inst, err)
+	return err
+}
+
+// Instances returns the addresses calls currently go to.
+func (c *Client) Instances() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	addrs := make([]string, len(c.instances))
+	for i, inst := range c.instances {
+		addrs[i] = inst.addr
+	}
+	return addrs
+}
+
+// Close closes the connections to every instance.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, inst := range c.instances {
+		inst.pool.Close()
+	}
+	c.instances = nil
+	return nil
+}
@@ -0,0 +1,103 @@
+package registry
+
+import (
+	"context"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"concurrency/clock"
+)
+
+// Caller makes RPC calls; *rpc.Client, *rpcx.Client and *rpcx.Pool all do.
+type Caller interface {
+	Call(serviceMethod string, args interface{}, reply interface{}) error
+}
+
+// callError maps the registry's errors, which arrive as text, back to the
+// package's.
+func callError(err error) error {
+	if serr, ok := err.(rpc.ServerError); ok {
+		for _, known := range []error{ErrBadInstance, ErrNoInstances} {
+			if string(serr) == known.Error() {
+				return known
+			}
+		}
+	}
+	return err
+}
+
+// Registration keeps an instance registered until closed.
+type Registration struct {
+	registry Caller
+	inst     Instance
+	clock    clock.Clock
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// Join registers inst and keeps renewing its lease, three times per TTL so
+// that a lost heartbeat or two is survived. Close deregisters it. A lease
+// too short to renew that often is given up with ErrShortLease.
+func Join(registry Caller, inst Instance, clk clock.Clock) (*Registration, error) {
+	if clk == nil {
+		clk = clock.New()
+	}
+	var lease Lease
+	if err := registry.Call(Name+".Register", inst, &lease); err != nil {
+		return nil, callError(err)
+	}
+	interval := lease.TTL / 3
+	if interval <= 0 {
+		var ok bool
+		registry.Call(Name+".Deregister", inst, &ok)
+		return nil, ErrShortLease
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Registration{registry: registry, inst: inst, clock: clk, cancel: cancel, done: make(chan struct{})}
+	go r.heartbeat(ctx, interval)
+	return r, nil
+}
+
+func (r *Registration) heartbeat(ctx context.Context, interval time.Duration) {
+	defer close(r.done)
+	ticker := r.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+		var lease Lease
+		err := callError(r.registry.Call(Name+".Register", r.inst, &lease))
+		r.mu.Lock()
+		r.lastErr = err
+		r.mu.Unlock()
+		// A lease too short to renew keeps the old pace.
+		if err == nil && lease.TTL/3 > 0 && lease.TTL/3 != interval {
+			interval = lease.TTL / 3
+			ticker.Reset(interval)
+		}
+	}
+}
+
+// Err is the error of the last heartbeat, if it failed. The heartbeats
+// carry on regardless, and recover the registration once they get
+// through.
+func (r *Registration) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastErr
+}
+
+// Close stops the heartbeats and deregisters the instance.
+func (r *Registration) Close() error {
+	r.cancel()
+	<-r.done
+	var ok bool
+	return callError(r.registry.Call(Name+".Deregister", r.inst, &ok))
+}
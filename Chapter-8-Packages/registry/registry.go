@@ -0,0 +1,153 @@
+// Package registry keeps track of which servers run which RPC services.
+// The registry is an RPC service itself: servers Join it and renew their
+// lease with heartbeats, and are forgotten when the lease runs out.
+// Clients resolve a service name to the live instances and balance calls
+// over them.
+package registry
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"concurrency/clock"
+)
+
+// Name is the service name the registry is called under.
+const Name = "Registry"
+
+// MinTTL is the shortest lease the registry grants, whatever an instance
+// asks for.
+const MinTTL = time.Second
+
+var (
+	ErrBadInstance = errors.New("registry: instance needs a service name and an address")
+	ErrNoInstances = errors.New("registry: no instances of the service")
+	ErrClosed      = errors.New("registry: client closed")
+	ErrShortLease  = errors.New("registry: lease too short to renew")
+)
+
+// Instance is one server offering a service at an address.
+type Instance struct {
+	Service string
+	Addr    string
+	// TTL is how long the registration lasts without a heartbeat. The
+	// registry's DefaultTTL, MaxTTL and MinTTL apply.
+	TTL time.Duration
+}
+
+// Lease is the reply to Register: renew within TTL.
+type Lease struct {
+	TTL time.Duration
+}
+
+// Options configures a Registry. Zero values pick the defaults.
+type Options struct {
+	// DefaultTTL is used when an instance asks for none. Defaults to 10s.
+	DefaultTTL time.Duration
+	// MaxTTL caps what instances may ask for. Defaults to one minute.
+	MaxTTL time.Duration
+	Clock  clock.Clock
+}
+
+func (o *Options) setDefaults() {
+	if o.DefaultTTL <= 0 {
+		o.DefaultTTL = 10 * time.Second
+	}
+	if o.MaxTTL <= 0 {
+		o.MaxTTL = time.Minute
+	}
+	if o.Clock == nil {
+		o.Clock = clock.New()
+	}
+}
+
+// Registry is the RPC receiver holding the registrations.
+type Registry struct {
+	opts Options
+
+	mu       sync.Mutex
+	services map[string]map[string]time.Time // service -> addr -> expiry
+}
+
+// New returns an empty registry.
+func New(opts Options) *Registry {
+	opts.setDefaults()
+	return &Registry{opts: opts, services: make(map[string]map[string]time.Time)}
+}
+
+// Registrar is an RPC server to register with, such as *rpc.Server or
+// *rpcx.Server.
+type Registrar interface {
+	RegisterName(name string, rcvr interface{}) error
+}
+
+// Register adds r to s under Name.
+func Register(s Registrar, r *Registry) error {
+	return s.RegisterName(Name, r)
+}
+
+// Register adds an instance or renews its lease; heartbeats are simply
+// registering again.
+func (r *Registry) Register(inst Instance, lease *Lease) error {
+	if inst.Service == "" || inst.Addr == "" {
+		return ErrBadInstance
+	}
+	ttl := inst.TTL
+	if ttl <= 0 {
+		ttl = r.opts.DefaultTTL
+	}
+	if ttl > r.opts.MaxTTL {
+		ttl = r.opts.MaxTTL
+	}
+	if ttl < MinTTL {
+		ttl = MinTTL
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	addrs := r.services[inst.Service]
+	if addrs == nil {
+		addrs = make(map[string]time.Time)
+		r.services[inst.Service] = addrs
+	}
+	addrs[inst.Addr] = r.opts.Clock.Now().Add(ttl)
+	lease.TTL = ttl
+	return nil
+}
+
+// Deregister removes an instance at once, as a server shutting down
+// cleanly does. Removing an unknown instance is not an error.
+func (r *Registry) Deregister(inst Instance, ok *bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if addrs := r.services[inst.Service]; addrs != nil {
+		_, *ok = addrs[inst.Addr]
+		delete(addrs, inst.Addr)
+		if len(addrs) == 0 {
+			delete(r.services, inst.Service)
+		}
+	}
+	return nil
+}
+
+// Lookup replies with the addresses of the live instances of service,
+// sorted, or ErrNoInstances.
+func (r *Registry) Lookup(service string, addrs *[]string) error {
+	now := r.opts.Clock.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for addr, expires := range r.services[service] {
+		if now.Before(expires) {
+			*addrs = append(*addrs, addr)
+		} else {
+			delete(r.services[service], addr)
+		}
+	}
+	if len(*addrs) == 0 {
+		delete(r.services, service)
+		return ErrNoInstances
+	}
+	sort.Strings(*addrs)
+	return nil
+}
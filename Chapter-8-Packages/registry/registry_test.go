@@ -0,0 +1,306 @@
+package registry
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"concurrency/clock"
+	"concurrency/leakcheck"
+	"packages/rpcx"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func lookup(r *Registry, service string) string {
+	var addrs []string
+	err := r.Lookup(service, &addrs)
+	return fmt.Sprint(addrs, " ", err)
+}
+
+func TestRegistry(t *testing.T) {
+	fake := clock.NewFake(epoch)
+	r := New(Options{DefaultTTL: 10 * time.Second, MaxTTL: 30 * time.Second, Clock: fake})
+	var lease Lease
+	if err := r.Register(Instance{Service: "calc"}, &lease); err != ErrBadInstance {
+		t.Errorf("Expected: %v, got: %v", ErrBadInstance, err)
+	}
+	r.Register(Instance{Service: "calc", Addr: "b:1"}, &lease)
+	if lease.TTL != 10*time.Second {
+		t.Errorf("Expected: %v, got: %v", 10*time.Second, lease.TTL)
+	}
+	r.Register(Instance{Service: "calc", Addr: "a:1", TTL: time.Hour}, &lease)
+	if lease.TTL != 30*time.Second {
+		t.Errorf("Expected: %v, got: %v", 30*time.Second, lease.TTL)
+	}
+	r.Register(Instance{Service: "calc", Addr: "d:1", TTL: 2}, &lease)
+	if lease.TTL != MinTTL {
+		t.Errorf("Expected: %v, got: %v", MinTTL, lease.TTL)
+	}
+	var ok bool
+	r.Deregister(Instance{Service: "calc", Addr: "d:1"}, &ok)
+	r.Register(Instance{Service: "calc", Addr: "c:1", TTL: 20 * time.Second}, &lease)
+
+	type testPair struct {
+		advance time.Duration
+		action  func()
+		want    string
+	}
+	tests := []testPair{
+		{0, nil, "[a:1 b:1 c:1] <nil>"},
+		{10 * time.Second, nil, "[a:1 c:1] <nil>"},
+		// A heartbeat renews b.
+		{0, func() { r.Register(Instance{Service: "calc", Addr: "b:1"}, &lease) }, "[a:1 b:1 c:1] <nil>"},
+		{0, func() {
+			var ok bool
+			r.Deregister(Instance{Service: "calc", Addr: "c:1"}, &ok)
+		}, "[a:1 b:1] <nil>"},
+		{10 * time.Second, nil, "[a:1] <nil>"},
+		{10 * time.Second, nil, "[] " + ErrNoInstances.Error()},
+	}
+	for i, test := range tests {
+		fake.Advance(test.advance)
+		if test.action != nil {
+			test.action()
+		}
+		if got := lookup(r, "calc"); got != test.want {
+			t.Errorf("step %d: Expected: %s, got: %s", i, test.want, got)
+		}
+	}
+	if got := lookup(r, "other"); got != "[] "+ErrNoInstances.Error() {
+		t.Errorf("Expected: no instances, got: %s", got)
+	}
+}
+
+// countingCaller tells when each call to the registry has been made.
+type countingCaller struct {
+	Caller
+	calls chan string
+}
+
+func (c *countingCaller) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	err := c.Caller.Call(serviceMethod, args, reply)
+	c.calls <- serviceMethod
+	return err
+}
+
+// startRegistry serves r on a listener and returns a client for it.
+func startRegistry(t *testing.T, r *Registry) (net.Listener, *rpc.Client) {
+	s := rpcx.NewServer()
+	if err := Register(s, r); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Accept(l)
+	c, err := rpc.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, c
+}
+
+func TestJoin(t *testing.T) {
+	defer leakcheck.Check(t)()
+	fake := clock.NewFake(epoch)
+	r := New(Options{Clock: fake})
+	l, rc := startRegistry(t, r)
+	defer l.Close()
+	defer rc.Close()
+	caller := &countingCaller{Caller: rc, calls: make(chan string, 10)}
+
+	if _, err := Join(caller, Instance{Addr: "a:1"}, fake); err != ErrBadInstance {
+		t.Errorf("Expected: %v, got: %v", ErrBadInstance, err)
+	}
+	<-caller.calls
+	reg, err := Join(caller, Instance{Service: "calc", Addr: "a:1", TTL: 3 * time.Second}, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-caller.calls
+
+	// Heartbeats every second keep the lease going well past its TTL.
+	fake.BlockUntil(1)
+	for i := 0; i < 5; i++ {
+		fake.Advance(time.Second)
+		if got := <-caller.calls; got != "Registry.Register" {
+			t.Errorf("Expected: Registry.Register, got: %s", got)
+		}
+	}
+	if got := lookup(r, "calc"); got != "[a:1] <nil>" {
+		t.Errorf("Expected: [a:1] <nil>, got: %s", got)
+	}
+	if err := reg.Err(); err != nil {
+		t.Errorf("Expected: %v, got: %v", nil, err)
+	}
+
+	if err := reg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-caller.calls
+	if got := lookup(r, "calc"); got != "[] "+ErrNoInstances.Error() {
+		t.Errorf("Expected: no instances, got: %s", got)
+	}
+}
+
+// shortLease is a registry granting leases too short to renew.
+type shortLease struct {
+	calls []string
+}
+
+func (s *shortLease) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	s.calls = append(s.calls, serviceMethod)
+	if lease, ok := reply.(*Lease); ok {
+		lease.TTL = 2
+	}
+	return nil
+}
+
+func TestJoinShortLease(t *testing.T) {
+	defer leakcheck.Check(t)()
+	caller := &shortLease{}
+	if _, err := Join(caller, Instance{Service: "calc", Addr: "a:1"}, clock.NewFake(epoch)); err != ErrShortLease {
+		t.Errorf("Expected: %v, got: %v", ErrShortLease, err)
+	}
+	if got := fmt.Sprint(caller.calls); got != "[Registry.Register Registry.Deregister]" {
+		t.Errorf("Expected: [Registry.Register Registry.Deregister], got: %s", got)
+	}
+}
+
+// Who answers with the address of the instance it runs in.
+type Who struct {
+	addr    string
+	started chan string
+	release chan struct{}
+}
+
+func (w *Who) Name(wait bool, reply *string) error {
+	if wait {
+		w.started <- w.addr
+		<-w.release
+	}
+	*reply = w.addr
+	return nil
+}
+
+// startInstances starts n servers of Who and registers them.
+func startInstances(t *testing.T, r *Registry, n int) ([]net.Listener, *Who) {
+	who := &Who{started: make(chan string, n), release: make(chan struct{})}
+	var ls []net.Listener
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := rpcx.NewServer()
+		s.Register(&Who{addr: l.Addr().String(), started: who.started, release: who.release})
+		go s.Accept(l)
+		var lease Lease
+		r.Register(Instance{Service: "who", Addr: l.Addr().String()}, &lease)
+		ls = append(ls, l)
+	}
+	return ls, who
+}
+
+func TestClientBalancing(t *testing.T) {
+	defer leakcheck.Check(t)()
+	type testPair struct {
+		name   string
+		policy Policy
+		// Whether calls keep away from the instance stuck on a slow call.
+		avoidsSlow bool
+	}
+	tests := []testPair{
+		{"round robin", RoundRobin, false},
+		{"least outstanding", LeastOutstanding, true},
+	}
+	for _, test := range tests {
+		r := New(Options{})
+		rl, rc := startRegistry(t, r)
+		ls, who := startInstances(t, r, 3)
+		c := NewClient(rc, "who", ClientOptions{Policy: test.policy})
+
+		// The first call goes to the first instance and hangs there.
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply string
+			if err := c.Call("Who.Name", true, &reply); err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+		}()
+		slow := <-who.started
+
+		counts := make(map[string]int)
+		for i := 0; i < 6; i++ {
+			var reply string
+			if err := c.Call("Who.Name", false, &reply); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			counts[reply]++
+		}
+		if got := counts[slow] == 0; got != test.avoidsSlow {
+			t.Errorf("%s: Expected: slow instance avoided %v, got: counts %v", test.name, test.avoidsSlow, counts)
+		}
+		if !test.avoidsSlow {
+			for _, l := range ls {
+				if counts[l.Addr().String()] != 2 {
+					t.Errorf("%s: Expected: 2 calls each, got: %v", test.name, counts)
+				}
+			}
+		}
+
+		close(who.release)
+		wg.Wait()
+		c.Close()
+		rc.Close()
+		rl.Close()
+		for _, l := range ls {
+			l.Close()
+		}
+	}
+}
+
+func TestClientFailover(t *testing.T) {
+	defer leakcheck.Check(t)()
+	r := New(Options{})
+	rl, rc := startRegistry(t, r)
+	defer rl.Close()
+	defer rc.Close()
+	ls, _ := startInstances(t, r, 2)
+	// Calls start with the lowest address.
+	sort.Slice(ls, func(i, j int) bool { return ls[i].Addr().String() < ls[j].Addr().String() })
+	defer ls[1].Close()
+	c := NewClient(rc, "who", ClientOptions{Pool: rpcx.PoolOptions{Backoff: time.Millisecond}})
+	defer c.Close()
+
+	// The first instance dies without deregistering.
+	ls[0].Close()
+	var reply string
+	if err := c.Call("Who.Name", false, &reply); err == nil {
+		t.Errorf("Expected: an error from the dead instance, got: %v", reply)
+	}
+	want := fmt.Sprint([]string{ls[1].Addr().String()})
+	if got := fmt.Sprint(c.Instances()); got != want {
+		t.Errorf("Expected: %s, got: %s", want, got)
+	}
+	if err := c.Call("Who.Name", false, &reply); err != nil || reply != ls[1].Addr().String() {
+		t.Errorf("Expected: %s <nil>, got: %s %v", ls[1].Addr(), reply, err)
+	}
+
+	nothing := NewClient(rc, "nothing", ClientOptions{})
+	if err := nothing.Call("Who.Name", false, &reply); err != ErrNoInstances {
+		t.Errorf("Expected: %v, got: %v", ErrNoInstances, err)
+	}
+	c.Close()
+	if err := c.Call("Who.Name", false, &reply); err != ErrClosed {
+		t.Errorf("Expected: %v, got: %v", ErrClosed, err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/rpc"
+	"packages/calculator"
+	"packages/registry"
+	"packages/rpcx"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Runs a registry, several calculator servers that join it, and a client
+// that finds them through it and spreads its calls over them:
+//
+//	go run rpc_registry.go -instances 3 -policy least
+
+// counter counts the calls each instance serves.
+type counter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *counter) interceptor(addr string) rpcx.Interceptor {
+	return func(call *rpcx.Call, next func() error) error {
+		c.mu.Lock()
+		c.counts[addr]++
+		c.mu.Unlock()
+		return next()
+	}
+}
+
+func (c *counter) print() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var addrs []string
+	for addr := range c.counts {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		fmt.Printf("  %s served %d\n", addr, c.counts[addr])
+	}
+	c.counts = make(map[string]int)
+}
+
+type instance struct {
+	listener net.Listener
+	reg      *registry.Registration
+}
+
+// startInstance serves the calculator on a port of its own and joins the
+// registry.
+func startInstance(rc *rpc.Client, ttl time.Duration, calls *counter) (*instance, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	addr := l.Addr().String()
+	srv := rpcx.NewServer(calls.interceptor(addr))
+	calculator.Register(srv)
+	go srv.Accept(l)
+	reg, err := registry.Join(rc, registry.Instance{Service: calculator.Name, Addr: addr, TTL: ttl}, nil)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &instance{listener: l, reg: reg}, nil
+}
+
+// spread makes n calls, 4 at a time.
+func spread(calc *calculator.Client, n int) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 4)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if _, err := calc.Average(float64(i), float64(i+1)); err != nil {
+				fmt.Println("call failed:", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8090", "Address of the registry")
+	instances := flag.Int("instances", 3, "Calculator servers to start")
+	policy := flag.String("policy", "rr", "Balancing: rr (round-robin) or least (least outstanding requests)")
+	calls := flag.Int("calls", 12, "Calls to make each round")
+	ttl := flag.Duration("ttl", 2*time.Second, "Lease of each server in the registry")
+	flag.Parse()
+
+	balancing := registry.RoundRobin
+	if *policy == "least" {
+		balancing = registry.LeastOutstanding
+	}
+
+	// The registry is an RPC service like any other.
+	srv := rpcx.NewServer()
+	registry.Register(srv, registry.New(registry.Options{}))
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer l.Close()
+	go srv.Accept(l)
+	rc, err := rpc.Dial("tcp", *addr)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rc.Close()
+
+	counts := &counter{counts: make(map[string]int)}
+	var running []*instance
+	for i := 0; i < *instances; i++ {
+		inst, err := startInstance(rc, *ttl, counts)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		running = append(running, inst)
+	}
+
+	client := registry.NewClient(rc, calculator.Name, registry.ClientOptions{Policy: balancing, Refresh: *ttl / 2})
+	calc := calculator.NewClient(client)
+	defer calc.Close()
+
+	spread(calc, *calls)
+	fmt.Println("Calls spread over", client.Instances())
+	counts.print()
+
+	// One server shuts down and leaves the registry.
+	gone := running[0]
+	gone.reg.Close()
+	gone.listener.Close()
+	fmt.Println("Stopped", gone.listener.Addr())
+	time.Sleep(*ttl)
+
+	spread(calc, *calls)
+	fmt.Println("Calls spread over", client.Instances())
+	counts.print()
+
+	for _, inst := range running[1:] {
+		inst.reg.Close()
+		inst.listener.Close()
+	}
+}
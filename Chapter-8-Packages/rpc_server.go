@@ -1,9 +1,26 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
+	"io"
+	"log"
 	"net"
+	"net/http"
 	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"packages/calculator"
+	"packages/hashstream"
+	"packages/jsonrpc2"
+	"packages/rpcx"
+	"packages/stubs"
+	"packages/tlsconfig"
+	"packages/udp"
+	"strings"
+	"time"
 )
 
 type Server struct{}
@@ -13,11 +30,36 @@ func (this *Server) Negate(i int64, reply *int64) error {
 	return nil
 }
 
-func server_rpc() {
-	rpc.Register(new(Server))
-	listener, err := net.Listen("tcp", ":8080")
-	if err != nil {
-		fmt.Println(err)
+// A batch for the HTTP transport, as a browser or script would send it.
+const httpBatch = `[
+	{"jsonrpc": "2.0", "method": "Server.Negate", "params": [99], "id": 1},
+	{"jsonrpc": "2.0", "method": "Calculator.Average", "params": {"Xs": [3, 1, 4, 1, 5, 9, 2, 6]}, "id": 2},
+	{"jsonrpc": "2.0", "method": "Calculator.Div", "params": {"A": 1, "B": 0}, "id": 3},
+	{"jsonrpc": "2.0", "method": "Calculator.Pow", "id": 4},
+	{"jsonrpc": "2.0", "method": "Introspect.Services", "params": ["Server"], "id": 5}
+]`
+
+func server_rpc(listener net.Listener, tlsConf *tls.Config, transport string) {
+	logger := log.New(os.Stdout, "server: ", 0)
+	srv := rpcx.NewServer(rpcx.Recover(logger), rpcx.Logging(logger), rpcx.Auth(rpcx.LocalOrCertified))
+	srv.Register(new(Server))
+	calculator.Register(srv)
+	// Hashes of the files under the directory the server runs in.
+	hashstream.Register(srv, ".", rpcx.StreamOptions{})
+	srv.RegisterIntrospection()
+	if tlsConf != nil {
+		listener = tls.NewListener(listener, tlsConf)
+	}
+	// Answer "rpc" lookups from clients on the local network.
+	if a, err := udp.Announce(udp.Service{Name: "rpc", Addr: ":8080"}, udp.DiscoveryOptions{}); err != nil {
+		fmt.Println("Not announcing:", err)
+	} else {
+		defer a.Close()
+	}
+	if transport == "http" {
+		mux := http.NewServeMux()
+		mux.Handle("/rpc", jsonrpc2.Handler(srv))
+		fmt.Println(http.Serve(listener, mux))
 		return
 	}
 	for {
@@ -25,28 +67,201 @@ func server_rpc() {
 		if err != nil {
 			continue
 		}
-		go rpc.ServeConn(conn)
+		if transport == "jsonrpc" {
+			go srv.ServeConnCodec(conn, jsonrpc.NewServerCodec(conn))
+		} else {
+			go srv.ServeConn(conn)
+		}
 	}
 }
 
-func client_rpc() {
-	conn, err := rpc.Dial("tcp", "127.0.0.1:8080")
+// client_http posts httpBatch and prints the responses as they come.
+func client_http(addr string, tlsConf *tls.Config) {
+	scheme := "http"
+	if tlsConf != nil {
+		scheme = "https"
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConf}}
+	resp, err := client.Post(scheme+"://"+addr+"/rpc", "application/json", strings.NewReader(httpBatch))
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	var result int64
-	err = conn.Call("Server.Negate", int64(99), &result)
+	defer resp.Body.Close()
+	fmt.Println(resp.Status)
+	io.Copy(os.Stdout, resp.Body)
+}
+
+// client_streams receives the hashes of dir on the server as it is walked,
+// then sends the hashes of the same directory here to compare.
+func client_streams(conn rpcx.Caller, dir string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	walk, err := hashstream.OpenWalk(ctx, conn, dir)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer walk.Close()
+	var files int
+	var bytes int64
+	for {
+		h, err := walk.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Println("walk:", err)
+			return
+		}
+		files++
+		bytes += h.Size
+		fmt.Printf("%.12s %8d %s\n", h.SHA256, h.Size, h.Path)
+	}
+	fmt.Println("server hashed", files, "files,", bytes, "bytes")
+
+	verify, err := hashstream.OpenVerify(ctx, conn, dir)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer verify.Close()
+	if err := hashstream.Walk(dir, verify.Send); err != nil {
+		fmt.Println("verify:", err)
+		return
+	}
+	report, err := verify.CloseAndRecv()
+	if err != nil {
+		fmt.Println("verify:", err)
+		return
+	}
+	fmt.Printf("verified: %d match, differ %v, missing %v, extra %v\n", report.Matched, report.Differ, report.Missing, report.Extra)
+}
+
+func client_rpc(addr string, tlsConf *tls.Config, transport string, dir string) {
+	if transport == "http" {
+		client_http(addr, tlsConf)
+		return
+	}
+	newClient := rpc.NewClient
+	if transport == "jsonrpc" {
+		newClient = jsonrpc.NewClient
+	}
+	dial := rpcx.TCPDialer(addr, tlsConf, newClient)
+	timing := rpcx.Timing(func(c *rpcx.Call, d time.Duration, err error) {
+		fmt.Println("client:", c.Name(), "took", d)
+	})
+	// Everything but Server.Negate may be sent twice without harm.
+	conn := rpcx.NewPool(dial, rpcx.PoolOptions{
+		Timeout:      5 * time.Second,
+		Idempotent:   []string{calculator.Name},
+		Interceptors: []rpcx.Interceptor{timing},
+	})
+	// What is there to call?
+	var services []rpcx.ServiceInfo
+	if err := conn.Call("Introspect.Services", "", &services); err != nil {
+		fmt.Println(err)
+	}
+	for _, s := range services {
+		for _, m := range s.Methods {
+			fmt.Printf("%s.%s(%s) %s\n", s.Name, m.Name, m.Args.Type, m.Reply.Type)
+		}
+	}
+
+	// The generated stub, from go generate ./stubs.
+	result, err := stubs.NewServerStub(conn).Negate(99)
 	if err != nil {
 		fmt.Println(err)
 	} else {
 		fmt.Println("server negate result:", result)
 	}
+
+	// Three calls at once, collected as they finish.
+	xs := []float64{3, 1, 4, 1, 5, 9, 2, 6}
+	var avg, max, min calculator.Result
+	ctx := context.Background()
+	futures := []*rpcx.Future{
+		conn.Go(ctx, "Calculator.Average", calculator.Numbers{Xs: xs}, &avg),
+		conn.Go(ctx, "Calculator.Max", calculator.Numbers{Xs: xs}, &max),
+		conn.Go(ctx, "Calculator.Min", calculator.Numbers{Xs: xs}, &min),
+	}
+	for _, f := range futures {
+		if err := f.Err(); err != nil {
+			fmt.Println(err)
+		}
+	}
+	fmt.Println("average:", avg.Value, "max:", max.Value, "min:", min.Value)
+
+	// A deadline too short for any server.
+	ctx, cancel := context.WithTimeout(ctx, time.Nanosecond)
+	defer cancel()
+	if err := conn.CallContext(ctx, "Calculator.Average", calculator.Numbers{Xs: xs}, &avg); err != nil {
+		fmt.Println("average in 1ns:", err)
+	}
+
+	// The pool serves the calculator's own client too.
+	calc := calculator.NewClient(conn)
+	defer calc.Close()
+	if _, err := calc.Average(); err == calculator.ErrEmpty {
+		fmt.Println("average of nothing:", err)
+	}
+	if q, err := calc.Div(22, 7); err == nil {
+		fmt.Println("22 / 7 =", q)
+	}
+	if _, err := calc.Div(1, 0); err != nil {
+		fmt.Println("1 / 0:", err)
+	}
+
+	client_streams(conn, dir)
+}
+
+// lookup finds a server by name on the local network, or returns fallback.
+func lookup(name, fallback string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	s, err := udp.Lookup(ctx, name, udp.DiscoveryOptions{})
+	if err != nil {
+		fmt.Println("Discovery failed:", err)
+		return fallback
+	}
+	fmt.Println("Discovered", name, "at", s.Addr)
+	return s.Addr
 }
 
 func main() {
-	go server_rpc()
-	go client_rpc()
+	certs := flag.String("tls", "", "Directory from gen_certs.go; enables TLS")
+	mtls := flag.Bool("mtls", false, "Require client certificates (with -tls)")
+	discover := flag.Bool("discover", false, "Find the server by multicast instead of dialing 127.0.0.1")
+	transport := flag.String("transport", "gob", "gob, jsonrpc (JSON-RPC 1.0 over TCP) or http (JSON-RPC 2.0 POSTed to /rpc)")
+	walk := flag.String("walk", "filehash", "Directory to stream file hashes of, relative to this one")
+	flag.Parse()
+
+	switch *transport {
+	case "gob", "jsonrpc", "http":
+	default:
+		fmt.Println("Unknown transport:", *transport)
+		return
+	}
+
+	serverTLS, clientTLS, err := tlsconfig.LoadDev(*certs, *mtls)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	// Listen before starting the client so it never dials too early.
+	listener, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	go server_rpc(listener, serverTLS, *transport)
+	go func() {
+		addr := "127.0.0.1:8080"
+		if *discover {
+			addr = lookup("rpc", addr)
+		}
+		client_rpc(addr, clientTLS, *transport, *walk)
+	}()
 	var input string
 	fmt.Scanln(&input)
 }
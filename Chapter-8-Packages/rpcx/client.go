@@ -0,0 +1,29 @@
+package rpcx
+
+import "net/rpc"
+
+// Client is an rpc.Client whose calls go through interceptors, the first
+// outermost. Call.Conn is always nil on this side.
+type Client struct {
+	rpc          *rpc.Client
+	interceptors []Interceptor
+}
+
+// NewClient wraps c, which should not then be used directly.
+func NewClient(c *rpc.Client, interceptors ...Interceptor) *Client {
+	return &Client{rpc: c, interceptors: interceptors}
+}
+
+// Call calls serviceMethod as rpc.Client.Call does. Errors from the server
+// still arrive as rpc.ServerError unless an interceptor changes them.
+func (c *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	call := newCall(serviceMethod, args, reply)
+	return chain(c.interceptors, call, func() error {
+		return c.rpc.Call(serviceMethod, args, reply)
+	})
+}
+
+// Close closes the underlying client.
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
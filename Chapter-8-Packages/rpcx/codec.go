@@ -0,0 +1,51 @@
+package rpcx
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"net/rpc"
+)
+
+// gobServerCodec is net/rpc's own server codec, which it does not export.
+type gobServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+func newGobServerCodec(rwc io.ReadWriteCloser) *gobServerCodec {
+	buf := bufio.NewWriter(rwc)
+	return &gobServerCodec{rwc: rwc, dec: gob.NewDecoder(rwc), enc: gob.NewEncoder(buf), encBuf: buf}
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	if err = c.enc.Encode(r); err == nil {
+		if err = c.enc.Encode(body); err == nil {
+			return c.encBuf.Flush()
+		}
+	}
+	// A half-written response leaves the stream unreadable.
+	if c.encBuf.Flush() == nil {
+		c.Close()
+	}
+	return err
+}
+
+func (c *gobServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
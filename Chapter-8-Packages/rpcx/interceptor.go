@@ -0,0 +1,152 @@
+package rpcx
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInternal is what callers see of a method that panicked under
+	// Recover.
+	ErrInternal = errors.New("rpcx: internal error")
+	// ErrUnauthorized is what LocalOrCertified refuses calls with.
+	ErrUnauthorized = errors.New("rpcx: unauthorized: connect from this machine or with a client certificate")
+)
+
+// Call describes one call as it passes through the interceptors.
+type Call struct {
+	Service string
+	Method  string
+	// Args is the argument as the method receives it; Reply is the pointer
+	// it fills in. On the client, they are what was passed to Client.Call.
+	Args  interface{}
+	Reply interface{}
+	// Conn is the connection the call came in on, or nil on the client and
+	// for codecs served without one.
+	Conn net.Conn
+	// RemoteAddr and TLS say who is calling: taken from Conn, or from a
+	// PeerCodec such as the one per HTTP request. RemoteAddr is nil when
+	// nobody knows, and TLS is nil without TLS.
+	RemoteAddr net.Addr
+	TLS        *tls.ConnectionState
+}
+
+// Name is "Service.Method".
+func (c *Call) Name() string {
+	return c.Service + "." + c.Method
+}
+
+func newCall(serviceMethod string, args, reply interface{}) *Call {
+	c := &Call{Method: serviceMethod, Args: args, Reply: reply}
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		c.Service, c.Method = serviceMethod[:dot], serviceMethod[dot+1:]
+	}
+	return c
+}
+
+// An Interceptor runs around a call. It calls next to carry on down the
+// chain, or returns without doing so to stop the call. Whatever error it
+// returns is the call's error.
+type Interceptor func(c *Call, next func() error) error
+
+// chain runs invoke inside interceptors, the first one outermost.
+func chain(interceptors []Interceptor, c *Call, invoke func() error) error {
+	var next func(i int) error
+	next = func(i int) error {
+		if i == len(interceptors) {
+			return invoke()
+		}
+		return interceptors[i](c, func() error { return next(i + 1) })
+	}
+	return next(0)
+}
+
+// Logging logs every call with its arguments, reply and error.
+func Logging(l *log.Logger) Interceptor {
+	return func(c *Call, next func() error) error {
+		start := time.Now()
+		err := next()
+		d := time.Since(start)
+		if err != nil {
+			l.Printf("%s(%+v) failed in %v: %v", c.Name(), c.Args, d, err)
+		} else {
+			l.Printf("%s(%+v) = %+v in %v", c.Name(), c.Args, deref(c.Reply), d)
+		}
+		return err
+	}
+}
+
+// deref shows what a reply pointer points at.
+func deref(reply interface{}) interface{} {
+	if v := reflect.ValueOf(reply); v.Kind() == reflect.Ptr && !v.IsNil() {
+		return v.Elem().Interface()
+	}
+	return reply
+}
+
+// Timing reports how long every call took, and how it ended, to observe.
+func Timing(observe func(c *Call, d time.Duration, err error)) Interceptor {
+	return func(c *Call, next func() error) error {
+		start := time.Now()
+		err := next()
+		observe(c, time.Since(start), err)
+		return err
+	}
+}
+
+// Auth calls check before every call and refuses the call with its error,
+// if any.
+func Auth(check func(c *Call) error) Interceptor {
+	return func(c *Call, next func() error) error {
+		if err := check(c); err != nil {
+			return err
+		}
+		return next()
+	}
+}
+
+// LocalOrCertified is a check for Auth that lets in callers on this
+// machine, and others only with a client certificate that verified
+// against the server's client CAs. Calls from an unknown address are
+// refused.
+//
+// On HTTP, as with jsonrpc2, RemoteAddr is that of whoever opened the
+// connection: behind a reverse proxy on the same machine every caller
+// looks local and is let in, so require certificates there instead.
+func LocalOrCertified(c *Call) error {
+	if c.TLS != nil && len(c.TLS.VerifiedChains) > 0 {
+		return nil
+	}
+	var ip net.IP
+	switch addr := c.RemoteAddr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	}
+	if ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return ErrUnauthorized
+}
+
+// Recover turns a panic further down the chain into ErrInternal, logging
+// the panic and its stack to l, so one bad call does not take the server
+// down. It belongs first in the chain.
+func Recover(l *log.Logger) Interceptor {
+	return func(c *Call, next func() error) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				l.Printf("%s panicked: %v\n%s", c.Name(), p, debug.Stack())
+				err = ErrInternal
+			}
+		}()
+		return next()
+	}
+}
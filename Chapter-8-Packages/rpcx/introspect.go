@@ -0,0 +1,107 @@
+package rpcx
+
+import (
+	"reflect"
+	"sort"
+)
+
+// IntrospectName is the service RegisterIntrospection adds.
+const IntrospectName = "Introspect"
+
+// ServiceInfo describes a registered service.
+type ServiceInfo struct {
+	Name    string       `json:"name"`
+	Methods []MethodInfo `json:"methods"`
+}
+
+// MethodInfo describes one method and what it takes and returns.
+type MethodInfo struct {
+	Name  string `json:"name"`
+	Args  Shape  `json:"args"`
+	Reply Shape  `json:"reply"`
+}
+
+// Shape describes a type as it travels over the wire: only the exported
+// fields of structs are listed. A struct already being described further
+// up is given by Type alone.
+type Shape struct {
+	Type   string  `json:"type"`
+	Kind   string  `json:"kind"`
+	Fields []Field `json:"fields,omitempty"`
+	// Elem is the element of an array, slice or pointer, or a map's value.
+	Elem *Shape `json:"elem,omitempty"`
+	Key  *Shape `json:"key,omitempty"`
+}
+
+// Field is a struct field.
+type Field struct {
+	Name  string `json:"name"`
+	Shape Shape  `json:"shape"`
+}
+
+func shapeOf(t reflect.Type, seen map[reflect.Type]bool) Shape {
+	s := Shape{Type: t.String(), Kind: t.Kind().String()}
+	switch t.Kind() {
+	case reflect.Struct:
+		if seen[t] {
+			return s
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.IsExported() {
+				s.Fields = append(s.Fields, Field{Name: f.Name, Shape: shapeOf(f.Type, seen)})
+			}
+		}
+	case reflect.Map:
+		key := shapeOf(t.Key(), seen)
+		s.Key = &key
+		fallthrough
+	case reflect.Array, reflect.Slice, reflect.Ptr:
+		elem := shapeOf(t.Elem(), seen)
+		s.Elem = &elem
+	}
+	return s
+}
+
+// Services describes the registered services, sorted by name.
+func (s *Server) Services() []ServiceInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	infos := make([]ServiceInfo, 0, len(s.services))
+	for name, svc := range s.services {
+		info := ServiceInfo{Name: name}
+		for mname, m := range svc.methods {
+			info.Methods = append(info.Methods, MethodInfo{
+				Name:  mname,
+				Args:  shapeOf(m.argType, make(map[reflect.Type]bool)),
+				Reply: shapeOf(m.replyType.Elem(), make(map[reflect.Type]bool)),
+			})
+		}
+		sort.Slice(info.Methods, func(i, j int) bool { return info.Methods[i].Name < info.Methods[j].Name })
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Introspect is the service describing a Server to its clients.
+type Introspect struct {
+	server *Server
+}
+
+// Services replies with the service called name, or all of them, itself
+// included, when name is empty.
+func (i *Introspect) Services(name string, reply *[]ServiceInfo) error {
+	for _, info := range i.server.Services() {
+		if name == "" || info.Name == name {
+			*reply = append(*reply, info)
+		}
+	}
+	return nil
+}
+
+// RegisterIntrospection adds the Introspect service.
+func (s *Server) RegisterIntrospection() error {
+	return s.RegisterName(IntrospectName, &Introspect{server: s})
+}
@@ -0,0 +1,81 @@
+package rpcx
+
+import (
+	"encoding/json"
+	"testing"
+
+	"concurrency/leakcheck"
+)
+
+// Tree refers to itself, which the shapes must survive.
+type Tree struct {
+	Value    int
+	Children []*Tree
+	Tags     map[string]bool
+	hidden   int
+}
+
+type Forest struct{}
+
+func (*Forest) Plant(t *Tree, reply *[2]string) error {
+	return nil
+}
+
+func TestIntrospection(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s := NewServer()
+	s.Register(new(Arith))
+	s.Register(new(Forest))
+	if err := s.RegisterIntrospection(); err != nil {
+		t.Fatal(err)
+	}
+	c := serve(s, false)
+	defer c.Close()
+
+	var all []ServiceInfo
+	if err := c.Call("Introspect.Services", "", &all); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range all {
+		names = append(names, info.Name)
+	}
+	if got, _ := json.Marshal(names); string(got) != `["Arith","Forest","Introspect"]` {
+		t.Errorf(`Expected: ["Arith","Forest","Introspect"], got: %s`, got)
+	}
+
+	type testPair struct {
+		service string
+		want    string
+	}
+	tests := []testPair{
+		{"Arith", `[{"name":"Arith","methods":[` +
+			`{"name":"Add","args":{"type":"rpcx.Args","kind":"struct","fields":[` +
+			`{"name":"A","shape":{"type":"int","kind":"int"}},{"name":"B","shape":{"type":"int","kind":"int"}}]},` +
+			`"reply":{"type":"int","kind":"int"}},` +
+			`{"name":"Div","args":{"type":"*rpcx.Args","kind":"ptr","elem":{"type":"rpcx.Args","kind":"struct","fields":[` +
+			`{"name":"A","shape":{"type":"int","kind":"int"}},{"name":"B","shape":{"type":"int","kind":"int"}}]}},` +
+			`"reply":{"type":"int","kind":"int"}},` +
+			`{"name":"List","args":{"type":"int","kind":"int"},` +
+			`"reply":{"type":"[]int","kind":"slice","elem":{"type":"int","kind":"int"}}},` +
+			`{"name":"Panic","args":{"type":"rpcx.Args","kind":"struct","fields":[` +
+			`{"name":"A","shape":{"type":"int","kind":"int"}},{"name":"B","shape":{"type":"int","kind":"int"}}]},` +
+			`"reply":{"type":"int","kind":"int"}}]}]`},
+		{"Forest", `[{"name":"Forest","methods":[{"name":"Plant",` +
+			`"args":{"type":"*rpcx.Tree","kind":"ptr","elem":{"type":"rpcx.Tree","kind":"struct","fields":[` +
+			`{"name":"Value","shape":{"type":"int","kind":"int"}},` +
+			`{"name":"Children","shape":{"type":"[]*rpcx.Tree","kind":"slice","elem":{"type":"*rpcx.Tree","kind":"ptr","elem":{"type":"rpcx.Tree","kind":"struct"}}}},` +
+			`{"name":"Tags","shape":{"type":"map[string]bool","kind":"map","elem":{"type":"bool","kind":"bool"},"key":{"type":"string","kind":"string"}}}]}},` +
+			`"reply":{"type":"[2]string","kind":"array","elem":{"type":"string","kind":"string"}}}]}]`},
+		{"Nothing", `null`},
+	}
+	for _, test := range tests {
+		var infos []ServiceInfo
+		if err := c.Call("Introspect.Services", test.service, &infos); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := json.Marshal(infos); string(got) != test.want {
+			t.Errorf("%s: Expected: %s, got: %s", test.service, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package rpcx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrPoolClosed is returned by calls on a closed Pool.
+	ErrPoolClosed = errors.New("rpcx: pool closed")
+	// ErrBadReply is returned by calls whose reply is not a non-nil
+	// pointer.
+	ErrBadReply = errors.New("rpcx: reply must be a non-nil pointer")
+)
+
+// A Dialer opens a new connection for a Pool.
+type Dialer func(ctx context.Context) (*rpc.Client, error)
+
+// TCPDialer dials addr, over TLS if tlsConf is set, and speaks to it with
+// newClient: rpc.NewClient for gob or jsonrpc.NewClient for JSON.
+func TCPDialer(addr string, tlsConf *tls.Config, newClient func(io.ReadWriteCloser) *rpc.Client) Dialer {
+	return func(ctx context.Context) (*rpc.Client, error) {
+		var conn net.Conn
+		var err error
+		if tlsConf != nil {
+			d := &tls.Dialer{Config: tlsConf}
+			conn, err = d.DialContext(ctx, "tcp", addr)
+		} else {
+			var d net.Dialer
+			conn, err = d.DialContext(ctx, "tcp", addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return newClient(conn), nil
+	}
+}
+
+// PoolOptions configures a Pool. Zero values pick the defaults.
+type PoolOptions struct {
+	// Size is the number of connections calls are spread over. Each one
+	// carries any number of calls at once. Defaults to 4.
+	Size int
+	// Timeout bounds calls made with Call and calls whose context has no
+	// deadline. Defaults to 10s.
+	Timeout time.Duration
+	// Idempotent lists the methods safe to send again after a connection
+	// fails mid-call, as "Service.Method" or a whole "Service". Other
+	// methods are only retried when they were never sent.
+	Idempotent []string
+	// Retries is how many times a call is tried again. Defaults to 2;
+	// negative means never.
+	Retries int
+	// Backoff is the wait before the first retry, doubling after each one.
+	// Defaults to 100ms.
+	Backoff time.Duration
+	// Interceptors run around each call, once however often it is tried.
+	Interceptors []Interceptor
+}
+
+func (o *PoolOptions) setDefaults() {
+	if o.Size <= 0 {
+		o.Size = 4
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.Retries == 0 {
+		o.Retries = 2
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = 100 * time.Millisecond
+	}
+}
+
+// slot is one connection of the pool, dialed when first needed and again
+// after it fails.
+type slot struct {
+	mu     sync.Mutex
+	client *rpc.Client
+}
+
+// Pool is an RPC client over several connections that reconnects after
+// failures, bounds every call by a deadline and retries what it safely can.
+type Pool struct {
+	dial Dialer
+	opts PoolOptions
+	next uint32
+
+	mu     sync.Mutex
+	slots  []*slot
+	closed bool
+}
+
+// NewPool returns a pool using dial for its connections. Nothing is dialed
+// until the first call.
+func NewPool(dial Dialer, opts PoolOptions) *Pool {
+	opts.setDefaults()
+	p := &Pool{dial: dial, opts: opts, slots: make([]*slot, opts.Size)}
+	for i := range p.slots {
+		p.slots[i] = &slot{}
+	}
+	return p
+}
+
+// get returns the slot's client, dialing it if need be.
+func (p *Pool) get(ctx context.Context, s *slot) (*rpc.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+	c, err := p.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		c.Close()
+		return nil, ErrPoolClosed
+	}
+	s.client = c
+	return c, nil
+}
+
+// drop forgets c after it failed, so the next call on s dials again.
+func (s *slot) drop(c *rpc.Client) {
+	s.mu.Lock()
+	if s.client == c {
+		s.client = nil
+		c.Close()
+	}
+	s.mu.Unlock()
+}
+
+func (p *Pool) idempotent(serviceMethod string) bool {
+	service := serviceMethod
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		service = serviceMethod[:dot]
+	}
+	for _, m := range p.opts.Idempotent {
+		if m == serviceMethod || m == service {
+			return true
+		}
+	}
+	return false
+}
+
+// Call calls serviceMethod within PoolOptions.Timeout. It satisfies the
+// same interface as rpc.Client.Call, so a Pool can stand in for one.
+func (p *Pool) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return p.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+// CallContext calls serviceMethod, giving up when ctx is done. reply is
+// only written to when the call succeeds.
+func (p *Pool) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	if v := reflect.ValueOf(reply); v.Kind() != reflect.Ptr || v.IsNil() {
+		return ErrBadReply
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.opts.Timeout)
+		defer cancel()
+	}
+	call := newCall(serviceMethod, args, reply)
+	return chain(p.opts.Interceptors, call, func() error {
+		return p.retry(ctx, serviceMethod, args, reply)
+	})
+}
+
+func (p *Pool) retry(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	backoff := p.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		sent, err := p.try(ctx, serviceMethod, args, reply)
+		if err == nil || attempt >= p.opts.Retries || (sent && !p.idempotent(serviceMethod)) {
+			return err
+		}
+		// A call that was sent and failed without breaking the connection
+		// would fail the same way again.
+		if err == ErrPoolClosed || (sent && !broken(err)) || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// try makes one attempt on the next connection in turn. sent says whether
+// the request may have reached the server.
+func (p *Pool) try(ctx context.Context, serviceMethod string, args, reply interface{}) (sent bool, err error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return false, ErrPoolClosed
+	}
+	p.mu.Unlock()
+	s := p.slots[atomic.AddUint32(&p.next, 1)%uint32(len(p.slots))]
+	for redial := true; ; redial = false {
+		c, err := p.get(ctx, s)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			return false, err
+		}
+
+		// A late answer must not land in reply after we have given up, so
+		// decode into a fresh value and copy it over on success.
+		fresh := reflect.New(reflect.TypeOf(reply).Elem())
+		call := c.Go(serviceMethod, args, fresh.Interface(), make(chan *rpc.Call, 1))
+		select {
+		case <-call.Done:
+		case <-ctx.Done():
+			return true, ctx.Err()
+		}
+		switch err := call.Error; {
+		case err == nil:
+			reflect.ValueOf(reply).Elem().Set(fresh.Elem())
+			return true, nil
+		case err == rpc.ErrShutdown:
+			// The client refused the call without sending it: its
+			// connection had gone, or net/rpc gave up on it after a reply
+			// it could not decode. Dial again, once.
+			s.drop(c)
+			if !redial {
+				return false, err
+			}
+		case broken(err):
+			s.drop(c)
+			return true, err
+		default:
+			// A server error, or the codec refusing the arguments or the
+			// reply. The connection is fine.
+			return true, err
+		}
+	}
+}
+
+// broken reports whether err from a call means the connection failed.
+func broken(err error) bool {
+	var netErr net.Error
+	return err == io.EOF || err == io.ErrUnexpectedEOF || errors.Is(err, io.ErrClosedPipe) || errors.As(err, &netErr)
+}
+
+// Future is the result of an asynchronous call.
+type Future struct {
+	done chan struct{}
+	err  error
+}
+
+// Done is closed when the call has finished.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Err waits for the call and returns its error. The reply passed to Go is
+// ready once it returns nil.
+func (f *Future) Err() error {
+	<-f.done
+	return f.err
+}
+
+// Go starts a call as CallContext would make it and returns at once.
+func (p *Pool) Go(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) *Future {
+	f := &Future{done: make(chan struct{})}
+	go func() {
+		f.err = p.CallContext(ctx, serviceMethod, args, reply)
+		close(f.done)
+	}()
+	return f
+}
+
+// Close closes every connection. Calls in flight fail.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	for _, s := range p.slots {
+		s.mu.Lock()
+		if s.client != nil {
+			s.client.Close()
+			s.client = nil
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
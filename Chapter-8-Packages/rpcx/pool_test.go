@@ -0,0 +1,226 @@
+package rpcx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"concurrency/leakcheck"
+)
+
+// Slow answers when released, for deadline tests.
+type Slow struct {
+	release chan struct{}
+}
+
+func (s *Slow) Wait(n int, reply *int) error {
+	<-s.release
+	*reply = n
+	return nil
+}
+
+// startPoolServer serves Arith and Slow on a listener. Calls to methods in
+// hangUp have their connection cut before they run, once each.
+func startPoolServer(t *testing.T, hangUp ...string) (net.Listener, *Slow, *int32) {
+	var mu sync.Mutex
+	cut := make(map[string]bool)
+	for _, m := range hangUp {
+		cut[m] = true
+	}
+	var ran int32
+	s := NewServer(func(c *Call, next func() error) error {
+		mu.Lock()
+		hang := cut[c.Name()]
+		delete(cut, c.Name())
+		mu.Unlock()
+		if hang {
+			c.Conn.Close()
+			return errors.New("hung up")
+		}
+		atomic.AddInt32(&ran, 1)
+		return next()
+	})
+	slow := &Slow{release: make(chan struct{})}
+	s.Register(new(Arith))
+	s.Register(slow)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Accept(l)
+	return l, slow, &ran
+}
+
+func TestPool(t *testing.T) {
+	defer leakcheck.Check(t)()
+	l, _, _ := startPoolServer(t)
+	defer l.Close()
+	p := NewPool(TCPDialer(l.Addr().String(), nil, rpc.NewClient), PoolOptions{Size: 2})
+
+	var futures []*Future
+	replies := make([]int, 10)
+	for i := range replies {
+		futures = append(futures, p.Go(context.Background(), "Arith.Add", Args{i, i}, &replies[i]))
+	}
+	for i, f := range futures {
+		if err := f.Err(); err != nil || replies[i] != 2*i {
+			t.Errorf("Expected: %d <nil>, got: %d %v", 2*i, replies[i], err)
+		}
+	}
+	var reply int
+	if err := p.Call("Arith.Div", &Args{1, 0}, &reply); err == nil || err.Error() != "divide by zero" {
+		t.Errorf("Expected: divide by zero, got: %v", err)
+	}
+
+	p.Close()
+	if err := p.Call("Arith.Add", Args{}, &reply); err != ErrPoolClosed {
+		t.Errorf("Expected: %v, got: %v", ErrPoolClosed, err)
+	}
+}
+
+func TestPoolRetries(t *testing.T) {
+	defer leakcheck.Check(t)()
+	type testPair struct {
+		name       string
+		idempotent []string
+		err        bool
+	}
+	tests := []testPair{
+		{"idempotent method", []string{"Arith.Add"}, false},
+		{"idempotent service", []string{"Arith"}, false},
+		{"not idempotent", nil, true},
+	}
+	for _, test := range tests {
+		l, _, ran := startPoolServer(t, "Arith.Add")
+		p := NewPool(TCPDialer(l.Addr().String(), nil, rpc.NewClient), PoolOptions{
+			Size: 1, Idempotent: test.idempotent, Backoff: time.Millisecond,
+		})
+		var reply int
+		err := p.Call("Arith.Add", Args{1, 2}, &reply)
+		if (err != nil) != test.err {
+			t.Errorf("%s: Expected: error %v, got: %v", test.name, test.err, err)
+		}
+		if err == nil && (reply != 3 || atomic.LoadInt32(ran) != 1) {
+			t.Errorf("%s: Expected: 3 run once, got: %d run %d times", test.name, reply, atomic.LoadInt32(ran))
+		}
+		// Either way the pool has reconnected for the next call.
+		if err := p.Call("Arith.Add", Args{1, 2}, &reply); err != nil {
+			t.Errorf("%s: Expected: %v, got: %v", test.name, nil, err)
+		}
+		p.Close()
+		l.Close()
+	}
+}
+
+// TestPoolCodecErrors checks that arguments or replies the codec cannot
+// handle fail the call without costing the next one.
+func TestPoolCodecErrors(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s := NewServer()
+	s.Register(new(Arith))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.ServeConnCodec(conn, jsonrpc.NewServerCodec(conn))
+		}
+	}()
+	var dials int32
+	dial := TCPDialer(l.Addr().String(), nil, jsonrpc.NewClient)
+	p := NewPool(func(ctx context.Context) (*rpc.Client, error) {
+		atomic.AddInt32(&dials, 1)
+		return dial(ctx)
+	}, PoolOptions{Size: 1, Retries: -1})
+	defer p.Close()
+
+	var n int
+	if err := p.Call("Arith.Add", make(chan int), &n); err == nil {
+		t.Error("Expected: an error encoding the arguments, got: <nil>")
+	}
+	if err := p.Call("Arith.Add", Args{1, 2}, &n); err != nil || n != 3 {
+		t.Errorf("Expected: 3 <nil>, got: %d %v", n, err)
+	}
+	if d := atomic.LoadInt32(&dials); d != 1 {
+		t.Errorf("Expected: %d dial, got: %d", 1, d)
+	}
+
+	// net/rpc gives up on the connection after a reply it cannot decode;
+	// the next call goes out on a new one without failing.
+	var list string
+	if err := p.Call("Arith.List", 3, &list); err == nil || !strings.HasPrefix(err.Error(), "reading body ") {
+		t.Errorf("Expected: an error reading the body, got: %v", err)
+	}
+	if err := p.Call("Arith.Add", Args{2, 2}, &n); err != nil || n != 4 {
+		t.Errorf("Expected: 4 <nil>, got: %d %v", n, err)
+	}
+
+	for _, reply := range []interface{}{nil, n, (*int)(nil)} {
+		if err := p.Call("Arith.Add", Args{1, 2}, reply); err != ErrBadReply {
+			t.Errorf("%#v: Expected: %v, got: %v", reply, ErrBadReply, err)
+		}
+	}
+}
+
+func TestPoolDialRetry(t *testing.T) {
+	defer leakcheck.Check(t)()
+	l, _, _ := startPoolServer(t)
+	defer l.Close()
+	var dials int32
+	dial := TCPDialer(l.Addr().String(), nil, rpc.NewClient)
+	p := NewPool(func(ctx context.Context) (*rpc.Client, error) {
+		if atomic.AddInt32(&dials, 1) == 1 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return dial(ctx)
+	}, PoolOptions{Size: 1, Backoff: time.Millisecond})
+	defer p.Close()
+	// Not idempotent, but never sent the first time.
+	var reply int
+	if err := p.Call("Arith.Add", Args{1, 2}, &reply); err != nil || reply != 3 {
+		t.Errorf("Expected: 3 <nil>, got: %d %v", reply, err)
+	}
+}
+
+func TestPoolDeadline(t *testing.T) {
+	defer leakcheck.Check(t)()
+	l, slow, _ := startPoolServer(t)
+	defer l.Close()
+	p := NewPool(TCPDialer(l.Addr().String(), nil, rpc.NewClient), PoolOptions{Timeout: 50 * time.Millisecond, Idempotent: []string{"Slow"}})
+	defer p.Close()
+
+	reply := -1
+	if err := p.Call("Slow.Wait", 7, &reply); err != context.DeadlineExceeded {
+		t.Errorf("Expected: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f := p.Go(ctx, "Slow.Wait", 8, &reply)
+	cancel()
+	if err := f.Err(); err != context.Canceled {
+		t.Errorf("Expected: %v, got: %v", context.Canceled, err)
+	}
+
+	// The late answers must not be written to reply.
+	close(slow.release)
+	var ok int
+	if err := p.Call("Slow.Wait", 9, &ok); err != nil || ok != 9 {
+		t.Errorf("Expected: 9 <nil>, got: %d %v", ok, err)
+	}
+	if reply != -1 {
+		t.Errorf("Expected: %d, got: %d", -1, reply)
+	}
+}
@@ -0,0 +1,238 @@
+package rpcx
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"concurrency/leakcheck"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Arith struct{}
+
+func (*Arith) Add(args Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func (*Arith) Div(args *Args, reply *int) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+func (*Arith) Panic(args Args, reply *int) error {
+	panic("oops")
+}
+
+func (*Arith) List(n int, reply *[]int) error {
+	for i := 0; i < n; i++ {
+		*reply = append(*reply, i)
+	}
+	return nil
+}
+
+// not published: wrong signature
+func (*Arith) Helper(a int) int { return a }
+
+// recorder notes the order interceptors run in.
+type recorder struct {
+	mu  sync.Mutex
+	log []string
+}
+
+func (r *recorder) note(s string) {
+	r.mu.Lock()
+	r.log = append(r.log, s)
+	r.mu.Unlock()
+}
+
+func (r *recorder) interceptor(name string) Interceptor {
+	return func(c *Call, next func() error) error {
+		r.note(name + ">" + c.Name())
+		err := next()
+		r.note(name + "<")
+		return err
+	}
+}
+
+func (r *recorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.log, " ")
+}
+
+func serve(s *Server, jsonCodec bool) *rpc.Client {
+	server, client := net.Pipe()
+	go func() {
+		if jsonCodec {
+			s.ServeConnCodec(server, jsonrpc.NewServerCodec(server))
+		} else {
+			s.ServeConn(server)
+		}
+	}()
+	if jsonCodec {
+		return jsonrpc.NewClient(client)
+	}
+	return rpc.NewClient(client)
+}
+
+func TestServer(t *testing.T) {
+	defer leakcheck.Check(t)()
+	var logs bytes.Buffer
+	rec := &recorder{}
+	deny := Auth(func(c *Call) error {
+		if c.Conn == nil {
+			return errors.New("no connection")
+		}
+		if c.Method == "Div" && c.Args.(*Args).A == 42 {
+			return errors.New("unauthorized")
+		}
+		return nil
+	})
+	s := NewServer(Recover(log.New(&logs, "", 0)), rec.interceptor("a"), deny, rec.interceptor("b"))
+	if err := s.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(new(Arith)); err == nil {
+		t.Error("Expected: an error registering twice, got: nil")
+	}
+
+	for _, jsonCodec := range []bool{false, true} {
+		c := serve(s, jsonCodec)
+		type testPair struct {
+			method string
+			args   interface{}
+			want   string
+		}
+		tests := []testPair{
+			{"Arith.Add", Args{1, 2}, "3 <nil>"},
+			{"Arith.Div", &Args{7, 2}, "3 <nil>"},
+			{"Arith.Div", &Args{7, 0}, "0 divide by zero"},
+			{"Arith.Div", &Args{42, 1}, "0 unauthorized"},
+			{"Arith.Panic", Args{}, "0 " + ErrInternal.Error()},
+			{"Arith.Helper", 1, "0 rpc: can't find method Arith.Helper"},
+			{"Nothing.Add", Args{}, "0 rpc: can't find service Nothing.Add"},
+			{"Add", Args{}, "0 rpc: service/method request ill-formed: Add"},
+		}
+		for _, test := range tests {
+			var reply int
+			err := c.Call(test.method, test.args, &reply)
+			if got := fmt.Sprint(reply, " ", err); got != test.want {
+				t.Errorf("%s(%v), json %v: Expected: %s, got: %s", test.method, test.args, jsonCodec, test.want, got)
+			}
+		}
+		var list []int
+		if err := c.Call("Arith.List", 3, &list); err != nil || fmt.Sprint(list) != "[0 1 2]" {
+			t.Errorf("Expected: [0 1 2] <nil>, got: %v %v", list, err)
+		}
+		c.Close()
+	}
+
+	// Rejected calls never reach b; a panic unwinds through both.
+	want := "a>Arith.Div a< a>Arith.Panic b>Arith.Panic"
+	if got := rec.String(); !strings.Contains(got, want) {
+		t.Errorf("Expected: ... %s ..., got: %s", want, got)
+	}
+	if !strings.Contains(logs.String(), "Arith.Panic panicked: oops") {
+		t.Errorf("Expected: the panic logged, got: %s", logs.String())
+	}
+}
+
+func TestServeRequest(t *testing.T) {
+	s := NewServer(Auth(func(c *Call) error {
+		if c.Conn != nil {
+			return errors.New("expected no connection")
+		}
+		return nil
+	}))
+	s.Register(new(Arith))
+	server, client := net.Pipe()
+	defer client.Close()
+	done := make(chan error)
+	go func() {
+		done <- s.ServeRequest(jsonrpc.NewServerCodec(server))
+	}()
+	c := jsonrpc.NewClient(client)
+	var reply int
+	if err := c.Call("Arith.Add", Args{2, 3}, &reply); err != nil || reply != 5 {
+		t.Errorf("Expected: 5 <nil>, got: %v %v", reply, err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Expected: %v, got: %v", nil, err)
+	}
+}
+
+func TestLocalOrCertified(t *testing.T) {
+	type testPair struct {
+		name string
+		call Call
+		want error
+	}
+	tests := []testPair{
+		{"loopback", Call{RemoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}}, nil},
+		{"loopback v6", Call{RemoteAddr: &net.TCPAddr{IP: net.IPv6loopback}}, nil},
+		{"remote", Call{RemoteAddr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1)}}, ErrUnauthorized},
+		{"unknown caller", Call{}, ErrUnauthorized},
+		{"TLS without a certificate", Call{RemoteAddr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1)}, TLS: &tls.ConnectionState{}}, ErrUnauthorized},
+		{"unverified certificate", Call{RemoteAddr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1)}, TLS: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}}, ErrUnauthorized},
+		{"client certificate", Call{RemoteAddr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1)}, TLS: &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{}}, VerifiedChains: [][]*x509.Certificate{{{}}}}}, nil},
+	}
+	for _, test := range tests {
+		if err := LocalOrCertified(&test.call); err != test.want {
+			t.Errorf("%s: Expected: %v, got: %v", test.name, test.want, err)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s := NewServer()
+	s.Register(new(Arith))
+	rec := &recorder{}
+	var timed []string
+	timing := Timing(func(c *Call, d time.Duration, err error) {
+		timed = append(timed, fmt.Sprint(c.Name(), " ", err))
+	})
+	var logs bytes.Buffer
+	c := NewClient(serve(s, false), timing, rec.interceptor("a"), Logging(log.New(&logs, "", 0)))
+	defer c.Close()
+
+	var reply int
+	if err := c.Call("Arith.Add", Args{1, 2}, &reply); err != nil || reply != 3 {
+		t.Errorf("Expected: 3 <nil>, got: %v %v", reply, err)
+	}
+	err := c.Call("Arith.Div", &Args{1, 0}, &reply)
+	if _, ok := err.(rpc.ServerError); !ok {
+		t.Errorf("Expected: an rpc.ServerError, got: %#v", err)
+	}
+
+	if got, want := rec.String(), "a>Arith.Add a< a>Arith.Div a<"; got != want {
+		t.Errorf("Expected: %s, got: %s", want, got)
+	}
+	if got, want := fmt.Sprint(timed), "[Arith.Add <nil> Arith.Div divide by zero]"; got != want {
+		t.Errorf("Expected: %s, got: %s", want, got)
+	}
+	for _, want := range []string{"Arith.Add({A:1 B:2}) = 3 in ", "Arith.Div(&{A:1 B:0}) failed in "} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("Expected: a line with %q, got: %s", want, logs.String())
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Package rpcx serves net/rpc services through a chain of interceptors,
+// for logging, timing, authentication and panic recovery that applies to
+// every method without editing any of them. Services are registered and
+// called exactly as with net/rpc, and any rpc.ServerCodec can carry them;
+// rpc.Client and net/rpc/jsonrpc clients talk to it unchanged.
+package rpcx
+
+import (
+	"crypto/tls"
+	"errors"
+	"go/token"
+	"net"
+	"net/rpc"
+	"reflect"
+	"sync"
+)
+
+var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+
+type method struct {
+	fn        reflect.Value
+	argType   reflect.Type
+	replyType reflect.Type
+}
+
+type service struct {
+	rcvr    reflect.Value
+	methods map[string]*method
+}
+
+// Server is an RPC server running every call through its interceptors, the
+// first outermost.
+type Server struct {
+	interceptors []Interceptor
+
+	mu       sync.RWMutex
+	services map[string]*service
+}
+
+// NewServer returns a server with no services.
+func NewServer(interceptors ...Interceptor) *Server {
+	return &Server{interceptors: interceptors, services: make(map[string]*service)}
+}
+
+// Register publishes the methods of rcvr under its type's name, following
+// the same rules as rpc.Register.
+func (s *Server) Register(rcvr interface{}) error {
+	return s.RegisterName(reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name(), rcvr)
+}
+
+// RegisterName is like Register but uses name for the service.
+func (s *Server) RegisterName(name string, rcvr interface{}) error {
+	if !token.IsExported(name) {
+		return errors.New("rpcx: service name " + name + " is not exported")
+	}
+	svc := &service{rcvr: reflect.ValueOf(rcvr), methods: suitableMethods(reflect.TypeOf(rcvr))}
+	if len(svc.methods) == 0 {
+		return errors.New("rpcx: type " + name + " has no exported methods of suitable type")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, dup := s.services[name]; dup {
+		return errors.New("rpcx: service already defined: " + name)
+	}
+	s.services[name] = svc
+	return nil
+}
+
+// suitableMethods picks out the methods net/rpc would publish:
+//
+//	func (t *T) Method(args A, reply *R) error
+//
+// with A and R exported or builtin.
+func suitableMethods(t reflect.Type) map[string]*method {
+	methods := make(map[string]*method)
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		mt := m.Type
+		if !m.IsExported() || mt.NumIn() != 3 || mt.NumOut() != 1 || mt.Out(0) != typeOfError {
+			continue
+		}
+		arg, reply := mt.In(1), mt.In(2)
+		if !exportedOrBuiltin(arg) || reply.Kind() != reflect.Ptr || !exportedOrBuiltin(reply) {
+			continue
+		}
+		methods[m.Name] = &method{fn: m.Func, argType: arg, replyType: reply}
+	}
+	return methods
+}
+
+func exportedOrBuiltin(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return token.IsExported(t.Name()) || t.PkgPath() == ""
+}
+
+// lookup finds a method, with net/rpc's error text so clients and
+// jsonrpc2 can tell an unknown method from a failed one.
+func (s *Server) lookup(serviceMethod string) (*service, *method, error) {
+	c := newCall(serviceMethod, nil, nil)
+	if c.Service == "" {
+		return nil, nil, errors.New("rpc: service/method request ill-formed: " + serviceMethod)
+	}
+	s.mu.RLock()
+	svc := s.services[c.Service]
+	s.mu.RUnlock()
+	if svc == nil {
+		return nil, nil, errors.New("rpc: can't find service " + serviceMethod)
+	}
+	m := svc.methods[c.Method]
+	if m == nil {
+		return nil, nil, errors.New("rpc: can't find method " + serviceMethod)
+	}
+	return svc, m, nil
+}
+
+// request is a decoded request ready to run.
+type request struct {
+	header *rpc.Request
+	svc    *service
+	m      *method
+	argv   reflect.Value
+	replyv reflect.Value
+}
+
+// readRequest reads the next request. A nil request in the error case means
+// the codec is unusable; otherwise the error belongs to this request only.
+func (s *Server) readRequest(codec rpc.ServerCodec) (*request, error) {
+	var header rpc.Request
+	if err := codec.ReadRequestHeader(&header); err != nil {
+		return nil, err
+	}
+	r := &request{header: &header}
+	var err error
+	r.svc, r.m, err = s.lookup(header.ServiceMethod)
+	if err != nil {
+		codec.ReadRequestBody(nil)
+		return r, err
+	}
+
+	if r.m.argType.Kind() == reflect.Ptr {
+		r.argv = reflect.New(r.m.argType.Elem())
+	} else {
+		r.argv = reflect.New(r.m.argType)
+	}
+	if err := codec.ReadRequestBody(r.argv.Interface()); err != nil {
+		return r, err
+	}
+	if r.m.argType.Kind() != reflect.Ptr {
+		r.argv = r.argv.Elem()
+	}
+
+	r.replyv = reflect.New(r.m.replyType.Elem())
+	switch r.m.replyType.Elem().Kind() {
+	case reflect.Map:
+		r.replyv.Elem().Set(reflect.MakeMap(r.m.replyType.Elem()))
+	case reflect.Slice:
+		r.replyv.Elem().Set(reflect.MakeSlice(r.m.replyType.Elem(), 0, 0))
+	}
+	return r, nil
+}
+
+// call runs r through the interceptors and writes the response.
+func (s *Server) call(codec rpc.ServerCodec, sending *sync.Mutex, r *request, conn net.Conn) {
+	c := newCall(r.header.ServiceMethod, r.argv.Interface(), r.replyv.Interface())
+	c.Conn = conn
+	if conn != nil {
+		c.RemoteAddr = conn.RemoteAddr()
+		if tc, ok := conn.(*tls.Conn); ok {
+			state := tc.ConnectionState()
+			c.TLS = &state
+		}
+	} else if pc, ok := codec.(PeerCodec); ok {
+		c.RemoteAddr, c.TLS = pc.RemoteAddr(), pc.TLS()
+	}
+	err := chain(s.interceptors, c, func() error {
+		out := r.m.fn.Call([]reflect.Value{r.svc.rcvr, r.argv, r.replyv})
+		if err, _ := out[0].Interface().(error); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		respond(codec, sending, r.header, nil, err.Error())
+		return
+	}
+	respond(codec, sending, r.header, r.replyv.Interface(), "")
+}
+
+func respond(codec rpc.ServerCodec, sending *sync.Mutex, header *rpc.Request, reply interface{}, errmsg string) {
+	resp := &rpc.Response{ServiceMethod: header.ServiceMethod, Seq: header.Seq, Error: errmsg}
+	if errmsg != "" {
+		reply = struct{}{}
+	}
+	sending.Lock()
+	codec.WriteResponse(resp, reply)
+	sending.Unlock()
+}
+
+// ServeConn serves the gob codec on conn, as rpc.ServeConn does, until
+// the client hangs up.
+func (s *Server) ServeConn(conn net.Conn) {
+	s.serve(newGobServerCodec(conn), conn)
+}
+
+// ServeConnCodec serves codec, which reads and writes conn, so that
+// interceptors can see the connection.
+func (s *Server) ServeConnCodec(conn net.Conn, codec rpc.ServerCodec) {
+	s.serve(codec, conn)
+}
+
+// ServeCodec serves codec until it fails. Calls have no Conn.
+func (s *Server) ServeCodec(codec rpc.ServerCodec) {
+	s.serve(codec, nil)
+}
+
+// Accept serves every connection l accepts, until l is closed.
+func (s *Server) Accept(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+func (s *Server) serve(codec rpc.ServerCodec, conn net.Conn) {
+	sending := new(sync.Mutex)
+	var wg sync.WaitGroup
+	for {
+		r, err := s.readRequest(codec)
+		if err != nil {
+			if r == nil {
+				break
+			}
+			respond(codec, sending, r.header, nil, err.Error())
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.call(codec, sending, r, conn)
+		}()
+	}
+	// Let calls in flight answer before the codec goes.
+	wg.Wait()
+	codec.Close()
+}
+
+// A PeerCodec is a ServerCodec that knows who is calling, for codecs
+// served without a connection, such as one per HTTP request. Calls read
+// from it get its RemoteAddr and TLS.
+type PeerCodec interface {
+	rpc.ServerCodec
+	RemoteAddr() net.Addr
+	TLS() *tls.ConnectionState
+}
+
+// ServeRequest serves a single request from codec, synchronously, as
+// rpc.Server.ServeRequest does. It does not close codec.
+func (s *Server) ServeRequest(codec rpc.ServerCodec) error {
+	sending := new(sync.Mutex)
+	r, err := s.readRequest(codec)
+	if err != nil {
+		if r != nil {
+			respond(codec, sending, r.header, nil, err.Error())
+		}
+		return err
+	}
+	s.call(codec, sending, r, nil)
+	return nil
+}
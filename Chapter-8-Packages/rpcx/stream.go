@@ -0,0 +1,349 @@
+package rpcx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Streams are built from ordinary calls, so they work over every codec
+// and through interceptors. A stream service registered as "Name" has:
+//
+//	Name.Open(args, *StreamID)            both kinds
+//	Name.Next(StreamID, *Batch[T])        server-streaming: take what is ready
+//	Name.Send(SendArgs[T], *int)          client-streaming: hand over items
+//	Name.CloseSend(StreamID, *R)          client-streaming: get the result
+//	Name.Cancel(StreamID, *bool)          both kinds
+//
+// Flow control comes from the window: a handler producing faster than the
+// client calls Next blocks in send, and a client sending faster than the
+// handler reads blocks in Send.
+
+var (
+	// ErrStreamNotFound is returned for a stream that has finished, been
+	// cancelled or timed out.
+	ErrStreamNotFound = errors.New("rpcx: no such stream")
+	// ErrStreamClosed is returned by Send after the handler has finished
+	// without reading everything.
+	ErrStreamClosed = errors.New("rpcx: stream closed by the server")
+)
+
+// StreamID names an open stream.
+type StreamID uint64
+
+// Batch is the reply to Next. Done is set, with no items, once the stream
+// has ended.
+type Batch[T any] struct {
+	Items []T
+	Done  bool
+}
+
+// SendArgs are the arguments of Send.
+type SendArgs[T any] struct {
+	ID    StreamID
+	Items []T
+}
+
+// StreamOptions configures a stream service. Zero values pick the
+// defaults.
+type StreamOptions struct {
+	// Window is how many items may wait between the two ends. Defaults to
+	// 64.
+	Window int
+	// MaxBatch caps the items in one Next reply, and in one Send from
+	// the stream clients here. Defaults to 32.
+	MaxBatch int
+	// Poll is the longest Next waits for an item before replying with
+	// none. Defaults to one second.
+	Poll time.Duration
+	// IdleTimeout cancels a stream nobody has called on for that long,
+	// or whose Send has waited that long for the handler to take an item.
+	// Defaults to 30s.
+	IdleTimeout time.Duration
+}
+
+func (o *StreamOptions) setDefaults() {
+	if o.Window <= 0 {
+		o.Window = 64
+	}
+	if o.MaxBatch <= 0 {
+		o.MaxBatch = 32
+	}
+	if o.Poll <= 0 {
+		o.Poll = time.Second
+	}
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = 30 * time.Second
+	}
+}
+
+// Registrar is an RPC server to register with, such as *rpc.Server or
+// *Server.
+type Registrar interface {
+	RegisterName(name string, rcvr interface{}) error
+}
+
+// stream is the state both kinds share.
+type stream struct {
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the handler returns
+	err    error         // the handler's, once done is closed
+	idle   *time.Timer
+}
+
+// streams is the table of a service's open streams.
+type streams[S any] struct {
+	opts StreamOptions
+
+	mu   sync.Mutex
+	next StreamID
+	open map[StreamID]S
+}
+
+func (t *streams[S]) add(s S, base *stream) StreamID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.open == nil {
+		t.open = make(map[StreamID]S)
+	}
+	t.next++
+	id := t.next
+	t.open[id] = s
+	base.idle = time.AfterFunc(t.opts.IdleTimeout, func() {
+		t.remove(id)
+		base.cancel()
+	})
+	return id
+}
+
+// get finds a stream and restarts its idle timeout.
+func (t *streams[S]) get(id StreamID, base func(S) *stream) (S, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.open[id]
+	if !ok {
+		return s, ErrStreamNotFound
+	}
+	base(s).idle.Reset(t.opts.IdleTimeout)
+	return s, nil
+}
+
+func (t *streams[S]) remove(id StreamID) (S, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.open[id]
+	delete(t.open, id)
+	return s, ok
+}
+
+// serverStreams is the service behind ServeServerStream.
+type serverStreams[A, T any] struct {
+	streams[*serverStream[T]]
+	handler func(ctx context.Context, args A, send func(T) error) error
+}
+
+type serverStream[T any] struct {
+	stream
+	items chan T
+}
+
+func (s *serverStream[T]) base() *stream { return &s.stream }
+
+// ServeServerStream registers a server-streaming service under name. Each
+// Open runs handler, whose items reach the client through send; send
+// fails once the client cancels or goes away. A, T and the handler's
+// error travel like any call's arguments, reply and error.
+func ServeServerStream[A, T any](s Registrar, name string, opts StreamOptions, handler func(ctx context.Context, args A, send func(T) error) error) error {
+	opts.setDefaults()
+	svc := &serverStreams[A, T]{handler: handler}
+	svc.opts = opts
+	return s.RegisterName(name, svc)
+}
+
+func (svc *serverStreams[A, T]) Open(args A, id *StreamID) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	st := &serverStream[T]{
+		stream: stream{cancel: cancel, done: make(chan struct{})},
+		items:  make(chan T, svc.opts.Window),
+	}
+	*id = svc.add(st, &st.stream)
+	go func() {
+		defer close(st.done)
+		st.err = svc.handler(ctx, args, func(item T) error {
+			select {
+			case st.items <- item:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return nil
+}
+
+func (svc *serverStreams[A, T]) Next(id StreamID, batch *Batch[T]) error {
+	st, err := svc.get(id, (*serverStream[T]).base)
+	if err != nil {
+		return err
+	}
+	poll := time.NewTimer(svc.opts.Poll)
+	defer poll.Stop()
+	select {
+	case item := <-st.items:
+		batch.Items = append(batch.Items, item)
+	case <-st.done:
+	case <-poll.C:
+		return nil
+	}
+	for len(batch.Items) < svc.opts.MaxBatch {
+		select {
+		case item := <-st.items:
+			batch.Items = append(batch.Items, item)
+			continue
+		default:
+		}
+		break
+	}
+	if len(batch.Items) > 0 {
+		return nil
+	}
+	// Finished and drained.
+	svc.remove(id)
+	st.idle.Stop()
+	st.cancel()
+	if st.err != nil {
+		return st.err
+	}
+	batch.Done = true
+	return nil
+}
+
+func (svc *serverStreams[A, T]) Cancel(id StreamID, ok *bool) error {
+	st, found := svc.remove(id)
+	if found {
+		st.idle.Stop()
+		st.cancel()
+	}
+	*ok = found
+	return nil
+}
+
+// clientStreams is the service behind ServeClientStream.
+type clientStreams[A, T, R any] struct {
+	streams[*clientStream[T, R]]
+	handler func(ctx context.Context, args A, recv func() (T, error)) (R, error)
+}
+
+type clientStream[T, R any] struct {
+	stream
+	ctx    context.Context
+	items  chan T
+	result R
+
+	sendMu sync.Mutex // serialises Send and the close in CloseSend
+	closed bool
+}
+
+func (s *clientStream[T, R]) base() *stream { return &s.stream }
+
+// ServeClientStream registers a client-streaming service under name. Each
+// Open runs handler, which reads the client's items with recv until
+// io.EOF, and whose result the client gets from CloseSend.
+func ServeClientStream[A, T, R any](s Registrar, name string, opts StreamOptions, handler func(ctx context.Context, args A, recv func() (T, error)) (R, error)) error {
+	opts.setDefaults()
+	svc := &clientStreams[A, T, R]{handler: handler}
+	svc.opts = opts
+	return s.RegisterName(name, svc)
+}
+
+func (svc *clientStreams[A, T, R]) Open(args A, id *StreamID) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	st := &clientStream[T, R]{
+		stream: stream{cancel: cancel, done: make(chan struct{})},
+		ctx:    ctx,
+		items:  make(chan T, svc.opts.Window),
+	}
+	*id = svc.add(st, &st.stream)
+	go func() {
+		defer close(st.done)
+		st.result, st.err = svc.handler(ctx, args, func() (T, error) {
+			select {
+			case item, ok := <-st.items:
+				if !ok {
+					return item, io.EOF
+				}
+				return item, nil
+			case <-ctx.Done():
+				var zero T
+				return zero, ctx.Err()
+			}
+		})
+	}()
+	return nil
+}
+
+func (svc *clientStreams[A, T, R]) Send(args SendArgs[T], accepted *int) error {
+	st, err := svc.get(args.ID, (*clientStream[T, R]).base)
+	if err != nil {
+		return err
+	}
+	// The idle timeout keeps running while Send waits, so a handler that
+	// stops reading cannot hold the stream once the client has gone. Each
+	// item taken starts it again.
+	st.sendMu.Lock()
+	defer st.sendMu.Unlock()
+	if st.closed {
+		return ErrStreamClosed
+	}
+	for _, item := range args.Items {
+		select {
+		case st.items <- item:
+			*accepted++
+			st.idle.Reset(svc.opts.IdleTimeout)
+		case <-st.done:
+			return ErrStreamClosed
+		case <-st.ctx.Done():
+			return ErrStreamNotFound
+		}
+	}
+	return nil
+}
+
+func (svc *clientStreams[A, T, R]) CloseSend(id StreamID, result *R) error {
+	st, err := svc.get(id, (*clientStream[T, R]).base)
+	if err != nil {
+		return err
+	}
+	st.idle.Stop()
+	st.sendMu.Lock()
+	if !st.closed {
+		st.closed = true
+		close(st.items)
+	}
+	st.sendMu.Unlock()
+	select {
+	case <-st.done:
+	case <-st.ctx.Done():
+		return ErrStreamNotFound
+	}
+	svc.remove(id)
+	st.idle.Stop()
+	st.cancel()
+	if st.err != nil {
+		return st.err
+	}
+	*result = st.result
+	return nil
+}
+
+func (svc *clientStreams[A, T, R]) Cancel(id StreamID, ok *bool) error {
+	st, found := svc.remove(id)
+	if found {
+		st.idle.Stop()
+		st.cancel()
+	}
+	*ok = found
+	return nil
+}
@@ -0,0 +1,202 @@
+package rpcx
+
+import (
+	"context"
+	"io"
+	"net/rpc"
+)
+
+// Caller makes calls, such as *rpc.Client, *Client or *Pool. When it also
+// has CallContext, as *Pool does, stream calls stop waiting once their
+// context is done.
+type Caller interface {
+	Call(serviceMethod string, args interface{}, reply interface{}) error
+}
+
+type contextCaller interface {
+	CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error
+}
+
+func callContext(ctx context.Context, c Caller, serviceMethod string, args, reply interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if cc, ok := c.(contextCaller); ok {
+		return cc.CallContext(ctx, serviceMethod, args, reply)
+	}
+	return c.Call(serviceMethod, args, reply)
+}
+
+// streamError maps the stream errors back from the server's text.
+func streamError(err error) error {
+	if se, ok := err.(rpc.ServerError); ok {
+		switch string(se) {
+		case ErrStreamNotFound.Error():
+			return ErrStreamNotFound
+		case ErrStreamClosed.Error():
+			return ErrStreamClosed
+		}
+	}
+	return err
+}
+
+// ServerStream receives the items of a server-streaming call.
+type ServerStream[T any] struct {
+	ctx     context.Context
+	c       Caller
+	service string
+	id      StreamID
+	items   []T
+	err     error // io.EOF once finished
+}
+
+// OpenServerStream starts a call to the server-streaming service. Once ctx
+// is done Recv fails and the server is told to stop.
+func OpenServerStream[A, T any](ctx context.Context, c Caller, service string, args A) (*ServerStream[T], error) {
+	s := &ServerStream[T]{ctx: ctx, c: c, service: service}
+	if err := callContext(ctx, c, service+".Open", args, &s.id); err != nil {
+		return nil, streamError(err)
+	}
+	return s, nil
+}
+
+// Recv returns the next item, or io.EOF after the last.
+func (s *ServerStream[T]) Recv() (T, error) {
+	var zero T
+	for len(s.items) == 0 && s.err == nil {
+		var batch Batch[T]
+		err := callContext(s.ctx, s.c, s.service+".Next", s.id, &batch)
+		switch {
+		case err != nil:
+			s.fail(streamError(err))
+		case batch.Done:
+			s.err = io.EOF
+		default:
+			s.items = batch.Items
+		}
+	}
+	if len(s.items) == 0 {
+		return zero, s.err
+	}
+	item := s.items[0]
+	s.items[0] = zero
+	s.items = s.items[1:]
+	return item, nil
+}
+
+// fail ends the stream with err, cancelling it on the server unless the
+// server has ended it already.
+func (s *ServerStream[T]) fail(err error) {
+	s.err = err
+	if _, ok := err.(rpc.ServerError); !ok && err != ErrStreamNotFound {
+		s.cancel()
+	}
+}
+
+func (s *ServerStream[T]) cancel() {
+	var ok bool
+	s.c.Call(s.service+".Cancel", s.id, &ok)
+}
+
+// Close stops a stream that has not finished. Items not yet received are
+// dropped.
+func (s *ServerStream[T]) Close() error {
+	if s.err == nil {
+		s.err = ErrStreamNotFound
+		s.items = nil
+		s.cancel()
+	}
+	return nil
+}
+
+// ClientStream sends the items of a client-streaming call, in batches.
+type ClientStream[T, R any] struct {
+	ctx     context.Context
+	c       Caller
+	service string
+	id      StreamID
+	batch   int
+	pending []T
+	err     error
+}
+
+// OpenClientStream starts a call to the client-streaming service. Items
+// are sent batch at a time.
+func OpenClientStream[A, T, R any](ctx context.Context, c Caller, service string, args A, batch int) (*ClientStream[T, R], error) {
+	if batch <= 0 {
+		batch = 32
+	}
+	s := &ClientStream[T, R]{ctx: ctx, c: c, service: service, batch: batch}
+	if err := callContext(ctx, c, service+".Open", args, &s.id); err != nil {
+		return nil, streamError(err)
+	}
+	return s, nil
+}
+
+// Send queues item, sending the batch once it is full. It blocks while the
+// server is behind, and fails with ErrStreamClosed if the server stopped
+// reading.
+func (s *ClientStream[T, R]) Send(item T) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.pending = append(s.pending, item)
+	if len(s.pending) < s.batch {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *ClientStream[T, R]) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	var accepted int
+	err := callContext(s.ctx, s.c, s.service+".Send", SendArgs[T]{ID: s.id, Items: s.pending}, &accepted)
+	s.pending = s.pending[:0]
+	if err != nil {
+		s.fail(streamError(err))
+	}
+	return s.err
+}
+
+func (s *ClientStream[T, R]) fail(err error) {
+	s.err = err
+	if _, ok := err.(rpc.ServerError); !ok && err != ErrStreamNotFound && err != ErrStreamClosed {
+		s.cancel()
+	}
+}
+
+func (s *ClientStream[T, R]) cancel() {
+	var ok bool
+	s.c.Call(s.service+".Cancel", s.id, &ok)
+}
+
+// CloseAndRecv sends what is queued, tells the server there is no more and
+// returns its result. After ErrStreamClosed from Send, it returns the
+// server's own result or error.
+func (s *ClientStream[T, R]) CloseAndRecv() (R, error) {
+	var result R
+	if s.err == nil {
+		s.flush()
+	}
+	if s.err != nil && s.err != ErrStreamClosed {
+		return result, s.err
+	}
+	err := callContext(s.ctx, s.c, s.service+".CloseSend", s.id, &result)
+	if err != nil {
+		s.fail(streamError(err))
+		return result, s.err
+	}
+	s.err = ErrStreamNotFound
+	return result, nil
+}
+
+// Close stops a stream that has not been closed with CloseAndRecv.
+func (s *ClientStream[T, R]) Close() error {
+	if s.err == nil {
+		s.err = ErrStreamNotFound
+		s.cancel()
+	}
+	return nil
+}
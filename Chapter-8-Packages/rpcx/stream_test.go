@@ -0,0 +1,265 @@
+package rpcx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"concurrency/leakcheck"
+)
+
+// count sends 0 to n-1, then fails with fail if it is set.
+func count(fail string) func(ctx context.Context, n int, send func(int) error) error {
+	return func(ctx context.Context, n int, send func(int) error) error {
+		for i := 0; i < n; i++ {
+			if err := send(i); err != nil {
+				return err
+			}
+		}
+		if fail != "" {
+			return errors.New(fail)
+		}
+		return nil
+	}
+}
+
+// sum adds up what it receives, stopping early after stop items if stop is
+// positive.
+func sum(ctx context.Context, stop int, recv func() (int, error)) (int, error) {
+	total := 0
+	for i := 0; stop <= 0 || i < stop; i++ {
+		n, err := recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func recvAll(s *ServerStream[int]) ([]int, error) {
+	var got []int
+	for {
+		n, err := s.Recv()
+		if err != nil {
+			return got, err
+		}
+		got = append(got, n)
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s := NewServer()
+	opts := StreamOptions{Window: 8, MaxBatch: 5}
+	if err := ServeServerStream(s, "Count", opts, count("")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ServeServerStream(s, "Fail", opts, count("boom")); err != nil {
+		t.Fatal(err)
+	}
+	for _, jsonCodec := range []bool{false, true} {
+		c := serve(s, jsonCodec)
+		ctx := context.Background()
+
+		st, err := OpenServerStream[int, int](ctx, c, "Count", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := recvAll(st)
+		if len(got) != 100 || got[99] != 99 || err != io.EOF {
+			t.Errorf("json %v: Expected: 100 items then EOF, got: %d %v", jsonCodec, len(got), err)
+		}
+
+		st, _ = OpenServerStream[int, int](ctx, c, "Fail", 3)
+		got, err = recvAll(st)
+		if len(got) != 3 || err == nil || err.Error() != "boom" {
+			t.Errorf("json %v: Expected: 3 items then boom, got: %v %v", jsonCodec, got, err)
+		}
+
+		var ok bool
+		if err := c.Call("Count.Next", StreamID(999), &Batch[int]{}); streamError(err) != ErrStreamNotFound {
+			t.Errorf("Expected: %v, got: %v", ErrStreamNotFound, err)
+		}
+		c.Call("Count.Cancel", StreamID(999), &ok)
+		if ok {
+			t.Errorf("Expected: nothing cancelled, got: %v", ok)
+		}
+		c.Close()
+	}
+}
+
+func TestServerStreamFlowControl(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s := NewServer()
+	sent := make(chan int, 100)
+	ServeServerStream(s, "Count", StreamOptions{Window: 4, MaxBatch: 2}, func(ctx context.Context, n int, send func(int) error) error {
+		for i := 0; i < n; i++ {
+			if err := send(i); err != nil {
+				return err
+			}
+			sent <- i
+		}
+		return nil
+	})
+	c := serve(s, false)
+	defer c.Close()
+	st, err := OpenServerStream[int, int](context.Background(), c, "Count", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The handler fills the window and waits there.
+	for i := 0; i < 4; i++ {
+		<-sent
+	}
+	select {
+	case i := <-sent:
+		t.Errorf("Expected: the handler blocked, got: item %d sent", i)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// One Next takes a batch of two, making room for two more.
+	st.Recv()
+	for i := 0; i < 2; i++ {
+		<-sent
+	}
+	st.Close()
+}
+
+func TestServerStreamCancel(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s := NewServer()
+	stopped := make(chan error, 1)
+	forever := func(ctx context.Context, _ int, send func(int) error) error {
+		for i := 0; ; i++ {
+			if err := send(i); err != nil {
+				stopped <- err
+				return err
+			}
+		}
+	}
+	ServeServerStream(s, "Forever", StreamOptions{Window: 2}, forever)
+	ServeServerStream(s, "Idle", StreamOptions{Window: 2, IdleTimeout: 20 * time.Millisecond}, forever)
+	c := serve(s, false)
+	defer c.Close()
+
+	st, _ := OpenServerStream[int, int](context.Background(), c, "Forever", 0)
+	if n, err := st.Recv(); n != 0 || err != nil {
+		t.Errorf("Expected: 0 <nil>, got: %d %v", n, err)
+	}
+	st.Close()
+	if err := <-stopped; err != context.Canceled {
+		t.Errorf("Expected: %v, got: %v", context.Canceled, err)
+	}
+	if _, err := st.Recv(); err != ErrStreamNotFound {
+		t.Errorf("Expected: %v, got: %v", ErrStreamNotFound, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	st, _ = OpenServerStream[int, int](ctx, c, "Forever", 0)
+	cancel()
+	if _, err := st.Recv(); err != context.Canceled {
+		t.Errorf("Expected: %v, got: %v", context.Canceled, err)
+	}
+	<-stopped
+
+	// Nobody calls Next, so the server gives up on its own.
+	st, _ = OpenServerStream[int, int](context.Background(), c, "Idle", 0)
+	<-stopped
+	if _, err := st.Recv(); err != ErrStreamNotFound {
+		t.Errorf("Expected: %v, got: %v", ErrStreamNotFound, err)
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s := NewServer()
+	if err := ServeClientStream(s, "Sum", StreamOptions{Window: 1}, sum); err != nil {
+		t.Fatal(err)
+	}
+	for _, jsonCodec := range []bool{false, true} {
+		c := serve(s, jsonCodec)
+		ctx := context.Background()
+
+		type testPair struct {
+			stop, batch, send int
+			sendErr           error
+			want              int
+		}
+		tests := []testPair{
+			{0, 3, 10, nil, 45},
+			{0, 100, 10, nil, 45},
+			{0, 1, 0, nil, 0},
+			// The server stops reading after two.
+			{2, 1, 10, ErrStreamClosed, 1},
+		}
+		for _, test := range tests {
+			st, err := OpenClientStream[int, int, int](ctx, c, "Sum", test.stop, test.batch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sendErr error
+			for i := 0; i < test.send && sendErr == nil; i++ {
+				sendErr = st.Send(i)
+			}
+			got, err := st.CloseAndRecv()
+			if sendErr != test.sendErr || got != test.want || err != nil {
+				t.Errorf("json %v, %+v: Expected: %v %d <nil>, got: %v %d %v", jsonCodec, test, test.sendErr, test.want, sendErr, got, err)
+			}
+		}
+
+		st, _ := OpenClientStream[int, int, int](ctx, c, "Sum", 0, 1)
+		st.Send(1)
+		st.Close()
+		if _, err := st.CloseAndRecv(); err != ErrStreamNotFound {
+			t.Errorf("Expected: %v, got: %v", ErrStreamNotFound, err)
+		}
+		var total int
+		if err := c.Call("Sum.CloseSend", StreamID(999), &total); streamError(err) != ErrStreamNotFound {
+			t.Errorf("Expected: %v, got: %v", ErrStreamNotFound, err)
+		}
+		c.Close()
+	}
+}
+
+// TestClientStreamAbandoned leaves a Send waiting on a handler that has
+// stopped reading and goes away; the idle timeout still reclaims the
+// stream.
+func TestClientStreamAbandoned(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s := NewServer()
+	stopped := make(chan error, 1)
+	stuck := func(ctx context.Context, _ int, recv func() (int, error)) (int, error) {
+		recv()
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return 0, ctx.Err()
+	}
+	ServeClientStream(s, "Stuck", StreamOptions{Window: 1, IdleTimeout: 50 * time.Millisecond}, stuck)
+	c := serve(s, false)
+
+	var id StreamID
+	if err := c.Call("Stuck.Open", 0, &id); err != nil {
+		t.Fatal(err)
+	}
+	var accepted int
+	c.Go("Stuck.Send", SendArgs[int]{ID: id, Items: []int{1, 2, 3, 4}}, &accepted, nil)
+	c.Close()
+	select {
+	case err := <-stopped:
+		if err != context.Canceled {
+			t.Errorf("Expected: %v, got: %v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected: the stream cancelled, got: still open")
+	}
+}
+
+// A plain *rpc.Client is a Caller too.
+var _ Caller = (*rpc.Client)(nil)
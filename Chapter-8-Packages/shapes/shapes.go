@@ -0,0 +1,62 @@
+// Package shapes is the Shape interface of Chapter-7 with its circles,
+// rectangles and multi-shapes, exported so other packages can use them.
+package shapes
+
+import "math"
+
+// Shape is anything with an area and a perimeter.
+type Shape interface {
+	Area() float64
+	Perimeter() float64
+}
+
+type Circle struct {
+	R float64
+}
+
+func (c *Circle) Area() float64 {
+	return math.Pi * c.R * c.R
+}
+
+func (c *Circle) Perimeter() float64 {
+	return 2 * math.Pi * c.R
+}
+
+type Rectangle struct {
+	L, W float64
+}
+
+func (r *Rectangle) Area() float64 {
+	return r.L * r.W
+}
+
+func (r *Rectangle) Perimeter() float64 {
+	return 2 * (r.L + r.W)
+}
+
+// MultiShape is a Shape made of others: its area and perimeter are theirs
+// added up.
+type MultiShape struct {
+	Shapes []Shape
+}
+
+func (m *MultiShape) Area() float64 {
+	return TotalArea(m.Shapes...)
+}
+
+func (m *MultiShape) Perimeter() float64 {
+	var total float64
+	for _, s := range m.Shapes {
+		total += s.Perimeter()
+	}
+	return total
+}
+
+// TotalArea adds up the areas of shapes.
+func TotalArea(shapes ...Shape) float64 {
+	var total float64
+	for _, s := range shapes {
+		total += s.Area()
+	}
+	return total
+}
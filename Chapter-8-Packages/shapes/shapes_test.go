@@ -0,0 +1,28 @@
+package shapes
+
+import (
+	"math"
+	"testing"
+)
+
+func TestShapes(t *testing.T) {
+	type testPair struct {
+		shape     Shape
+		area      float64
+		perimeter float64
+	}
+	tests := []testPair{
+		{&Circle{1}, math.Pi, 2 * math.Pi},
+		{&Rectangle{3, 4}, 12, 14},
+		{&MultiShape{[]Shape{&Circle{1}, &Rectangle{3, 4}}}, math.Pi + 12, 2*math.Pi + 14},
+		{&MultiShape{}, 0, 0},
+	}
+	for _, test := range tests {
+		if a, p := test.shape.Area(), test.shape.Perimeter(); math.Abs(a-test.area) > 1e-9 || math.Abs(p-test.perimeter) > 1e-9 {
+			t.Errorf("%+v: Expected: %v %v, got: %v %v", test.shape, test.area, test.perimeter, a, p)
+		}
+	}
+	if got := TotalArea(&Rectangle{10, 10}, &Circle{10}); math.Abs(got-(100+100*math.Pi)) > 1e-9 {
+		t.Errorf("Expected: %v, got: %v", 100+100*math.Pi, got)
+	}
+}
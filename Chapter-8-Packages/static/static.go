@@ -0,0 +1,322 @@
+// Package static serves files from any fs.FS, a directory on disk or an
+// embed.FS, with what browsers and caches need: strong ETags from the
+// contents, Cache-Control by file type, precompressed .br and .gz files
+// when the client takes them, and range requests. Dotfiles are never
+// served.
+package static
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheControl is used for extensions Options.CacheControl does
+// not list. Pages are revalidated every time; assets are kept a day.
+var DefaultCacheControl = map[string]string{
+	"":       "public, max-age=3600",
+	".html":  "no-cache",
+	".css":   "public, max-age=86400",
+	".js":    "public, max-age=86400",
+	".svg":   "public, max-age=86400",
+	".png":   "public, max-age=86400",
+	".jpg":   "public, max-age=86400",
+	".gif":   "public, max-age=86400",
+	".ico":   "public, max-age=86400",
+	".woff":  "public, max-age=604800",
+	".woff2": "public, max-age=604800",
+}
+
+// Options configures a Handler. Zero values pick the defaults.
+type Options struct {
+	// Listing lists directories without an index.html; otherwise they
+	// are not found.
+	Listing bool
+	// CacheControl maps extensions, with the dot, to a Cache-Control
+	// value; "" is for the rest. Missing entries fall back to
+	// DefaultCacheControl.
+	CacheControl map[string]string
+}
+
+// encodings are the precompressed siblings looked for, best first.
+var encodings = []struct{ name, ext string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+type handler struct {
+	fsys fs.FS
+	opts Options
+
+	mu    sync.Mutex
+	etags map[string]string // by name, size and modification time
+}
+
+// Handler serves fsys. Mount it with http.StripPrefix to serve it under a
+// path.
+func Handler(fsys fs.FS, opts Options) http.Handler {
+	return &handler{fsys: fsys, opts: opts, etags: make(map[string]string)}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	urlPath := r.URL.Path
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+	name := strings.TrimPrefix(path.Clean(urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+	if hidden(name) {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !info.IsDir() {
+		if strings.HasSuffix(urlPath, "/") {
+			redirect(w, r, "../"+path.Base(name))
+			return
+		}
+		h.serveFile(w, r, name, info)
+		return
+	}
+	if !strings.HasSuffix(urlPath, "/") {
+		redirect(w, r, path.Base(urlPath)+"/")
+		return
+	}
+	index := path.Join(name, "index.html")
+	if info, err := fs.Stat(h.fsys, index); err == nil && info.Mode().IsRegular() {
+		h.serveFile(w, r, index, info)
+		return
+	}
+	if !h.opts.Listing {
+		http.NotFound(w, r)
+		return
+	}
+	h.list(w, r, name)
+}
+
+// hidden reports whether any element of name starts with a dot.
+func hidden(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") && elem != "." {
+			return true
+		}
+	}
+	return false
+}
+
+// redirect sends the client to to, relative to the request, keeping the
+// query.
+func redirect(w http.ResponseWriter, r *http.Request, to string) {
+	if q := r.URL.RawQuery; q != "" {
+		to += "?" + q
+	}
+	w.Header().Set("Location", to)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
+
+// accepts reports whether Accept-Encoding takes encoding.
+func accepts(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		return !ok || strings.Trim(q, "0.") != ""
+	}
+	return false
+}
+
+func (h *handler) cacheControl(ext string) string {
+	for _, m := range []map[string]string{h.opts.CacheControl, DefaultCacheControl} {
+		if cc, ok := m[ext]; ok {
+			return cc
+		}
+	}
+	if cc, ok := h.opts.CacheControl[""]; ok {
+		return cc
+	}
+	return DefaultCacheControl[""]
+}
+
+func (h *handler) serveFile(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo) {
+	ext := strings.ToLower(path.Ext(name))
+	header := w.Header()
+	ctype := mime.TypeByExtension(ext)
+	header.Set("Cache-Control", h.cacheControl(ext))
+
+	// The compressed file is a representation of its own, with its own
+	// ETag; ranges apply to its bytes.
+	send, sendInfo := name, info
+	varies := false
+	for _, enc := range encodings {
+		cinfo, err := fs.Stat(h.fsys, name+enc.ext)
+		if err != nil || !cinfo.Mode().IsRegular() {
+			continue
+		}
+		varies = true
+		if send == name && accepts(r.Header.Get("Accept-Encoding"), enc.name) {
+			send, sendInfo = name+enc.ext, cinfo
+			header.Set("Content-Encoding", enc.name)
+		}
+	}
+	if varies && !strings.Contains(strings.Join(header.Values("Vary"), ","), "Accept-Encoding") {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	// Left unset, ServeContent sniffs the type, but not through
+	// compression.
+	if ctype == "" && send != name {
+		ctype = "application/octet-stream"
+	}
+	if ctype != "" {
+		header.Set("Content-Type", ctype)
+	}
+
+	content, err := h.open(send)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+	etag, err := h.etag(send, sendInfo, content)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	header.Set("ETag", etag)
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+type readSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// open returns the file ready for ServeContent, which needs to seek.
+func (h *handler) open(name string) (readSeekCloser, error) {
+	f, err := h.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if rs, ok := f.(readSeekCloser); ok {
+		return rs, nil
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+type nopCloser struct{ *bytes.Reader }
+
+func (nopCloser) Close() error { return nil }
+
+// etag hashes the contents once for each version of a file, leaving
+// content at the start.
+func (h *handler) etag(name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	key := fmt.Sprintf("%s\x00%d\x00%d", name, info.Size(), info.ModTime().UnixNano())
+	h.mu.Lock()
+	etag, ok := h.etags[key]
+	h.mu.Unlock()
+	if ok {
+		return etag, nil
+	}
+	sum := sha256.New()
+	if _, err := io.Copy(sum, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag = `"` + hex.EncodeToString(sum.Sum(nil)[:16]) + `"`
+	h.mu.Lock()
+	h.etags[key] = etag
+	h.mu.Unlock()
+	return etag, nil
+}
+
+var listing = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Index of {{.Dir}}</title>
+</head>
+<body>
+	<h1>Index of {{.Dir}}</h1>
+	<ul>
+	{{- range .Entries}}
+		<li><a href="{{.Href}}">{{.Name}}</a> {{.Size}} {{.Modified}}</li>
+	{{- end}}
+	</ul>
+</body>
+</html>
+`))
+
+type entry struct {
+	Name, Href, Size, Modified string
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request, dir string) {
+	entries, err := fs.ReadDir(h.fsys, dir)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	var data struct {
+		Dir     string
+		Entries []entry
+	}
+	data.Dir = "/" + strings.TrimPrefix(dir, ".")
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		ent := entry{Name: e.Name(), Href: (&url.URL{Path: e.Name()}).String()}
+		if e.IsDir() {
+			ent.Name += "/"
+			ent.Href += "/"
+		} else {
+			ent.Size = fmt.Sprint(info.Size())
+		}
+		if !info.ModTime().IsZero() {
+			ent.Modified = info.ModTime().UTC().Format(time.RFC3339)
+		}
+		data.Entries = append(data.Entries, ent)
+	}
+	var buf bytes.Buffer
+	if err := listing.Execute(&buf, data); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	buf.WriteTo(w)
+}
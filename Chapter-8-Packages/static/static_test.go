@@ -0,0 +1,163 @@
+package static
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var modified = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func files() fstest.MapFS {
+	return fstest.MapFS{
+		"app.js":            {Data: []byte("console.log('hi')"), ModTime: modified},
+		"app.js.gz":         {Data: []byte("gzipped js"), ModTime: modified},
+		"app.js.br":         {Data: []byte("brotli js"), ModTime: modified},
+		"style.css":         {Data: []byte("body { color: red }"), ModTime: modified},
+		"style.css.gz":      {Data: []byte("gzipped css"), ModTime: modified},
+		"notes":             {Data: []byte("plain words"), ModTime: modified},
+		"digits.txt":        {Data: []byte("0123456789"), ModTime: modified},
+		".env":              {Data: []byte("SECRET=1")},
+		".git/config":       {Data: []byte("[core]")},
+		"docs/index.html":   {Data: []byte("<h1>Docs</h1>")},
+		"docs/.hidden.html": {Data: []byte("secret")},
+		"img/a <b>.png":     {Data: []byte("png"), ModTime: modified},
+		"img/.keep":         {Data: []byte("")},
+		"img/sub/c.gif":     {Data: []byte("gif")},
+	}
+}
+
+func get(h http.Handler, target string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	for i := 0; i < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestServe(t *testing.T) {
+	h := Handler(files(), Options{CacheControl: map[string]string{".txt": "no-store"}})
+
+	type testPair struct {
+		target string
+		header []string
+		code   int
+		body   string
+		want   map[string]string
+	}
+	tests := []testPair{
+		{"/style.css", nil, 200, "body { color: red }", map[string]string{
+			"Content-Type":     "text/css; charset=utf-8",
+			"Cache-Control":    "public, max-age=86400",
+			"Vary":             "Accept-Encoding",
+			"Content-Encoding": "",
+			"Last-Modified":    "Mon, 01 Jan 2024 00:00:00 GMT",
+		}},
+		{"/style.css", []string{"Accept-Encoding", "gzip, br"}, 200, "gzipped css", map[string]string{
+			"Content-Type":     "text/css; charset=utf-8",
+			"Content-Encoding": "gzip",
+		}},
+		{"/app.js", []string{"Accept-Encoding", "gzip, br"}, 200, "brotli js", map[string]string{"Content-Encoding": "br"}},
+		{"/app.js", []string{"Accept-Encoding", "gzip, br;q=0"}, 200, "gzipped js", map[string]string{"Content-Encoding": "gzip"}},
+		{"/app.js", []string{"Accept-Encoding", "identity"}, 200, "console.log('hi')", map[string]string{"Content-Encoding": ""}},
+		// Sniffed, and the rest of the types' policy.
+		{"/notes", nil, 200, "plain words", map[string]string{
+			"Content-Type":  "text/plain; charset=utf-8",
+			"Cache-Control": "public, max-age=3600",
+			"Vary":          "",
+		}},
+		{"/digits.txt", []string{"Range", "bytes=2-4"}, 206, "234", map[string]string{
+			"Content-Range": "bytes 2-4/10",
+			"Cache-Control": "no-store",
+		}},
+		{"/docs/", nil, 200, "<h1>Docs</h1>", map[string]string{"Cache-Control": "no-cache"}},
+		{"/docs", nil, 301, "", map[string]string{"Location": "docs/"}},
+		{"/style.css/", nil, 301, "", map[string]string{"Location": "../style.css"}},
+		{"/img/", nil, 404, "404 page not found\n", nil},
+		{"/missing.css", nil, 404, "404 page not found\n", nil},
+	}
+	for _, test := range tests {
+		w := get(h, test.target, test.header...)
+		if w.Code != test.code || (test.body != "" && w.Body.String() != test.body) {
+			t.Errorf("%s %v: Expected: %d %q, got: %d %q", test.target, test.header, test.code, test.body, w.Code, w.Body)
+		}
+		for k, v := range test.want {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf("%s %v: %s: Expected: %q, got: %q", test.target, test.header, k, v, got)
+			}
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/style.css", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != 405 || w.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("Expected: 405 allowing GET, HEAD, got: %d %q", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestDotfiles(t *testing.T) {
+	h := Handler(files(), Options{Listing: true})
+	for _, target := range []string{"/.env", "/.git/config", "/.git/", "/docs/.hidden.html", "/img/.keep", "/img/../.env", "/%2eenv"} {
+		if w := get(h, target); w.Code != 404 {
+			t.Errorf("%s: Expected: 404, got: %d %q", target, w.Code, w.Body)
+		}
+	}
+}
+
+func TestETag(t *testing.T) {
+	fsys := files()
+	h := Handler(fsys, Options{})
+	first := get(h, "/style.css").Header().Get("ETag")
+	if len(first) != 34 || first[0] != '"' || strings.HasPrefix(first, "W/") {
+		t.Errorf("Expected: a strong ETag of the contents, got: %s", first)
+	}
+	if again := get(h, "/style.css").Header().Get("ETag"); again != first {
+		t.Errorf("Expected: %s, got: %s", first, again)
+	}
+	if gz := get(h, "/style.css", "Accept-Encoding", "gzip").Header().Get("ETag"); gz == first {
+		t.Errorf("Expected: the compressed file to have its own ETag, got: %s", gz)
+	}
+	w := get(h, "/style.css", "If-None-Match", first)
+	if w.Code != 304 || w.Body.Len() != 0 {
+		t.Errorf("Expected: 304, got: %d %q", w.Code, w.Body)
+	}
+	// An outdated validator gets the whole file instead of a range.
+	w = get(h, "/digits.txt", "Range", "bytes=0-1", "If-Range", `"stale"`)
+	if w.Code != 200 || w.Body.String() != "0123456789" {
+		t.Errorf("Expected: 200 with the whole file, got: %d %q", w.Code, w.Body)
+	}
+
+	fsys["style.css"] = &fstest.MapFile{Data: []byte("body { color: blue }"), ModTime: modified.Add(time.Second)}
+	if changed := get(h, "/style.css").Header().Get("ETag"); changed == first {
+		t.Errorf("Expected: a new ETag for new contents, got: %s", changed)
+	}
+	if w := get(h, "/style.css", "If-None-Match", first); w.Code != 200 {
+		t.Errorf("Expected: 200, got: %d", w.Code)
+	}
+}
+
+func TestListing(t *testing.T) {
+	w := get(Handler(files(), Options{Listing: true}), "/img/")
+	body := w.Body.String()
+	if w.Code != 200 || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("Expected: 200 html, got: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"<title>Index of /img</title>",
+		`<a href="a%20%3Cb%3E.png">a &lt;b&gt;.png</a> 3 2024-01-01T00:00:00Z`,
+		`<a href="sub/">sub/</a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected: %s in the listing, got: %s", want, body)
+		}
+	}
+	if strings.Contains(body, ".keep") {
+		t.Errorf("Expected: no dotfiles listed, got: %s", body)
+	}
+}
@@ -0,0 +1,232 @@
+// Package stubgen writes typed client stubs for RPC services from the Go
+// source of their receiver type, so callers write stub.Negate(99) instead
+// of client.Call("Server.Negate", int64(99), &reply). gen_stubs.go is its
+// command line.
+//
+// Every method registered by net/rpc gets a stub method; the others are
+// skipped. When the stub goes in another package, types from the source
+// package are qualified with its name and the import path must be given.
+package stubgen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/template"
+)
+
+type stubMethod struct {
+	Name  string
+	Doc   string
+	Args  string
+	Reply string
+}
+
+type stubFile struct {
+	Source  string
+	Package string
+	Service string
+	Imports []string
+	Methods []stubMethod
+}
+
+var stubTemplate = template.Must(template.New("stub").Parse(`// Code generated by gen_stubs.go from {{.Source}}; DO NOT EDIT.
+
+package {{.Package}}
+{{if .Imports}}
+import (
+{{range .Imports}}	{{.}}
+{{end}})
+{{end}}
+// {{.Service}}Caller makes RPC calls; *rpc.Client, *rpcx.Client and
+// *rpcx.Pool all do.
+type {{.Service}}Caller interface {
+	Call(serviceMethod string, args interface{}, reply interface{}) error
+}
+
+// {{.Service}}Stub calls the {{.Service}} service's methods.
+type {{.Service}}Stub struct {
+	c {{.Service}}Caller
+}
+
+// New{{.Service}}Stub returns a stub making its calls with c.
+func New{{.Service}}Stub(c {{.Service}}Caller) *{{.Service}}Stub {
+	return &{{.Service}}Stub{c: c}
+}
+{{range .Methods}}
+{{if .Doc}}{{.Doc}}{{else}}// {{.Name}} calls {{$.Service}}.{{.Name}}.
+{{end}}func (s *{{$.Service}}Stub) {{.Name}}(args {{.Args}}) ({{.Reply}}, error) {
+	var reply {{.Reply}}
+	err := s.c.Call("{{$.Service}}.{{.Name}}", args, &reply)
+	return reply, err
+}
+{{end}}`))
+
+// qualifier rewrites type expressions for the stub's package and notes the
+// imports they need.
+type qualifier struct {
+	srcPkg  string // qualifies local types, or "" to leave them be
+	imports map[string]bool
+	known   map[string]string // package name -> import spec in the source
+}
+
+func (q *qualifier) expr(e ast.Expr) (ast.Expr, error) {
+	switch e := e.(type) {
+	case *ast.Ident:
+		if q.srcPkg == "" || types.Universe.Lookup(e.Name) != nil {
+			return e, nil
+		}
+		if !e.IsExported() {
+			return nil, fmt.Errorf("type %s is not exported", e.Name)
+		}
+		q.imports[q.known[q.srcPkg]] = true
+		return &ast.SelectorExpr{X: ast.NewIdent(q.srcPkg), Sel: e}, nil
+	case *ast.SelectorExpr:
+		pkg, ok := e.X.(*ast.Ident)
+		if !ok || q.known[pkg.Name] == "" {
+			return nil, fmt.Errorf("cannot find the import for %s", types.ExprString(e))
+		}
+		q.imports[q.known[pkg.Name]] = true
+		return e, nil
+	case *ast.StarExpr:
+		x, err := q.expr(e.X)
+		return &ast.StarExpr{X: x}, err
+	case *ast.ArrayType:
+		elt, err := q.expr(e.Elt)
+		return &ast.ArrayType{Len: e.Len, Elt: elt}, err
+	case *ast.MapType:
+		key, err := q.expr(e.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := q.expr(e.Value)
+		return &ast.MapType{Key: key, Value: value}, err
+	}
+	return e, nil
+}
+
+// importSpecs maps the package names a file uses to its import lines.
+func importSpecs(f *ast.File) map[string]string {
+	known := make(map[string]string)
+	for _, imp := range f.Imports {
+		p, _ := strconv.Unquote(imp.Path.Value)
+		name := path.Base(p)
+		spec := imp.Path.Value
+		if imp.Name != nil {
+			name = imp.Name.Name
+			spec = name + " " + spec
+		}
+		known[name] = spec
+	}
+	return known
+}
+
+// rpcMethod returns the argument and reply types of fn if net/rpc would
+// publish it: func (T) Name(args A, reply *R) error.
+func rpcMethod(fn *ast.FuncDecl) (args, reply ast.Expr, ok bool) {
+	if !fn.Name.IsExported() {
+		return nil, nil, false
+	}
+	var params []ast.Expr
+	for _, field := range fn.Type.Params.List {
+		for range max(len(field.Names), 1) {
+			params = append(params, field.Type)
+		}
+	}
+	results := fn.Type.Results
+	if len(params) != 2 || results == nil || len(results.List) != 1 || len(results.List[0].Names) > 1 {
+		return nil, nil, false
+	}
+	if id, isIdent := results.List[0].Type.(*ast.Ident); !isIdent || id.Name != "error" {
+		return nil, nil, false
+	}
+	star, isStar := params[1].(*ast.StarExpr)
+	if !isStar {
+		return nil, nil, false
+	}
+	return params[0], star.X, true
+}
+
+func receiverName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) != 1 {
+		return ""
+	}
+	t := fn.Recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if id, ok := t.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// Generate returns the stub source for the methods of typeName in file,
+// calling them as service. pkg is the stub's package, the source's when
+// empty; importPath is the source package's path, needed when pkg differs
+// and the methods use its types.
+func Generate(file, typeName, service, pkg, importPath string) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if pkg == "" {
+		pkg = f.Name.Name
+	}
+	q := &qualifier{imports: make(map[string]bool), known: importSpecs(f)}
+	if pkg != f.Name.Name {
+		q.srcPkg = f.Name.Name
+		q.known[q.srcPkg] = strconv.Quote(importPath)
+	}
+
+	out := stubFile{Source: filepath.Base(file), Package: pkg, Service: service}
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || receiverName(fn) != typeName {
+			continue
+		}
+		args, reply, ok := rpcMethod(fn)
+		if !ok {
+			continue
+		}
+		if args, err = q.expr(args); err == nil {
+			reply, err = q.expr(reply)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", typeName, fn.Name.Name, err)
+		}
+		m := stubMethod{Name: fn.Name.Name, Args: types.ExprString(args), Reply: types.ExprString(reply)}
+		if fn.Doc != nil {
+			for _, c := range fn.Doc.List {
+				m.Doc += c.Text + "\n"
+			}
+		}
+		out.Methods = append(out.Methods, m)
+	}
+	if len(out.Methods) == 0 {
+		return nil, fmt.Errorf("%s has no RPC methods in %s", typeName, file)
+	}
+	if q.srcPkg != "" && q.imports[q.known[q.srcPkg]] && importPath == "" {
+		return nil, errors.New("-import is needed for types from package " + q.srcPkg)
+	}
+	for spec := range q.imports {
+		out.Imports = append(out.Imports, spec)
+	}
+	sort.Strings(out.Imports)
+
+	var buf bytes.Buffer
+	if err := stubTemplate.Execute(&buf, out); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,63 @@
+package stubgen
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+func TestGenerate(t *testing.T) {
+	type testPair struct {
+		golden     string
+		service    string
+		pkg        string
+		importPath string
+	}
+	tests := []testPair{
+		{"same_package.golden", "Shop", "", ""},
+		{"other_package.golden", "Store", "stubs", "example.com/shop"},
+	}
+	for _, test := range tests {
+		got, err := Generate(filepath.Join("testdata", "shop.go"), "Shop", test.service, test.pkg, test.importPath)
+		if err != nil {
+			t.Fatalf("%s: %v", test.golden, err)
+		}
+		golden := filepath.Join("testdata", test.golden)
+		if *update {
+			if err := os.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("%s: Expected:\n%s\ngot:\n%s", test.golden, want, got)
+		}
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	type testPair struct {
+		typeName   string
+		pkg        string
+		importPath string
+		want       string
+	}
+	tests := []testPair{
+		{"Shop", "stubs", "", "-import is needed for types from package shop"},
+		{"Receipt", "", "", "Receipt has no RPC methods in testdata/shop.go"},
+		{"Order", "stubs", "example.com/shop", ""},
+	}
+	for _, test := range tests {
+		_, err := Generate(filepath.Join("testdata", "shop.go"), test.typeName, test.typeName, test.pkg, test.importPath)
+		if test.want == "" && err != nil || test.want != "" && (err == nil || err.Error() != test.want) {
+			t.Errorf("%s: Expected: %q, got: %v", test.typeName, test.want, err)
+		}
+	}
+}
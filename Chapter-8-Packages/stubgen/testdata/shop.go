@@ -0,0 +1,55 @@
+package shop
+
+import (
+	"time"
+
+	units "example.com/measure/units"
+)
+
+type Shop struct{}
+
+type Order struct {
+	Items []string
+	Wait  time.Duration
+}
+
+type Receipt struct {
+	Total units.Money
+}
+
+// Place takes an order and says what it cost.
+//
+// The order is kept until it is paid.
+func (s *Shop) Place(order Order, receipt *Receipt) error {
+	return nil
+}
+
+func (s *Shop) Prices(items []string, prices *map[string]units.Money) error {
+	return nil
+}
+
+func (s Shop) Find(id int64, order **Order) error {
+	return nil
+}
+
+func (s *Shop) Delay(d time.Duration, until *time.Time) error {
+	return nil
+}
+
+// Not RPC methods.
+
+func (s *Shop) Open() bool {
+	return true
+}
+
+func (s *Shop) Total(a, b int) int {
+	return a + b
+}
+
+func (s *Shop) secret(args int, reply *int) error {
+	return nil
+}
+
+func (o *Order) Add(item string, n *int) error {
+	return nil
+}
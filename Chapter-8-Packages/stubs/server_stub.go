@@ -0,0 +1,26 @@
+// Code generated by gen_stubs.go from rpc_server.go; DO NOT EDIT.
+
+package stubs
+
+// ServerCaller makes RPC calls; *rpc.Client, *rpcx.Client and
+// *rpcx.Pool all do.
+type ServerCaller interface {
+	Call(serviceMethod string, args interface{}, reply interface{}) error
+}
+
+// ServerStub calls the Server service's methods.
+type ServerStub struct {
+	c ServerCaller
+}
+
+// NewServerStub returns a stub making its calls with c.
+func NewServerStub(c ServerCaller) *ServerStub {
+	return &ServerStub{c: c}
+}
+
+// Negate calls Server.Negate.
+func (s *ServerStub) Negate(args int64) (int64, error) {
+	var reply int64
+	err := s.c.Call("Server.Negate", args, &reply)
+	return reply, err
+}
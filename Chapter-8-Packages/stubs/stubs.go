@@ -0,0 +1,5 @@
+// Package stubs holds typed clients generated by gen_stubs.go for the
+// services in this chapter's demos.
+package stubs
+
+//go:generate go run ../gen_stubs.go -file ../rpc_server.go -type Server -package stubs -o server_stub.go
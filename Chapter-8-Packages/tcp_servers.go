@@ -1,57 +1,135 @@
 package main
 
 import (
-	"encoding/gob"
+	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"net"
+	"packages/protocol"
+	"packages/tlsconfig"
+	"packages/udp"
+	"sync"
+	"time"
 )
 
-func server() {
-	listener, err := net.Listen("tcp", ":8080")
+// server runs srv on l. main sets its limits, so one slow or idle client
+// cannot tie up a goroutine forever.
+func server(srv *protocol.Server, l net.Listener) {
+	err := srv.Serve(l)
 	if err != nil {
 		fmt.Println(err)
-		return
-	}
-
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			fmt.Println(err)
-			continue
-		}
-		go handleServerConnection(conn)
 	}
 }
 
-func handleServerConnection(conn net.Conn) {
-	var msg string
-	err := gob.NewDecoder(conn).Decode(&msg)
-	if err != nil {
-		fmt.Println(err)
-	} else {
-		fmt.Println("Received:", msg)
+// handleServerConnection is called for every message on every connection.
+// Connections stay open, so a client can send as many messages as it likes.
+func handleServerConnection(conn *protocol.Conn, msg *protocol.Message) (interface{}, error) {
+	fmt.Println("Received:", msg.Kind, msg.Type, msg.Payload)
+	if msg.Type == "echo" {
+		return msg.Payload, nil
 	}
-	conn.Close()
+	return nil, nil
 }
 
-func client() {
-	conn, err := net.Dial("tcp", ":8080")
+func client(addr, codecName string, tlsConf *tls.Config) {
+	codec := protocol.CodecByName(codecName)
+	if codec == nil {
+		fmt.Println("unknown codec:", codecName)
+		return
+	}
+	opts := protocol.Options{Codecs: []protocol.Codec{codec}, TLS: tlsConf}
+	conn, err := protocol.Dial(addr, nil, opts)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	defer conn.Close()
+	fmt.Println("Using codec", conn.Codec().Name())
+
 	msg := "hello world"
 	fmt.Println("Sending", msg)
-	err = gob.NewEncoder(conn).Encode(msg)
+	err = conn.Notify("say", msg)
 	if err != nil {
 		fmt.Println(err)
 	}
-	conn.Close()
+
+	// Several requests in flight on the same connection at once; the
+	// replies are matched up by ID.
+	var wg sync.WaitGroup
+	for i := 1; i <= 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reply, err := conn.Call(context.Background(), "echo", fmt.Sprint(msg, " #", i))
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Println("Reply:", reply)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// lookup finds a server by name on the local network, or returns fallback.
+func lookup(name, fallback string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	s, err := udp.Lookup(ctx, name, udp.DiscoveryOptions{})
+	if err != nil {
+		fmt.Println("Discovery failed:", err)
+		return fallback
+	}
+	fmt.Println("Discovered", name, "at", s.Addr)
+	return s.Addr
 }
 
 func main() {
-	go server()
-	go client()
+	codec := flag.String("codec", "gob", "Codec for the client: gob, binary, json or jsonl")
+	certs := flag.String("tls", "", "Directory from gen_certs.go; enables TLS")
+	mtls := flag.Bool("mtls", false, "Require client certificates (with -tls)")
+	maxConns := flag.Int("max-conns", 100, "Connections served at once; more are turned away")
+	discover := flag.Bool("discover", false, "Find the server by multicast instead of dialing localhost")
+	idle := flag.Duration("idle", 5*time.Minute, "Close connections idle for this long")
+	flag.Parse()
+
+	serverTLS, clientTLS, err := tlsconfig.LoadDev(*certs, *mtls)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	srv := &protocol.Server{
+		Handler: protocol.HandlerFunc(handleServerConnection),
+		Options: protocol.Options{
+			TLS:            serverTLS,
+			IdleTimeout:    *idle,
+			ReadTimeout:    5 * time.Second,
+			WriteTimeout:   5 * time.Second,
+			MaxMessageSize: 1 << 20,
+		},
+		MaxConns: *maxConns,
+	}
+	// Listen before starting the client so it has something to dial.
+	l, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	go server(srv, l)
+
+	// Answer "tcp" lookups from clients on the local network.
+	if a, err := udp.Announce(udp.Service{Name: "tcp", Addr: ":8080"}, udp.DiscoveryOptions{}); err != nil {
+		fmt.Println("Not announcing:", err)
+	} else {
+		defer a.Close()
+	}
+	addr := "localhost:8080"
+	if *discover {
+		addr = lookup("tcp", addr)
+	}
+	go client(addr, *codec, clientTLS)
 	var input string
 	fmt.Scanln(&input)
+	fmt.Printf("Server stats: %+v\n", srv.Stats())
 }
@@ -0,0 +1,164 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CA is a certificate authority for development and tests. Its keys are
+// generated in memory; nothing about it is suitable for production.
+type CA struct {
+	Cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// NewCA creates a self-signed CA valid for ten years.
+func NewCA(name string) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template(name, 10*365*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Cert: cert, key: key, certPEM: encodeCert(der), keyPEM: keyPEM}, nil
+}
+
+// CertPEM returns the CA certificate, for the other side to trust.
+func (ca *CA) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// Issue signs a certificate for name valid for a year and returns it and
+// its private key as PEM. hosts are the DNS names and IP addresses a server
+// certificate is valid for; a client certificate has none.
+func (ca *CA) Issue(name string, hosts []string, client bool) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl, err := template(name, 365*24*time.Hour)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	if client {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err = encodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeCert(der), keyPEM, nil
+}
+
+// GenerateDev writes a new CA and a server and client certificate signed by
+// it into dir:
+//
+//	ca.pem ca-key.pem server.pem server-key.pem client.pem client-key.pem
+//
+// The server certificate is valid for hosts.
+func GenerateDev(dir string, hosts []string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	ca, err := NewCA("dev CA")
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{
+		"ca.pem":     ca.certPEM,
+		"ca-key.pem": ca.keyPEM,
+	}
+	for _, side := range []string{"server", "client"} {
+		var h []string
+		if side == "server" {
+			h = hosts
+		}
+		cert, key, err := ca.Issue("dev "+side, h, side == "client")
+		if err != nil {
+			return err
+		}
+		files[side+".pem"] = cert
+		files[side+"-key.pem"] = key
+	}
+	for name, bs := range files {
+		perm := os.FileMode(0644)
+		if strings.HasSuffix(name, "-key.pem") {
+			perm = 0600
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), bs, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func template(name string, validFor time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"Go examples"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validFor),
+	}, nil
+}
+
+func encodeCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,141 @@
+// Package tlsconfig builds the *tls.Config used by the TCP, RPC and HTTP
+// servers and their clients from PEM files, and generates a development
+// CA to sign them.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Config names the PEM files for one side of a connection. The zero value
+// means plaintext.
+type Config struct {
+	// CertFile and KeyFile are this side's certificate and private key.
+	// A server needs them; a client only needs them for mutual TLS.
+	CertFile string
+	KeyFile  string
+	// CAFile is the certificate authority trusted to sign the other side.
+	// For a client it verifies the server; for a server it verifies client
+	// certificates. A client without one uses the system roots.
+	CAFile string
+	// ClientAuth makes a server require a client certificate signed by
+	// CAFile (mutual TLS).
+	ClientAuth bool
+	// ServerName overrides the host name a client checks the server
+	// certificate against.
+	ServerName string
+}
+
+// Dev returns the server and client Configs for the files GenerateDev
+// wrote to dir. With clientAuth the server requires, and the client
+// presents, a client certificate.
+func Dev(dir string, clientAuth bool) (server, client *Config) {
+	server = &Config{
+		CertFile:   filepath.Join(dir, "server.pem"),
+		KeyFile:    filepath.Join(dir, "server-key.pem"),
+		CAFile:     filepath.Join(dir, "ca.pem"),
+		ClientAuth: clientAuth,
+	}
+	client = &Config{CAFile: filepath.Join(dir, "ca.pem")}
+	if clientAuth {
+		client.CertFile = filepath.Join(dir, "client.pem")
+		client.KeyFile = filepath.Join(dir, "client-key.pem")
+	}
+	return server, client
+}
+
+// LoadDev is Dev followed by Server and Client. An empty dir means
+// plaintext: both configurations are nil.
+func LoadDev(dir string, clientAuth bool) (server, client *tls.Config, err error) {
+	if dir == "" {
+		return nil, nil, nil
+	}
+	s, c := Dev(dir, clientAuth)
+	if server, err = s.Server(); err != nil {
+		return nil, nil, err
+	}
+	if client, err = c.Client(); err != nil {
+		return nil, nil, err
+	}
+	return server, client, nil
+}
+
+// Enabled reports whether any TLS setting was given.
+func (c *Config) Enabled() bool {
+	return c != nil && (c.CertFile != "" || c.CAFile != "")
+}
+
+// Server returns the configuration for a listener. It returns nil, nil
+// when TLS is not enabled.
+func (c *Config) Server() (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("tlsconfig: a server needs a certificate and a key")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.ClientAuth {
+		if c.CAFile == "" {
+			return nil, errors.New("tlsconfig: client authentication needs a CA")
+		}
+		pool, err := loadPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// Client returns the configuration for dialing a server. It returns nil,
+// nil when TLS is not enabled.
+func (c *Config) Client() (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	conf := &tls.Config{
+		ServerName: c.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.CAFile != "" {
+		pool, err := loadPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+func loadPool(file string) (*x509.CertPool, error) {
+	bs, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bs) {
+		return nil, fmt.Errorf("tlsconfig: no certificates in %s", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,113 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"concurrency/leakcheck"
+)
+
+// roundTrip serves one echo over TLS with the server config and dials it
+// with the client config.
+func roundTrip(t *testing.T, server, client *Config) error {
+	serverConf, err := server.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConf, err := client.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	c, err := tls.Dial("tcp", l.Addr().String(), clientConf)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("ping")); err != nil {
+		return err
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return err
+	}
+	if string(buf) != "ping" {
+		t.Errorf("Expected: ping, got: %q", buf)
+	}
+	return nil
+}
+
+func TestDev(t *testing.T) {
+	defer leakcheck.Check(t)()
+	dir := t.TempDir()
+	if err := GenerateDev(dir, []string{"localhost", "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	other := t.TempDir()
+	if err := GenerateDev(other, []string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	server, client := Dev(dir, false)
+	mserver, mclient := Dev(dir, true)
+	_, otherClient := Dev(other, true)
+	type testPair struct {
+		name   string
+		server *Config
+		client *Config
+		ok     bool
+	}
+	tests := []testPair{
+		{"tls", server, client, true},
+		{"mtls", mserver, mclient, true},
+		{"mtls without client cert", mserver, client, false},
+		{"client cert from another CA", mserver, &Config{CAFile: client.CAFile, CertFile: otherClient.CertFile, KeyFile: otherClient.KeyFile}, false},
+		{"server from another CA", server, otherClient, false},
+	}
+	for _, test := range tests {
+		err := roundTrip(t, test.server, test.client)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: Expected: ok=%v, got: %v", test.name, test.ok, err)
+		}
+	}
+}
+
+func TestKeyPermissions(t *testing.T) {
+	dir := t.TempDir()
+	if err := GenerateDev(dir, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "server-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected: %v, got: %v", os.FileMode(0600), info.Mode().Perm())
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	var c Config
+	if conf, err := c.Server(); conf != nil || err != nil {
+		t.Errorf("Expected: nil, nil, got: %v, %v", conf, err)
+	}
+	if _, err := (&Config{CertFile: "x"}).Server(); err == nil {
+		t.Error("Expected: error for a missing key, got: nil")
+	}
+}
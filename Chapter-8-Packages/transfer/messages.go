@@ -0,0 +1,59 @@
+// Package transfer sends files over a protocol.Conn in chunks. An
+// interrupted transfer resumes from the last chunk the receiver
+// acknowledged, and the receiver checks the finished file against the
+// sender's SHA-256 before keeping it.
+package transfer
+
+import (
+	"errors"
+
+	"packages/protocol"
+)
+
+// Message types, used as protocol.Message.Type. All are Requests from the
+// sender.
+const (
+	OfferType  = "transfer.offer"
+	ChunkType  = "transfer.chunk"
+	FinishType = "transfer.finish"
+)
+
+var (
+	ErrBadName      = errors.New("transfer: bad file name")
+	ErrBadOffset    = errors.New("transfer: chunk at unexpected offset")
+	ErrNoOffer      = errors.New("transfer: no transfer in progress for that file")
+	ErrBusy         = errors.New("transfer: another sender is transferring that file")
+	ErrHashMismatch = errors.New("transfer: received file does not match the sender's hash")
+)
+
+// Offer announces a file. The receiver answers with a Resume.
+type Offer struct {
+	Name string
+	Size int64
+	Hash []byte // SHA-256 of the whole file
+}
+
+// Resume tells the sender where to carry on from; 0 for a new transfer.
+type Resume struct {
+	Offset int64
+}
+
+// Chunk is a piece of the file starting at Offset. The receiver answers
+// with a Resume pointing past it.
+type Chunk struct {
+	Name   string
+	Offset int64
+	Data   []byte
+}
+
+// Finish asks the receiver to verify the file and keep it.
+type Finish struct {
+	Name string
+}
+
+func init() {
+	protocol.Register(Offer{})
+	protocol.Register(Resume{})
+	protocol.Register(Chunk{})
+	protocol.Register(Finish{})
+}
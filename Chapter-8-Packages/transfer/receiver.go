@@ -0,0 +1,201 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"packages/filehash"
+	"packages/protocol"
+)
+
+// Receiver is a protocol.Handler that stores offered files in a directory.
+// A file being received lives in <name>.part, with the offer it belongs to
+// in <name>.offer, until it is verified and renamed into place. Both
+// survive a restart, so a sender can resume after either end goes away.
+//
+// A file belongs to the connection that offered it until that connection
+// closes or the file is finished; a different offer of the same name in
+// the meantime fails with ErrBusy.
+type Receiver struct {
+	dir string
+
+	mu        sync.Mutex // guards transfers, not the files
+	transfers map[string]*transfer
+}
+
+// transfer is the state of one file name. Its lock is held across the
+// file I/O, so transfers of different files never wait for each other.
+type transfer struct {
+	mu    sync.Mutex
+	offer *Offer // nil when nothing is in progress
+	owner *protocol.Conn
+}
+
+// NewReceiver stores files in dir, which must exist.
+func NewReceiver(dir string) *Receiver {
+	return &Receiver{dir: dir, transfers: make(map[string]*transfer)}
+}
+
+func (r *Receiver) ServeMessage(c *protocol.Conn, m *protocol.Message) (interface{}, error) {
+	switch m.Type {
+	case OfferType:
+		req, _ := m.Payload.(Offer)
+		return r.offer(c, req)
+	case ChunkType:
+		req, _ := m.Payload.(Chunk)
+		return r.chunk(c, req)
+	case FinishType:
+		req, _ := m.Payload.(Finish)
+		return nil, r.finish(c, req.Name)
+	}
+	return nil, errors.New("transfer: unknown message " + m.Type)
+}
+
+// transfer returns the state for name, adding it if create is set.
+func (r *Receiver) transfer(name string, create bool) *transfer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.transfers[name]
+	if t == nil && create {
+		t = &transfer{}
+		r.transfers[name] = t
+	}
+	return t
+}
+
+// held returns the transfer of name if c offered it, locked.
+func (r *Receiver) held(c *protocol.Conn, name string) (*transfer, bool) {
+	t := r.transfer(name, false)
+	if t == nil {
+		return nil, false
+	}
+	t.mu.Lock()
+	if t.offer == nil || t.owner != c {
+		t.mu.Unlock()
+		return nil, false
+	}
+	return t, true
+}
+
+func closed(c *protocol.Conn) bool {
+	select {
+	case <-c.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func sameFile(a, b Offer) bool {
+	return a.Size == b.Size && bytes.Equal(a.Hash, b.Hash)
+}
+
+func validName(name string) bool {
+	return name != "" && name == filepath.Base(name) && !strings.HasPrefix(name, ".") &&
+		!strings.HasSuffix(name, ".part") && !strings.HasSuffix(name, ".offer")
+}
+
+func (r *Receiver) path(name string) string {
+	return filepath.Join(r.dir, name)
+}
+
+// offer starts a transfer, or resumes one if the same file was offered
+// before and part of it is already here.
+func (r *Receiver) offer(c *protocol.Conn, o Offer) (Resume, error) {
+	if !validName(o.Name) || o.Size < 0 {
+		return Resume{}, ErrBadName
+	}
+	t := r.transfer(o.Name, true)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.offer != nil && t.owner != c && !closed(t.owner) && !sameFile(*t.offer, o) {
+		return Resume{}, ErrBusy
+	}
+
+	part := r.path(o.Name) + ".part"
+	if prev, err := r.loadOffer(o.Name); err == nil && sameFile(prev, o) {
+		if info, err := os.Stat(part); err == nil && info.Size() <= o.Size {
+			t.offer, t.owner = &o, c
+			return Resume{Offset: info.Size()}, nil
+		}
+	}
+
+	bs, err := json.Marshal(o)
+	if err != nil {
+		return Resume{}, err
+	}
+	if err := os.WriteFile(r.path(o.Name)+".offer", bs, 0644); err != nil {
+		return Resume{}, err
+	}
+	if err := os.WriteFile(part, nil, 0644); err != nil {
+		return Resume{}, err
+	}
+	t.offer, t.owner = &o, c
+	return Resume{}, nil
+}
+
+func (r *Receiver) loadOffer(name string) (Offer, error) {
+	var o Offer
+	bs, err := os.ReadFile(r.path(name) + ".offer")
+	if err != nil {
+		return o, err
+	}
+	err = json.Unmarshal(bs, &o)
+	return o, err
+}
+
+// chunk appends data to the partial file. Chunks must arrive in order, so
+// the size of the partial file is always the resume offset.
+func (r *Receiver) chunk(conn *protocol.Conn, c Chunk) (Resume, error) {
+	t, ok := r.held(conn, c.Name)
+	if !ok {
+		return Resume{}, ErrNoOffer
+	}
+	defer t.mu.Unlock()
+	o := t.offer
+	f, err := os.OpenFile(r.path(c.Name)+".part", os.O_WRONLY, 0644)
+	if err != nil {
+		return Resume{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Resume{}, err
+	}
+	if c.Offset != info.Size() || c.Offset+int64(len(c.Data)) > o.Size {
+		return Resume{}, ErrBadOffset
+	}
+	if _, err := f.WriteAt(c.Data, c.Offset); err != nil {
+		return Resume{}, err
+	}
+	return Resume{Offset: c.Offset + int64(len(c.Data))}, nil
+}
+
+// finish checks the whole file against the offered hash. A file that does
+// not match is thrown away, so the next offer starts over.
+func (r *Receiver) finish(c *protocol.Conn, name string) error {
+	t, ok := r.held(c, name)
+	if !ok {
+		return ErrNoOffer
+	}
+	defer t.mu.Unlock()
+	o := t.offer
+	part := r.path(name) + ".part"
+	sum, err := filehash.File(part, sha256.New())
+	if err != nil {
+		return err
+	}
+	t.offer, t.owner = nil, nil
+	os.Remove(r.path(name) + ".offer")
+	if !bytes.Equal(sum, o.Hash) {
+		os.Remove(part)
+		return ErrHashMismatch
+	}
+	return os.Rename(part, r.path(name))
+}
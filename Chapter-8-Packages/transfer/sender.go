@@ -0,0 +1,171 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"packages/filehash"
+	"packages/protocol"
+)
+
+// Options configures Send. Zero values pick the defaults.
+type Options struct {
+	// Name is what the receiver calls the file. Defaults to the base name
+	// of the path being sent.
+	Name string
+	// ChunkSize is the most data sent in one message. Defaults to 256KB.
+	ChunkSize int
+	// Retries is how many times to reconnect and resume after the
+	// connection fails. Defaults to 5; negative means never.
+	Retries int
+	// Backoff is the pause before each reconnect. Defaults to half a
+	// second.
+	Backoff time.Duration
+	// Progress, if set, is called with the receiver's offset after the
+	// offer and after every acknowledged chunk.
+	Progress func(offset, size int64)
+}
+
+func (o *Options) setDefaults(path string) {
+	if o.Name == "" {
+		o.Name = filepath.Base(path)
+	}
+	if o.ChunkSize <= 0 {
+		o.ChunkSize = 256 << 10
+	}
+	if o.Retries == 0 {
+		o.Retries = 5
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = 500 * time.Millisecond
+	}
+}
+
+// SendFile sends the file at path to the receiver at addr. If the
+// connection fails it dials again and resumes, up to opts.Retries times.
+// Errors from the receiver, such as ErrHashMismatch, are not retried.
+func SendFile(ctx context.Context, addr, path string, popts protocol.Options, opts Options) error {
+	opts.setDefaults(path)
+	hash, err := filehash.File(path, sha256.New())
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		var conn *protocol.Conn
+		conn, err = protocol.Dial(addr, nil, popts)
+		if err == nil {
+			err = send(ctx, conn, path, hash, opts)
+			conn.Close()
+		}
+		if err == nil || isFinal(err) || ctx.Err() != nil || attempt >= opts.Retries {
+			return err
+		}
+		select {
+		case <-time.After(opts.Backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Send sends the file at path over conn once, resuming wherever the
+// receiver says it got to.
+func Send(ctx context.Context, conn *protocol.Conn, path string, opts Options) error {
+	opts.setDefaults(path)
+	hash, err := filehash.File(path, sha256.New())
+	if err != nil {
+		return err
+	}
+	return send(ctx, conn, path, hash, opts)
+}
+
+func send(ctx context.Context, conn *protocol.Conn, path string, hash []byte, opts Options) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	offset, err := resume(ctx, conn, OfferType, Offer{Name: opts.Name, Size: size, Hash: hash})
+	if err != nil {
+		return err
+	}
+	if opts.Progress != nil {
+		opts.Progress(offset, size)
+	}
+
+	buf := make([]byte, opts.ChunkSize)
+	for offset < size {
+		n, err := f.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			// The file shrank since it was hashed.
+			return io.ErrUnexpectedEOF
+		}
+		offset, err = resume(ctx, conn, ChunkType, Chunk{Name: opts.Name, Offset: offset, Data: buf[:n]})
+		if err != nil {
+			return err
+		}
+		if opts.Progress != nil {
+			opts.Progress(offset, size)
+		}
+	}
+	_, err = call(ctx, conn, FinishType, Finish{Name: opts.Name})
+	return err
+}
+
+// call turns the receiver's errors, which arrive as plain text, back into
+// the package's error values.
+func call(ctx context.Context, conn *protocol.Conn, typ string, payload interface{}) (interface{}, error) {
+	v, err := conn.Call(ctx, typ, payload)
+	if remote, ok := err.(protocol.RemoteError); ok {
+		for _, known := range []error{ErrBadName, ErrBadOffset, ErrNoOffer, ErrHashMismatch, ErrBusy} {
+			if string(remote) == known.Error() {
+				return nil, known
+			}
+		}
+	}
+	return v, err
+}
+
+// resume calls typ, which the receiver answers with the offset to carry
+// on from.
+func resume(ctx context.Context, conn *protocol.Conn, typ string, payload interface{}) (int64, error) {
+	v, err := call(ctx, conn, typ, payload)
+	if err != nil {
+		return 0, err
+	}
+	r, ok := v.(Resume)
+	if !ok {
+		return 0, fmt.Errorf("transfer: unexpected reply %T to %s", v, typ)
+	}
+	return r.Offset, nil
+}
+
+// isFinal reports whether err came from the receiver, which will answer
+// the same way next time.
+func isFinal(err error) bool {
+	switch err.(type) {
+	case protocol.RemoteError:
+		return true
+	}
+	// ErrBusy is not: the other sender may finish or go away.
+	switch err {
+	case ErrBadName, ErrBadOffset, ErrNoOffer, ErrHashMismatch:
+		return true
+	}
+	return false
+}
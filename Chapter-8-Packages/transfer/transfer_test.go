@@ -0,0 +1,247 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"concurrency/leakcheck"
+	"packages/protocol"
+)
+
+const chunk = 64 << 10
+
+func startReceiver(t *testing.T, h protocol.Handler) (*protocol.Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &protocol.Server{Handler: h}
+	go s.Serve(l)
+	return s, l.Addr().String()
+}
+
+func writeRandom(t *testing.T, size int) (string, []byte) {
+	data := make([]byte, size)
+	rand.Read(data)
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func expectFile(t *testing.T, dir string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(filepath.Join(dir, "data.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected: %d bytes sent, got: %d different bytes", len(want), len(got))
+	}
+	for _, leftover := range []string{"data.bin.part", "data.bin.offer"} {
+		if _, err := os.Stat(filepath.Join(dir, leftover)); !os.IsNotExist(err) {
+			t.Errorf("Expected: %s removed, got: %v", leftover, err)
+		}
+	}
+}
+
+// dropAfter closes the connection when the chunk after the first n
+// arrives, once.
+func dropAfter(r *Receiver, n int) protocol.Handler {
+	var mu sync.Mutex
+	chunks, dropped := 0, false
+	return protocol.HandlerFunc(func(c *protocol.Conn, m *protocol.Message) (interface{}, error) {
+		if m.Type == ChunkType {
+			mu.Lock()
+			chunks++
+			drop := chunks > n && !dropped
+			dropped = dropped || drop
+			mu.Unlock()
+			if drop {
+				c.Close()
+				return nil, nil
+			}
+		}
+		return r.ServeMessage(c, m)
+	})
+}
+
+func TestSendFile(t *testing.T) {
+	defer leakcheck.Check(t)()
+	dir := t.TempDir()
+	s, addr := startReceiver(t, NewReceiver(dir))
+	defer s.Close()
+
+	path, data := writeRandom(t, 10*chunk+123)
+	var last int64
+	opts := Options{ChunkSize: chunk, Progress: func(offset, size int64) { last = offset }}
+	if err := SendFile(context.Background(), addr, path, protocol.Options{}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if last != int64(len(data)) {
+		t.Errorf("Expected: progress up to %d, got: %d", len(data), last)
+	}
+	expectFile(t, dir, data)
+}
+
+func TestResumeAfterDisconnect(t *testing.T) {
+	defer leakcheck.Check(t)()
+	dir := t.TempDir()
+	s, addr := startReceiver(t, dropAfter(NewReceiver(dir), 3))
+	defer s.Close()
+
+	path, data := writeRandom(t, 8*chunk)
+	var offsets []int64
+	opts := Options{
+		ChunkSize: chunk,
+		Backoff:   10 * time.Millisecond,
+		Progress:  func(offset, size int64) { offsets = append(offsets, offset) },
+	}
+	if err := SendFile(context.Background(), addr, path, protocol.Options{}, opts); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, dir, data)
+
+	// Offer 0, three chunks, the lost fourth; then the second offer picks
+	// up after the third chunk.
+	if len(offsets) < 5 || offsets[4] != 3*chunk {
+		t.Errorf("Expected: resume at %d, got offsets: %v", 3*chunk, offsets)
+	}
+}
+
+func TestResumeAfterRestart(t *testing.T) {
+	defer leakcheck.Check(t)()
+	dir := t.TempDir()
+	path, data := writeRandom(t, 6*chunk)
+
+	s, addr := startReceiver(t, dropAfter(NewReceiver(dir), 2))
+	err := SendFile(context.Background(), addr, path, protocol.Options{}, Options{ChunkSize: chunk, Retries: -1})
+	s.Close()
+	if err == nil {
+		t.Fatal("Expected: error from dropped connection, got: nil")
+	}
+
+	// A new receiver on the same directory finds the partial file.
+	s, addr = startReceiver(t, NewReceiver(dir))
+	defer s.Close()
+	var first int64 = -1
+	opts := Options{ChunkSize: chunk, Progress: func(offset, size int64) {
+		if first < 0 {
+			first = offset
+		}
+	}}
+	if err := SendFile(context.Background(), addr, path, protocol.Options{}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if first != 2*chunk {
+		t.Errorf("Expected: resume at %d, got: %d", 2*chunk, first)
+	}
+	expectFile(t, dir, data)
+}
+
+func TestHashMismatch(t *testing.T) {
+	defer leakcheck.Check(t)()
+	dir := t.TempDir()
+	s, addr := startReceiver(t, NewReceiver(dir))
+	defer s.Close()
+	conn, err := protocol.Dial(addr, nil, protocol.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	if _, err := call(ctx, conn, OfferType, Offer{Name: "x", Size: 3, Hash: []byte("not the hash")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call(ctx, conn, ChunkType, Chunk{Name: "x", Offset: 1, Data: []byte("abc")}); err != ErrBadOffset {
+		t.Errorf("Expected: %v, got: %v", ErrBadOffset, err)
+	}
+	if _, err := call(ctx, conn, ChunkType, Chunk{Name: "x", Data: []byte("abc")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call(ctx, conn, FinishType, Finish{Name: "x"}); err != ErrHashMismatch {
+		t.Errorf("Expected: %v, got: %v", ErrHashMismatch, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("Expected: nothing kept, got: %v", entries)
+	}
+}
+
+// TestBusy offers a different file under a name another connection is
+// still sending, which must not disturb the first transfer.
+func TestBusy(t *testing.T) {
+	defer leakcheck.Check(t)()
+	dir := t.TempDir()
+	s, addr := startReceiver(t, NewReceiver(dir))
+	defer s.Close()
+	first, err := protocol.Dial(addr, nil, protocol.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := protocol.Dial(addr, nil, protocol.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	ctx := context.Background()
+	data := []byte("abcdef")
+	sum := sha256.Sum256(data)
+	if _, err := call(ctx, first, OfferType, Offer{Name: "data.bin", Size: 6, Hash: sum[:]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call(ctx, first, ChunkType, Chunk{Name: "data.bin", Data: data[:3]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call(ctx, second, OfferType, Offer{Name: "data.bin", Size: 6, Hash: []byte("other")}); err != ErrBusy {
+		t.Errorf("Expected: %v, got: %v", ErrBusy, err)
+	}
+	if _, err := call(ctx, second, ChunkType, Chunk{Name: "data.bin", Offset: 3, Data: []byte("xyz")}); err != ErrNoOffer {
+		t.Errorf("Expected: %v, got: %v", ErrNoOffer, err)
+	}
+	if _, err := call(ctx, first, ChunkType, Chunk{Name: "data.bin", Offset: 3, Data: data[3:]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call(ctx, first, FinishType, Finish{Name: "data.bin"}); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, dir, data)
+
+	// Once the first sender has gone, its unfinished file can be replaced.
+	if _, err := call(ctx, first, OfferType, Offer{Name: "data.bin", Size: 6, Hash: sum[:]}); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := call(ctx, second, OfferType, Offer{Name: "data.bin", Size: 3, Hash: []byte("other")})
+		if err == nil {
+			break
+		}
+		if err != ErrBusy || time.Now().After(deadline) {
+			t.Fatalf("Expected: offer taken over, got: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBadName(t *testing.T) {
+	r := NewReceiver(t.TempDir())
+	for _, name := range []string{"", "../x", "a/b", ".hidden", "x.part", "x.offer"} {
+		if _, err := r.offer(nil, Offer{Name: name}); err != ErrBadName {
+			t.Errorf("%q: Expected: %v, got: %v", name, ErrBadName, err)
+		}
+	}
+}
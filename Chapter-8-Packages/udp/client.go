@@ -0,0 +1,156 @@
+package udp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Options configures a Client. Zero values pick the defaults.
+type Options struct {
+	// RetryInterval is how long SendAcked waits for an ack before sending
+	// the datagram again. Defaults to 200ms.
+	RetryInterval time.Duration
+}
+
+// Client sends datagrams to one server, numbering them from 1.
+type Client struct {
+	conn *net.UDPConn
+	opts Options
+
+	mu      sync.Mutex
+	seq     uint32
+	pending map[uint32]chan struct{}
+	closed  bool
+	done    chan struct{}
+}
+
+// Dial returns a Client sending to the UDP address addr.
+func Dial(addr string, opts Options) (*Client, error) {
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = 200 * time.Millisecond
+	}
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:    conn,
+		opts:    opts,
+		pending: make(map[uint32]chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go c.readAcks()
+	return c, nil
+}
+
+func (c *Client) next() (uint32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, ErrClosed
+	}
+	c.seq++
+	return c.seq, nil
+}
+
+// Send sends payload once, without waiting to hear whether it arrived, and
+// returns its sequence number.
+func (c *Client) Send(payload []byte) (uint32, error) {
+	if len(payload) > MaxPayload {
+		return 0, ErrTooLarge
+	}
+	seq, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	_, err = c.conn.Write(encode(kindData, 0, seq, payload))
+	return seq, err
+}
+
+// SendAcked sends payload and resends it every RetryInterval until the
+// server acknowledges it or ctx is done. The server handles it at most
+// once however many copies arrive within its PeerTimeout.
+func (c *Client) SendAcked(ctx context.Context, payload []byte) (uint32, error) {
+	if len(payload) > MaxPayload {
+		return 0, ErrTooLarge
+	}
+	seq, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	acked := make(chan struct{})
+	c.mu.Lock()
+	c.pending[seq] = acked
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, seq)
+		c.mu.Unlock()
+	}()
+
+	datagram := encode(kindData, flagWantAck, seq, payload)
+	ticker := time.NewTicker(c.opts.RetryInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := c.conn.Write(datagram); err != nil {
+			return seq, err
+		}
+		select {
+		case <-acked:
+			return seq, nil
+		case <-ticker.C:
+		case <-ctx.Done():
+			return seq, ctx.Err()
+		case <-c.done:
+			return seq, ErrClosed
+		}
+	}
+}
+
+func (c *Client) readAcks() {
+	buf := make([]byte, headerSize+MaxPayload)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			c.mu.Lock()
+			closed := c.closed
+			c.mu.Unlock()
+			if closed {
+				return
+			}
+			// Nothing listening yet shows up as a read error on a
+			// connected socket; keep going and let SendAcked retry.
+			continue
+		}
+		kind, p, err := decode(buf[:n])
+		if err != nil || kind != kindAck {
+			continue
+		}
+		c.mu.Lock()
+		if ch := c.pending[p.Seq]; ch != nil {
+			close(ch)
+			delete(c.pending, p.Seq)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Close stops the client. SendAcked calls still waiting fail with
+// ErrClosed.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	c.mu.Unlock()
+	return c.conn.Close()
+}
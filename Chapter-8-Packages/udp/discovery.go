@@ -0,0 +1,179 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultGroup is the multicast group and port discovery uses unless told
+// otherwise. 239.255.0.0/16 is scoped to the local site.
+const DefaultGroup = "239.255.42.99:9999"
+
+// ErrNotFound is returned by Lookup when nothing answered in time.
+var ErrNotFound = errors.New("udp: service not found")
+
+// Discovery is two text datagrams:
+//
+//	client, to the group:      DISCOVER <name>
+//	server, back to the client: SERVICE <name> <addr>
+
+// Service is something reachable on the network under a name.
+type Service struct {
+	Name string
+	Addr string
+}
+
+// DiscoveryOptions configures Announce and Lookup. Zero values pick the
+// defaults.
+type DiscoveryOptions struct {
+	// Group is the multicast address and port. Defaults to DefaultGroup.
+	Group string
+	// Interface to join the group on. Defaults to the system's choice.
+	Interface *net.Interface
+	// RetryInterval is how often Lookup asks again. Defaults to 250ms.
+	RetryInterval time.Duration
+}
+
+func (o *DiscoveryOptions) setDefaults() {
+	if o.Group == "" {
+		o.Group = DefaultGroup
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = 250 * time.Millisecond
+	}
+}
+
+// Announcer answers discovery queries for one service until closed.
+type Announcer struct {
+	service Service
+	conn    *net.UDPConn
+	done    chan struct{}
+}
+
+// Announce joins the discovery group and answers queries for service. An
+// Addr without a host, such as ":8080", is answered with whichever local
+// IP address reaches the one asking.
+func Announce(service Service, opts DiscoveryOptions) (*Announcer, error) {
+	opts.setDefaults()
+	group, err := net.ResolveUDPAddr("udp4", opts.Group)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", opts.Interface, group)
+	if err != nil {
+		return nil, err
+	}
+	a := &Announcer{service: service, conn: conn, done: make(chan struct{})}
+	go a.serve()
+	return a, nil
+}
+
+func (a *Announcer) serve() {
+	defer close(a.done)
+	buf := make([]byte, 512)
+	for {
+		n, from, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		name, ok := parse(string(buf[:n]), "DISCOVER", 1)
+		if !ok || name[0] != a.service.Name {
+			continue
+		}
+		addr, err := advertised(a.service.Addr, from)
+		if err != nil {
+			continue
+		}
+		a.conn.WriteToUDP([]byte("SERVICE "+a.service.Name+" "+addr+"\n"), from)
+	}
+}
+
+// advertised fills in a missing host in addr with the local address used
+// to reach to.
+func advertised(addr string, to *net.UDPAddr) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return addr, nil
+	}
+	probe, err := net.DialUDP("udp", nil, to)
+	if err != nil {
+		return "", err
+	}
+	defer probe.Close()
+	local := probe.LocalAddr().(*net.UDPAddr)
+	return net.JoinHostPort(local.IP.String(), port), nil
+}
+
+// Close stops answering queries.
+func (a *Announcer) Close() error {
+	err := a.conn.Close()
+	<-a.done
+	return err
+}
+
+// Lookup asks the discovery group for name until something answers or ctx
+// is done, and returns the first answer.
+func Lookup(ctx context.Context, name string, opts DiscoveryOptions) (Service, error) {
+	opts.setDefaults()
+	group, err := net.ResolveUDPAddr("udp4", opts.Group)
+	if err != nil {
+		return Service{}, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return Service{}, err
+	}
+	defer conn.Close()
+
+	found := make(chan Service, 1)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			fields, ok := parse(string(buf[:n]), "SERVICE", 2)
+			if ok && fields[0] == name {
+				select {
+				case found <- Service{Name: name, Addr: fields[1]}:
+				default:
+				}
+			}
+		}
+	}()
+
+	query := []byte("DISCOVER " + name + "\n")
+	ticker := time.NewTicker(opts.RetryInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := conn.WriteToUDP(query, group); err != nil {
+			return Service{}, err
+		}
+		select {
+		case s := <-found:
+			return s, nil
+		case <-ticker.C:
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return Service{}, ErrNotFound
+			}
+			return Service{}, ctx.Err()
+		}
+	}
+}
+
+// parse splits "VERB field..." and checks the verb and field count.
+func parse(line, verb string, fields int) ([]string, bool) {
+	f := strings.Fields(line)
+	if len(f) != fields+1 || f[0] != verb {
+		return nil, false
+	}
+	return f[1:], true
+}
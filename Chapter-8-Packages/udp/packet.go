@@ -0,0 +1,90 @@
+// Package udp sends numbered datagrams, optionally acknowledged and
+// retransmitted until they are, and finds services on the local network
+// by multicast.
+package udp
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Every datagram starts with a six byte header:
+//
+//	kind byte | flags byte | seq uint32
+//
+// followed by the payload. An ack is a bare header echoing the seq.
+const (
+	kindData byte = 1
+	kindAck  byte = 2
+
+	flagWantAck byte = 1
+
+	headerSize = 6
+	// MaxPayload keeps a datagram within what IPv4 UDP can carry.
+	MaxPayload = 65507 - headerSize
+)
+
+var (
+	ErrTooLarge  = errors.New("udp: payload too large for one datagram")
+	ErrClosed    = errors.New("udp: closed")
+	errBadPacket = errors.New("udp: malformed datagram")
+)
+
+// Packet is a received datagram.
+type Packet struct {
+	Seq     uint32
+	Payload []byte
+	// WantAck is set when the sender waits for an acknowledgement. The
+	// server sends it after the handler returns.
+	WantAck bool
+}
+
+func encode(kind, flags byte, seq uint32, payload []byte) []byte {
+	b := make([]byte, headerSize+len(payload))
+	b[0] = kind
+	b[1] = flags
+	binary.BigEndian.PutUint32(b[2:6], seq)
+	copy(b[headerSize:], payload)
+	return b
+}
+
+func decode(b []byte) (kind byte, p Packet, err error) {
+	if len(b) < headerSize || (b[0] != kindData && b[0] != kindAck) {
+		return 0, Packet{}, errBadPacket
+	}
+	p.Seq = binary.BigEndian.Uint32(b[2:6])
+	p.WantAck = b[1]&flagWantAck != 0
+	p.Payload = b[headerSize:]
+	return b[0], p, nil
+}
+
+// window remembers which of the last 64 sequence numbers from one sender
+// have been seen, so retransmissions whose ack was lost are not handled
+// twice.
+type window struct {
+	max  uint32
+	seen uint64
+	last time.Time // when the newest fresh datagram arrived
+}
+
+// fresh reports whether seq has not been seen before, and records it.
+// Anything more than 64 behind the newest is treated as seen.
+func (w *window) fresh(seq uint32) bool {
+	if seq > w.max {
+		shift := seq - w.max
+		if shift >= 64 {
+			w.seen = 1
+		} else {
+			w.seen = w.seen<<shift | 1
+		}
+		w.max = seq
+		return true
+	}
+	diff := w.max - seq
+	if diff >= 64 || w.seen&(1<<diff) != 0 {
+		return false
+	}
+	w.seen |= 1 << diff
+	return true
+}
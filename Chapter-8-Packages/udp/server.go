@@ -0,0 +1,131 @@
+package udp
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Handler is called for every new datagram. Duplicates of recent ones are
+// acknowledged again but not handed to it.
+type Handler interface {
+	ServeDatagram(from net.Addr, p Packet)
+}
+
+// HandlerFunc lets an ordinary function be a Handler.
+type HandlerFunc func(from net.Addr, p Packet)
+
+func (f HandlerFunc) ServeDatagram(from net.Addr, p Packet) {
+	f(from, p)
+}
+
+// maxPeers bounds the duplicate windows kept; past it they are forgotten
+// and start again.
+const maxPeers = 4096
+
+// Server reads datagrams and hands them to Handler one at a time.
+type Server struct {
+	Handler Handler
+	// PeerTimeout is how long after a sender's last new datagram its
+	// duplicate window is forgotten, so that a sender which restarts and
+	// numbers from 1 again is heard. A retransmission arriving later than
+	// that is handled a second time. Defaults to ten seconds; negative
+	// keeps windows until maxPeers forces them out.
+	PeerTimeout time.Duration
+
+	mu     sync.Mutex
+	pc     net.PacketConn
+	closed bool
+	peers  map[string]*window
+}
+
+// ListenAndServe listens on the UDP address addr and calls Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(pc)
+}
+
+// Serve reads from pc until Close is called.
+func (s *Server) Serve(pc net.PacketConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		pc.Close()
+		return ErrClosed
+	}
+	s.pc = pc
+	s.peers = make(map[string]*window)
+	s.mu.Unlock()
+
+	buf := make([]byte, headerSize+MaxPayload)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			return err
+		}
+		kind, p, err := decode(buf[:n])
+		if err != nil || kind != kindData {
+			continue
+		}
+		if s.fresh(from, p.Seq) && s.Handler != nil {
+			p.Payload = append([]byte(nil), p.Payload...)
+			s.Handler.ServeDatagram(from, p)
+		}
+		if p.WantAck {
+			pc.WriteTo(encode(kindAck, 0, p.Seq, nil), from)
+		}
+	}
+}
+
+func (s *Server) fresh(from net.Addr, seq uint32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	timeout := s.PeerTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	now := time.Now()
+	w := s.peers[from.String()]
+	if w == nil || timeout > 0 && now.Sub(w.last) > timeout {
+		if w == nil && len(s.peers) >= maxPeers {
+			s.peers = make(map[string]*window)
+		}
+		w = &window{}
+		s.peers[from.String()] = w
+	}
+	if !w.fresh(seq) {
+		return false
+	}
+	w.last = now
+	return true
+}
+
+// Addr returns the address being served, or nil before Serve is called.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pc == nil {
+		return nil
+	}
+	return s.pc.LocalAddr()
+}
+
+// Close stops Serve.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.pc != nil {
+		return s.pc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package udp
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"concurrency/leakcheck"
+)
+
+func TestWindow(t *testing.T) {
+	type testPair struct {
+		seq   uint32
+		fresh bool
+	}
+	var w window
+	tests := []testPair{
+		{1, true}, {2, true}, {2, false}, {4, true}, {3, true}, {3, false},
+		{100, true}, {4, false}, {37, true}, {36, false}, {37, false}, {99, true},
+	}
+	for _, test := range tests {
+		if got := w.fresh(test.seq); got != test.fresh {
+			t.Errorf("seq %d: Expected: %v, got: %v", test.seq, test.fresh, got)
+		}
+	}
+}
+
+// lossy drops the first dropIn datagrams read and the first dropOut
+// written.
+type lossy struct {
+	net.PacketConn
+	mu      sync.Mutex
+	dropIn  int
+	dropOut int
+}
+
+func (l *lossy) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := l.PacketConn.ReadFrom(b)
+		l.mu.Lock()
+		drop := err == nil && l.dropIn > 0
+		if drop {
+			l.dropIn--
+		}
+		l.mu.Unlock()
+		if !drop {
+			return n, addr, err
+		}
+	}
+}
+
+func (l *lossy) WriteTo(b []byte, addr net.Addr) (int, error) {
+	l.mu.Lock()
+	drop := l.dropOut > 0
+	if drop {
+		l.dropOut--
+	}
+	l.mu.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return l.PacketConn.WriteTo(b, addr)
+}
+
+type recorder struct {
+	mu   sync.Mutex
+	got  []string
+	seqs []uint32
+}
+
+func (r *recorder) ServeDatagram(from net.Addr, p Packet) {
+	r.mu.Lock()
+	r.got = append(r.got, string(p.Payload))
+	r.seqs = append(r.seqs, p.Seq)
+	r.mu.Unlock()
+}
+
+func (r *recorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return fmt.Sprint(r.got, r.seqs)
+}
+
+func startServer(t *testing.T, dropIn, dropOut int) (*Server, *recorder, string) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	s := &Server{Handler: rec}
+	go s.Serve(&lossy{PacketConn: pc, dropIn: dropIn, dropOut: dropOut})
+	return s, rec, pc.LocalAddr().String()
+}
+
+func TestSendAcked(t *testing.T) {
+	defer leakcheck.Check(t)()
+	type testPair struct {
+		name            string
+		dropIn, dropOut int
+	}
+	tests := []testPair{
+		{"no loss", 0, 0},
+		{"lost datagrams", 2, 0},
+		// The server handles the first copy but its acks go missing;
+		// the retransmissions are acked without being handled again.
+		{"lost acks", 0, 2},
+	}
+	for _, test := range tests {
+		s, rec, addr := startServer(t, test.dropIn, test.dropOut)
+		c, err := Dial(addr, Options{RetryInterval: 20 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		for _, msg := range []string{"one", "two"} {
+			if _, err := c.SendAcked(ctx, []byte(msg)); err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+		}
+		cancel()
+		if got := rec.String(); got != "[one two] [1 2]" {
+			t.Errorf("%s: Expected: [one two] [1 2], got: %s", test.name, got)
+		}
+		c.Close()
+		s.Close()
+	}
+}
+
+func TestSend(t *testing.T) {
+	defer leakcheck.Check(t)()
+	s, rec, addr := startServer(t, 0, 0)
+	defer s.Close()
+	c, err := Dial(addr, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	seq, _ := c.Send([]byte("fire"))
+	if seq != 1 {
+		t.Errorf("Expected: %d, got: %d", 1, seq)
+	}
+	// On loopback the unacked datagram arrives before the acked one after it.
+	if _, err := c.SendAcked(context.Background(), []byte("forget")); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.String(); got != "[fire forget] [1 2]" {
+		t.Errorf("Expected: [fire forget] [1 2], got: %s", got)
+	}
+	if _, err := c.Send(make([]byte, MaxPayload+1)); err != ErrTooLarge {
+		t.Errorf("Expected: %v, got: %v", ErrTooLarge, err)
+	}
+}
+
+// TestSenderRestart sends from one address, then starts numbering from 1
+// again as a restarted sender would. Once the server has forgotten the
+// old window the datagram is handled, not just acknowledged.
+func TestSenderRestart(t *testing.T) {
+	defer leakcheck.Check(t)()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	s := &Server{Handler: rec, PeerTimeout: 100 * time.Millisecond}
+	go s.Serve(pc)
+	defer s.Close()
+
+	sender, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	send := func(seq uint32, msg string) {
+		t.Helper()
+		if _, err := sender.WriteTo(encode(kindData, flagWantAck, seq, []byte(msg)), pc.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, headerSize)
+		sender.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := sender.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ack, err := decode(buf[:n]); err != nil || ack.Seq != seq {
+			t.Fatalf("Expected: ack %d, got: %d %v", seq, ack.Seq, err)
+		}
+	}
+
+	for seq, msg := range []string{"one", "two", "three"} {
+		send(uint32(seq+1), msg)
+	}
+	send(1, "repeat") // a retransmission as far as the server can tell
+	time.Sleep(150 * time.Millisecond)
+	send(1, "restarted")
+	if got := rec.String(); got != "[one two three restarted] [1 2 3 1]" {
+		t.Errorf("Expected: [one two three restarted] [1 2 3 1], got: %s", got)
+	}
+}
+
+func TestSendAckedNoServer(t *testing.T) {
+	defer leakcheck.Check(t)()
+	pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	c, err := Dial(addr, Options{RetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := c.SendAcked(ctx, []byte("anyone?")); err != context.DeadlineExceeded {
+		t.Errorf("Expected: %v, got: %v", context.DeadlineExceeded, err)
+	}
+}
+
+// multicastInterface prefers loopback, as the tests only talk to
+// themselves, and falls back to the system's choice where loopback cannot
+// do multicast (Linux).
+func multicastInterface() *net.Interface {
+	ifaces, _ := net.Interfaces()
+	for i := range ifaces {
+		f := ifaces[i].Flags
+		if f&net.FlagLoopback != 0 && f&net.FlagMulticast != 0 && f&net.FlagUp != 0 {
+			return &ifaces[i]
+		}
+	}
+	return nil
+}
+
+func TestDiscovery(t *testing.T) {
+	defer leakcheck.Check(t)()
+	opts := DiscoveryOptions{
+		// A port of its own, so a running demo does not answer.
+		Group:         fmt.Sprintf("239.255.42.99:%d", 20000+rand.Intn(20000)),
+		Interface:     multicastInterface(),
+		RetryInterval: 50 * time.Millisecond,
+	}
+	a, err := Announce(Service{Name: "echo", Addr: ":8080"}, opts)
+	if err != nil {
+		t.Skip("multicast not available:", err)
+	}
+	defer a.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	s, err := Lookup(ctx, "echo", opts)
+	if err == ErrNotFound {
+		t.Skip("multicast not delivered on this host")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(s.Addr)
+	if port != "8080" || host == "" || strings.HasPrefix(host, "0.") {
+		t.Errorf("Expected: a reachable host with port 8080, got: %s", s.Addr)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := Lookup(ctx, "nothing", opts); err != ErrNotFound {
+		t.Errorf("Expected: %v, got: %v", ErrNotFound, err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"packages/udp"
+	"time"
+)
+
+// The UDP counterpart of tcp_servers.go. Datagrams are numbered; those
+// sent with -ack are resent until the server acknowledges them.
+
+func server(pc net.PacketConn) {
+	srv := &udp.Server{Handler: udp.HandlerFunc(handleDatagram)}
+	err := srv.Serve(pc)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func handleDatagram(from net.Addr, p udp.Packet) {
+	fmt.Printf("Received #%d from %v: %s (ack: %v)\n", p.Seq, from, p.Payload, p.WantAck)
+}
+
+func client(addr string, ack bool) {
+	c, err := udp.Dial(addr, udp.Options{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer c.Close()
+
+	for i := 1; i <= 3; i++ {
+		msg := []byte(fmt.Sprint("hello world #", i))
+		if !ack {
+			_, err = c.Send(msg)
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			var seq uint32
+			seq, err = c.SendAcked(ctx, msg)
+			cancel()
+			if err == nil {
+				fmt.Println("Acknowledged", seq)
+			}
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func main() {
+	ack := flag.Bool("ack", false, "Wait for each datagram to be acknowledged")
+	flag.Parse()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:8080")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	go server(pc)
+	go client("127.0.0.1:8080", *ack)
+	var input string
+	fmt.Scanln(&input)
+}
@@ -0,0 +1,153 @@
+// Package views renders pages with html/template. Each page is parsed
+// together with every layout and partial, so a page fills in the blocks a
+// layout leaves open and may use any partial; all output is escaped for
+// its context in the HTML.
+package views
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+var ErrNoPage = errors.New("views: no such page")
+
+// Options configures a Renderer. Zero values pick the defaults.
+type Options struct {
+	// FS holds the templates.
+	FS fs.FS
+	// Layouts, Partials and Pages are the glob patterns for each kind of
+	// template. They default to layouts/*.html, partials/*.html and
+	// pages/*.html.
+	Layouts, Partials, Pages string
+	// Layout is the template executed to render a page. Defaults to
+	// "base".
+	Layout string
+	Funcs  template.FuncMap
+	// Reload parses the templates again on every render, so edits show
+	// up without a restart. Meant for development.
+	Reload bool
+}
+
+func (o *Options) setDefaults() {
+	if o.Layouts == "" {
+		o.Layouts = "layouts/*.html"
+	}
+	if o.Partials == "" {
+		o.Partials = "partials/*.html"
+	}
+	if o.Pages == "" {
+		o.Pages = "pages/*.html"
+	}
+	if o.Layout == "" {
+		o.Layout = "base"
+	}
+}
+
+// Renderer renders the pages found in Options.FS, each by the name of its
+// file without the extension.
+type Renderer struct {
+	opts Options
+
+	mu    sync.RWMutex
+	pages map[string]*template.Template
+}
+
+// New parses every template, so that mistakes show up at start even when
+// reloading.
+func New(opts Options) (*Renderer, error) {
+	opts.setDefaults()
+	r := &Renderer{opts: opts}
+	pages, err := r.parse()
+	if err != nil {
+		return nil, err
+	}
+	r.pages = pages
+	return r, nil
+}
+
+// parse reads the layouts and partials once and clones them for each page.
+func (r *Renderer) parse() (map[string]*template.Template, error) {
+	shared := template.New("").Funcs(r.opts.Funcs)
+	for _, pattern := range []string{r.opts.Layouts, r.opts.Partials} {
+		names, err := fs.Glob(r.opts.FS, pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) > 0 {
+			if shared, err = shared.ParseFS(r.opts.FS, names...); err != nil {
+				return nil, err
+			}
+		}
+	}
+	names, err := fs.Glob(r.opts.FS, r.opts.Pages)
+	if err != nil {
+		return nil, err
+	}
+	pages := make(map[string]*template.Template)
+	for _, name := range names {
+		t, err := shared.Clone()
+		if err != nil {
+			return nil, err
+		}
+		// Parsed last, the page's definitions replace the layout's blocks.
+		if t, err = t.ParseFS(r.opts.FS, name); err != nil {
+			return nil, err
+		}
+		if t.Lookup(r.opts.Layout) == nil {
+			return nil, errors.New("views: " + name + ": no template " + r.opts.Layout)
+		}
+		base := path.Base(name)
+		pages[strings.TrimSuffix(base, path.Ext(base))] = t
+	}
+	return pages, nil
+}
+
+func (r *Renderer) page(name string) (*template.Template, error) {
+	if r.opts.Reload {
+		pages, err := r.parse()
+		if err != nil {
+			return nil, err
+		}
+		r.mu.Lock()
+		r.pages = pages
+		r.mu.Unlock()
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.pages[name]
+	if !ok {
+		return nil, ErrNoPage
+	}
+	return t, nil
+}
+
+// Execute writes the page, rendered with data, to w.
+func (r *Renderer) Execute(w io.Writer, page string, data interface{}) error {
+	t, err := r.page(page)
+	if err != nil {
+		return err
+	}
+	return t.ExecuteTemplate(w, r.opts.Layout, data)
+}
+
+// Render answers with the page and status. The page is rendered in full
+// before anything is written, so a failing template becomes a plain 500
+// rather than half a page.
+func (r *Renderer) Render(w http.ResponseWriter, status int, page string, data interface{}) error {
+	var buf bytes.Buffer
+	if err := r.Execute(&buf, page, data); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,103 @@
+package views
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func files() fstest.MapFS {
+	return fstest.MapFS{
+		"layouts/base.html":  {Data: []byte(`{{define "base"}}<title>{{block "title" .}}Site{{end}}</title>{{template "nav"}}{{block "content" .}}{{end}}{{end}}`)},
+		"partials/nav.html":  {Data: []byte(`{{define "nav"}}<nav/>{{end}}`)},
+		"pages/hello.html":   {Data: []byte(`{{define "title"}}Hello{{end}}{{define "content"}}<p>{{.}}</p>{{end}}`)},
+		"pages/plain.html":   {Data: []byte(`{{define "content"}}<a href="/?q={{.}}">{{shout .}}</a>{{end}}`)},
+		"pages/broken.html":  {Data: []byte(`{{define "content"}}{{.Missing}}{{end}}`)},
+		"pages/readme.txt":   {Data: []byte(`not a page`)},
+		"partials/notes.txt": {Data: []byte(`{{define "nav"}}ignored{{end}}`)},
+	}
+}
+
+var funcs = map[string]interface{}{"shout": strings.ToUpper}
+
+func TestExecute(t *testing.T) {
+	r, err := New(Options{FS: files(), Funcs: funcs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type testPair struct {
+		page string
+		data interface{}
+		want string
+	}
+	tests := []testPair{
+		{"hello", "world", "<title>Hello</title><nav/><p>world</p>"},
+		// Escaped for where it lands: text, then a URL.
+		{"hello", "<b>&</b>", "<title>Hello</title><nav/><p>&lt;b&gt;&amp;&lt;/b&gt;</p>"},
+		{"plain", "a b&c", `<title>Site</title><nav/><a href="/?q=a%20b%26c">A B&amp;C</a>`},
+	}
+	for _, test := range tests {
+		var b strings.Builder
+		if err := r.Execute(&b, test.page, test.data); err != nil || b.String() != test.want {
+			t.Errorf("%s: Expected: %s <nil>, got: %s %v", test.page, test.want, b.String(), err)
+		}
+	}
+	if err := r.Execute(&strings.Builder{}, "readme", nil); err != ErrNoPage {
+		t.Errorf("Expected: %v, got: %v", ErrNoPage, err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	r, _ := New(Options{FS: files(), Funcs: funcs})
+	w := httptest.NewRecorder()
+	r.Render(w, 201, "hello", "x")
+	if w.Code != 201 || w.Header().Get("Content-Type") != "text/html; charset=utf-8" || w.Body.String() != "<title>Hello</title><nav/><p>x</p>" {
+		t.Errorf("Expected: 201 html page, got: %d %q %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+
+	// Nothing of a failed page is sent.
+	w = httptest.NewRecorder()
+	if err := r.Render(w, 200, "broken", "not a struct"); err == nil {
+		t.Errorf("Expected: an error, got: %v", err)
+	}
+	if w.Code != 500 || strings.Contains(w.Body.String(), "<title>") {
+		t.Errorf("Expected: a bare 500, got: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestReload(t *testing.T) {
+	fsys := files()
+	fixed, _ := New(Options{FS: fsys, Funcs: funcs})
+	live, _ := New(Options{FS: fsys, Funcs: funcs, Reload: true})
+	fsys["partials/nav.html"] = &fstest.MapFile{Data: []byte(`{{define "nav"}}<nav>new</nav>{{end}}`)}
+	fsys["pages/added.html"] = &fstest.MapFile{Data: []byte(`{{define "content"}}added{{end}}`)}
+
+	var b strings.Builder
+	fixed.Execute(&b, "hello", "x")
+	if want := "<title>Hello</title><nav/><p>x</p>"; b.String() != want {
+		t.Errorf("Expected: %s, got: %s", want, b.String())
+	}
+	b.Reset()
+	live.Execute(&b, "hello", "x")
+	if want := "<title>Hello</title><nav>new</nav><p>x</p>"; b.String() != want {
+		t.Errorf("Expected: %s, got: %s", want, b.String())
+	}
+	if err := live.Execute(&strings.Builder{}, "added", nil); err != nil {
+		t.Errorf("Expected: %v, got: %v", nil, err)
+	}
+	if err := fixed.Execute(&strings.Builder{}, "added", nil); err != ErrNoPage {
+		t.Errorf("Expected: %v, got: %v", ErrNoPage, err)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	bad := files()
+	bad["pages/bad.html"] = &fstest.MapFile{Data: []byte(`{{define "content"}}{{end`)}
+	if _, err := New(Options{FS: bad, Funcs: funcs}); err == nil {
+		t.Errorf("Expected: a parse error, got: %v", err)
+	}
+	if _, err := New(Options{FS: files(), Funcs: funcs, Layout: "other"}); err == nil {
+		t.Errorf("Expected: a missing layout error, got: %v", err)
+	}
+}
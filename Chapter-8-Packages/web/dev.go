@@ -0,0 +1,31 @@
+//go:build dev
+
+package web
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Dev reports whether the templates and static files are read from disk.
+const Dev = true
+
+// Templates returns the templates, with layouts/, partials/ and pages/ at
+// the top.
+func Templates() fs.FS {
+	return os.DirFS(filepath.Join(dir(), "templates"))
+}
+
+// Static returns the files served under /assets/.
+func Static() fs.FS {
+	return os.DirFS(filepath.Join(dir(), "static"))
+}
+
+// dir is the source directory of this package, wherever the server is run
+// from.
+func dir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}
@@ -0,0 +1,33 @@
+//go:build !dev
+
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// Dev reports whether the templates and static files are read from disk.
+const Dev = false
+
+//go:embed templates static
+var files embed.FS
+
+// Templates returns the templates, with layouts/, partials/ and pages/ at
+// the top.
+func Templates() fs.FS {
+	return sub("templates")
+}
+
+// Static returns the files served under /assets/.
+func Static() fs.FS {
+	return sub("static")
+}
+
+func sub(dir string) fs.FS {
+	fsys, err := fs.Sub(files, dir)
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
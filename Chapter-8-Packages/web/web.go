@@ -0,0 +1,9 @@
+// Package web holds the templates and static files of the HTTP server in
+// http_servers.go. Normal builds embed them in the binary; building with
+// -tags dev reads them from this directory instead, so they can be edited
+// while the server runs.
+package web
+
+// The precompressed copies are served to clients that take gzip; remake
+// them after editing.
+//go:generate gzip -9 -k -n -f static/style.css
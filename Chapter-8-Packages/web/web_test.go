@@ -0,0 +1,31 @@
+package web
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+
+	"packages/views"
+)
+
+func TestTemplates(t *testing.T) {
+	r, err := views.New(views.Options{FS: Templates()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := r.Execute(&b, "hello", struct{ Name string }{"<Gopher>"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "<h1>Hello &lt;Gopher&gt;</h1>") {
+		t.Errorf("Expected: an escaped greeting, got: %s", b.String())
+	}
+}
+
+func TestStatic(t *testing.T) {
+	for _, name := range []string{"style.css", "style.css.gz"} {
+		if _, err := fs.Stat(Static(), name); err != nil {
+			t.Errorf("Expected: %s, got: %v", name, err)
+		}
+	}
+}